@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+	"mnctech-restapi/cmd/rest-api/database"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"os"
+)
+
+// ledger-check verifies the ledger invariants against the database configured by
+// the DB_* environment variables and exits with status 1 when any is broken.
+func main() {
+	db := database.ConnectDB()
+	defer database.CloseDBConnection(db)
+
+	violations, err := ledger.CheckInvariants(db)
+	if err != nil {
+		log.Fatalf("Failed to check the ledger: %v", err)
+	}
+
+	for _, violation := range violations {
+		log.Println(violation)
+	}
+	if len(violations) > 0 {
+		log.Printf("Ledger check failed with %d violation(s)", len(violations))
+		os.Exit(1)
+	}
+	log.Println("Ledger check passed")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"mnctech-restapi/cmd/rest-api/database"
+	"mnctech-restapi/cmd/rest-api/handlers"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// merchant-settlement creates the settlement batches of all merchants for a business
+// date, yesterday by default. Run it once a day after midnight; running it again for
+// the same date settles only the merchants that failed before.
+func main() {
+	date := flag.String("date", time.Now().AddDate(0, 0, -1).Format("2006-01-02"), "business date to settle (YYYY-MM-DD)")
+	flag.Parse()
+
+	businessDate, err := time.ParseInLocation("2006-01-02", *date, time.Local)
+	if err != nil {
+		log.Fatalf("Invalid date %q: %v", *date, err)
+	}
+
+	db := database.ConnectDB()
+	defer database.CloseDBConnection(db)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	appHandler := &handlers.AppHandler{DB: db}
+	settled, err := appHandler.SettleMerchants(ctx, businessDate)
+	log.Printf("Created %d settlement(s) for %s", settled, businessDate.Format("2006-01-02"))
+	if err != nil {
+		log.Printf("Settlement failed: %v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mnctech-restapi/cmd/rest-api/broker"
+	"mnctech-restapi/cmd/rest-api/config"
+	"mnctech-restapi/cmd/rest-api/database"
+	"mnctech-restapi/cmd/rest-api/outbox"
+	"os/signal"
+	"syscall"
+)
+
+// outbox-relay publishes the messages the Rest API writes to the outbox table.
+func main() {
+	db := database.ConnectDB()
+	defer database.CloseDBConnection(db)
+
+	messageBroker, err := broker.Connect()
+	if err != nil {
+		log.Fatalf("Failed to connect to the broker: %v", err)
+	}
+	defer messageBroker.Close()
+
+	relay := outbox.DefaultRelay(db, messageBroker)
+	relay.BatchSize = config.GetEnvInt("OUTBOX_BATCH_SIZE", relay.BatchSize)
+	relay.Interval = config.GetEnvDuration("OUTBOX_POLL_INTERVAL", relay.Interval)
+	relay.Retention = config.GetEnvDuration("OUTBOX_RETENTION", relay.Retention)
+	relay.MaxAttempts = config.GetEnvInt("OUTBOX_MAX_ATTEMPTS", relay.MaxAttempts)
+	relay.RetryDelay = config.GetEnvDuration("OUTBOX_RETRY_DELAY", relay.RetryDelay)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Println("Relaying outbox messages")
+	if err := relay.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatalf("Stopped relaying: %v", err)
+	}
+	log.Println("Outbox relay stopped")
+}
@@ -4,9 +4,22 @@ import "github.com/golang-jwt/jwt/v5"
 
 type ContextKey string
 
-const UserIDKey ContextKey = "uid"
+const (
+	UserIDKey     ContextKey = "uid"
+	SessionIDKey  ContextKey = "sid"
+	MerchantIDKey ContextKey = "mid" // UID of the merchant authenticated by API key
+)
 
+// Roles of a User.
+const (
+	RoleUser  = "USER"
+	RoleAdmin = "ADMIN"
+)
+
+// CustomClaims are the claims carried by access and refresh tokens.
+// The embedded RegisteredClaims.ID is the jti of the individual token.
 type CustomClaims struct {
-	UID string `json:"uid"`
+	UID       string `json:"uid"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is a single key loaded by the KeyManager, identified by its kid.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer    // Nil for keys that are only kept to verify tokens signed before a rotation
+	Public  crypto.PublicKey // Always set
+}
+
+// KeyManager signs access tokens with the active private key and verifies them
+// against every loaded key, so tokens signed before a rotation stay valid.
+type KeyManager struct {
+	signingKey *Key
+	keys       map[string]*Key
+}
+
+// JWK is the public part of a key as published in the JWKS document.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA public exponent
+	Crv string `json:"crv,omitempty"` // Ed25519 curve name
+	X   string `json:"x,omitempty"`   // Ed25519 public key
+}
+
+// JWKSet is the JSON Web Key Set served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// LoadKeyManager loads every "<kid>.pem" file in dir. Files may hold an RSA or
+// Ed25519 private key, or only a public key for verification. signingKID selects
+// the private key used to sign; it may be empty when exactly one private key exists.
+func LoadKeyManager(dir, signingKID string) (*KeyManager, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no signing keys found in %q", dir)
+	}
+
+	km := &KeyManager{keys: map[string]*Key{}}
+	var privateKeys []*Key
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := loadKey(path, kid)
+		if err != nil {
+			return nil, fmt.Errorf("load key %q: %w", path, err)
+		}
+		km.keys[kid] = key
+		if key.Private != nil {
+			privateKeys = append(privateKeys, key)
+		}
+	}
+
+	switch {
+	case signingKID != "":
+		key, ok := km.keys[signingKID]
+		if !ok {
+			return nil, fmt.Errorf("signing key %q not found in %q", signingKID, dir)
+		}
+		if key.Private == nil {
+			return nil, fmt.Errorf("signing key %q has no private key", signingKID)
+		}
+		km.signingKey = key
+	case len(privateKeys) == 1:
+		km.signingKey = privateKeys[0]
+	case len(privateKeys) == 0:
+		return nil, fmt.Errorf("no private key found in %q", dir)
+	default:
+		return nil, errors.New("several private keys found, set the signing key ID")
+	}
+
+	return km, nil
+}
+
+// loadKey parses a PEM encoded RSA or Ed25519 key.
+func loadKey(path, kid string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
+
+// SigningKeyID returns the kid of the key new tokens are signed with.
+func (km *KeyManager) SigningKeyID() string {
+	return km.signingKey.ID
+}
+
+// Sign signs the claims with the active signing key and sets the kid header.
+func (km *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(km.signingKey.Method, claims)
+	token.Header["kid"] = km.signingKey.ID
+	return token.SignedString(km.signingKey.Private)
+}
+
+// Keyfunc resolves the verification key of a token from its kid header.
+// It is meant to be passed to jwt.Parse and friends.
+func (km *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := km.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+// JWKS returns the public keys of every loaded key.
+func (km *KeyManager) JWKS() JWKSet {
+	kids := make([]string, 0, len(km.keys))
+	for kid := range km.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, kid := range kids {
+		key := km.keys[kid]
+		jwk := JWK{Use: "sig", Kid: kid, Alg: key.Method.Alg()}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
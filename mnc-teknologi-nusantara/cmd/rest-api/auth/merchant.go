@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"mnctech-restapi/cmd/rest-api/models"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidAPIKey is returned by MerchantByAPIKey for a missing, malformed or unknown key.
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// HashToken returns the hash under which an opaque token or API key secret is stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// MerchantByAPIKey returns the merchant owning an API key of the form
+// "<key id>.<secret>".
+func MerchantByAPIKey(db *gorm.DB, key string) (models.Merchant, error) {
+	var merchant models.Merchant
+	keyID, secret, ok := strings.Cut(key, ".")
+	if !ok || keyID == "" || secret == "" {
+		return merchant, ErrInvalidAPIKey
+	}
+	err := db.Where("api_key_id = ?", keyID).First(&merchant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return merchant, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return merchant, err
+	}
+	if subtle.ConstantTimeCompare([]byte(HashToken(secret)), []byte(merchant.APIKeyHash)) != 1 {
+		return merchant, ErrInvalidAPIKey
+	}
+	return merchant, nil
+}
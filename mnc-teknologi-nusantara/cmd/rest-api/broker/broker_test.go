@@ -0,0 +1,33 @@
+package broker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        RetryPolicy
+		attempt       int
+		wantExhausted bool
+		wantDelay     time.Duration
+	}{
+		{name: "fixed delay", policy: RetryPolicy{MaxAttempts: 5, Delay: time.Second}, attempt: 4, wantDelay: time.Second},
+		{name: "last attempt", policy: RetryPolicy{MaxAttempts: 5, Delay: time.Second}, attempt: 5, wantExhausted: true, wantDelay: time.Second},
+		{name: "forever", policy: RetryPolicy{Delay: time.Second}, attempt: 1000, wantDelay: time.Second},
+		{name: "first backoff", policy: RetryPolicy{Delay: time.Second, MaxDelay: time.Minute}, attempt: 1, wantDelay: time.Second},
+		{name: "doubled backoff", policy: RetryPolicy{Delay: time.Second, MaxDelay: time.Minute}, attempt: 4, wantDelay: 8 * time.Second},
+		{name: "capped backoff", policy: RetryPolicy{Delay: time.Second, MaxDelay: time.Minute}, attempt: 1000, wantDelay: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.exhausted(tt.attempt); got != tt.wantExhausted {
+				t.Errorf("got exhausted %v, want %v", got, tt.wantExhausted)
+			}
+			if got := tt.policy.retryDelay(tt.attempt); got != tt.wantDelay {
+				t.Errorf("got delay %s, want %s", got, tt.wantDelay)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// memoryQueueSize is the number of messages a queue of the Memory broker buffers.
+const memoryQueueSize = 1024
+
+var ErrClosed = errors.New("broker is closed")
+
+// Memory is an in-process Broker for tests and local development. Messages are
+// lost when the process exits.
+type Memory struct {
+	mu     sync.Mutex
+	queues map[string]chan Message
+	closed bool
+}
+
+// NewMemory returns an empty in-memory broker.
+func NewMemory() *Memory {
+	return &Memory{queues: map[string]chan Message{}}
+}
+
+func (b *Memory) queue(name string) (chan Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	q, ok := b.queues[name]
+	if !ok {
+		q = make(chan Message, memoryQueueSize)
+		b.queues[name] = q
+	}
+	return q, nil
+}
+
+// Publish adds the message to the queue.
+func (b *Memory) Publish(ctx context.Context, queue string, msg Message) error {
+	q, err := b.queue(queue)
+	if err != nil {
+		return err
+	}
+	if msg.Attempt == 0 {
+		msg.Attempt = 1
+	}
+	select {
+	case q <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// PublishEvent adds the message to the queue named EventQueue(routingKey).
+func (b *Memory) PublishEvent(ctx context.Context, routingKey string, msg Message) error {
+	return b.Publish(ctx, EventQueue(routingKey), msg)
+}
+
+// EventQueue returns the queue the Memory broker keeps events with the routing key in.
+func EventQueue(routingKey string) string {
+	return EventsExchange + "." + routingKey
+}
+
+// Consume delivers the messages of the queue to handler until ctx is done.
+func (b *Memory) Consume(ctx context.Context, queue string, policy RetryPolicy, handler Handler) error {
+	q, err := b.queue(queue)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-q:
+			if err := handler(ctx, msg); err == nil {
+				continue
+			}
+			// Published aside, so a full queue cannot block the consumer that drains it
+			if policy.exhausted(msg.Attempt) {
+				go b.Publish(ctx, DeadLetterQueue(queue), msg)
+				continue
+			}
+			delay := policy.retryDelay(msg.Attempt)
+			msg.Attempt++
+			time.AfterFunc(delay, func() {
+				b.Publish(ctx, queue, msg)
+			})
+		}
+	}
+}
+
+// Len returns the number of messages waiting in the queue.
+func (b *Memory) Len(queue string) int {
+	q, err := b.queue(queue)
+	if err != nil {
+		return 0
+	}
+	return len(q)
+}
+
+// Close makes further publishing fail.
+func (b *Memory) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
@@ -0,0 +1,132 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMemoryRetriesFailedMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := NewMemory()
+
+	var attempts []int
+	done := make(chan struct{})
+	go b.Consume(ctx, "jobs", RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond}, func(ctx context.Context, msg Message) error {
+		attempts = append(attempts, msg.Attempt)
+		if msg.Attempt < 3 {
+			return errors.New("not yet")
+		}
+		close(done)
+		return nil
+	})
+
+	if err := b.Publish(ctx, "jobs", Message{ID: "1", Body: []byte("{}")}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("message not handled, attempts %v", attempts)
+	}
+
+	if len(attempts) != 3 || attempts[0] != 1 || attempts[1] != 2 || attempts[2] != 3 {
+		t.Errorf("got attempts %v, want [1 2 3]", attempts)
+	}
+	if n := b.Len(DeadLetterQueue("jobs")); n != 0 {
+		t.Errorf("got %d dead-lettered messages, want 0", n)
+	}
+}
+
+func TestMemoryDeadLettersAfterMaxAttempts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := NewMemory()
+
+	var calls atomic.Int32
+	go b.Consume(ctx, "jobs", RetryPolicy{MaxAttempts: 2, Delay: time.Millisecond}, func(ctx context.Context, msg Message) error {
+		calls.Add(1)
+		return errors.New("always fails")
+	})
+
+	if err := b.Publish(ctx, "jobs", Message{ID: "1", Body: []byte("payload")}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	waitFor(t, func() bool { return b.Len(DeadLetterQueue("jobs")) == 1 })
+
+	if n := calls.Load(); n != 2 {
+		t.Errorf("got %d attempts, want 2", n)
+	}
+
+	// The dead-lettered message keeps its ID and body and reaches a consumer of the DLQ
+	received := make(chan Message, 1)
+	go b.Consume(ctx, DeadLetterQueue("jobs"), RetryPolicy{MaxAttempts: 4, Delay: time.Millisecond}, func(ctx context.Context, msg Message) error {
+		received <- msg
+		return nil
+	})
+	select {
+	case msg := <-received:
+		if msg.ID != "1" || string(msg.Body) != "payload" || msg.Attempt != 2 {
+			t.Errorf("got %+v, want ID 1, body payload, attempt 2", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("dead-lettered message not delivered")
+	}
+}
+
+func TestMemoryPublishAfterClose(t *testing.T) {
+	b := NewMemory()
+	b.Close()
+	if err := b.Publish(context.Background(), "jobs", Message{ID: "1"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestMemoryFullDeadLetterQueueDoesNotBlockConsumer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := NewMemory()
+
+	for i := 0; i < memoryQueueSize; i++ {
+		if err := b.Publish(ctx, DeadLetterQueue("jobs"), Message{ID: "dead"}); err != nil {
+			t.Fatalf("fill dead-letter queue: %v", err)
+		}
+	}
+
+	handled := make(chan string, 2)
+	go b.Consume(ctx, "jobs", RetryPolicy{MaxAttempts: 1, Delay: time.Millisecond}, func(ctx context.Context, msg Message) error {
+		handled <- msg.ID
+		if msg.ID == "1" {
+			return errors.New("always fails")
+		}
+		return nil
+	})
+
+	b.Publish(ctx, "jobs", Message{ID: "1"})
+	b.Publish(ctx, "jobs", Message{ID: "2"})
+	for _, want := range []string{"1", "2"} {
+		select {
+		case id := <-handled:
+			if id != want {
+				t.Errorf("got message %s, want %s", id, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %s not handled while the dead-letter queue is full", want)
+		}
+	}
+}
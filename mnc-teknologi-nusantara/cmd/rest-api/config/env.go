@@ -0,0 +1,46 @@
+// Package config reads the settings shared by the Rest API, the transfer worker
+// and the outbox relay from the environment.
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetEnv reads an environment variable, falling back to def when unset.
+func GetEnv(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// GetEnvInt reads an integer environment variable, falling back to def when unset or invalid.
+func GetEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %d", key, value, def)
+		return def
+	}
+	return parsed
+}
+
+// GetEnvDuration reads a duration environment variable such as "15m", falling back to def when unset or invalid.
+func GetEnvDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %s", key, value, def)
+		return def
+	}
+	return parsed
+}
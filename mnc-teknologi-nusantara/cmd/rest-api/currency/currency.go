@@ -0,0 +1,135 @@
+// Package currency knows the supported currencies and where exchange rates come from.
+package currency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"mnctech-restapi/cmd/rest-api/money"
+	"sort"
+	"time"
+)
+
+// Base is the currency of accounts and amounts that do not name one. Limits are
+// configured in it.
+const Base = "IDR"
+
+// supported lists the ISO 4217 codes accounts may hold. All of them have two
+// decimal places, the scale of money.Money.
+var supported = map[string]bool{
+	"IDR": true,
+	"USD": true,
+	"SGD": true,
+	"EUR": true,
+	"MYR": true,
+}
+
+// numeric maps the supported currencies to their ISO 4217 numeric codes, which
+// QR payloads carry.
+var numeric = map[string]string{
+	"IDR": "360",
+	"USD": "840",
+	"SGD": "702",
+	"EUR": "978",
+	"MYR": "458",
+}
+
+var (
+	ErrUnsupported = errors.New("unsupported currency")
+	ErrNoRate      = errors.New("no exchange rate")
+)
+
+// Valid reports whether accounts may hold the currency.
+func Valid(code string) bool {
+	return supported[code]
+}
+
+// Supported returns the codes accounts may hold in alphabetical order.
+func Supported() []string {
+	codes := make([]string, 0, len(supported))
+	for code := range supported {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Numeric returns the ISO 4217 numeric code of a supported currency.
+func Numeric(code string) (string, bool) {
+	n, ok := numeric[code]
+	return n, ok
+}
+
+// FromNumeric returns the supported currency with the ISO 4217 numeric code.
+func FromNumeric(n string) (string, bool) {
+	for code, value := range numeric {
+		if value == n {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// Rate is the price of one unit of From in To.
+type Rate struct {
+	From   string
+	To     string
+	Mid    *big.Rat  // Mid-market rate
+	Spread *big.Rat  // Fraction taken off the mid rate when converting, e.g. 0.005
+	AsOf   time.Time // When the provider last updated the rate
+}
+
+// Applied returns the rate customers convert at: the mid rate less the spread.
+func (r Rate) Applied() *big.Rat {
+	applied := new(big.Rat).Sub(big.NewRat(1, 1), r.Spread)
+	return applied.Mul(applied, r.Mid)
+}
+
+// Convert returns amount in To at the applied rate, rounded down to the minor unit.
+func (r Rate) Convert(amount money.Money) (money.Money, error) {
+	return convert(amount, r.Applied())
+}
+
+// FxRateProvider quotes exchange rates between supported currencies.
+type FxRateProvider interface {
+	Rate(ctx context.Context, from, to string) (Rate, error)
+}
+
+// ToBase converts amount in code to the base currency at the mid rate. Amounts
+// already in the base currency are returned as they are.
+func ToBase(ctx context.Context, provider FxRateProvider, amount money.Money, code string) (money.Money, error) {
+	if code == Base || amount.IsZero() {
+		return amount, nil
+	}
+	if provider == nil {
+		return 0, fmt.Errorf("%w from %s to %s", ErrNoRate, code, Base)
+	}
+	rate, err := provider.Rate(ctx, code, Base)
+	if err != nil {
+		return 0, err
+	}
+	return convert(amount, rate.Mid)
+}
+
+// convert multiplies amount by rate, rounding towards zero.
+func convert(amount money.Money, rate *big.Rat) (money.Money, error) {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(amount.Minor()), rate)
+	minor := new(big.Int).Quo(product.Num(), product.Denom())
+	if !minor.IsInt64() {
+		return 0, money.ErrOutOfRange
+	}
+	return money.FromMinor(minor.Int64()), nil
+}
+
+// FormatRate renders a rate with up to 10 decimal places, e.g. "15621.5".
+func FormatRate(rate *big.Rat) string {
+	text := rate.FloatString(10)
+	for text[len(text)-1] == '0' {
+		text = text[:len(text)-1]
+	}
+	if text[len(text)-1] == '.' {
+		text = text[:len(text)-1]
+	}
+	return text
+}
@@ -0,0 +1,128 @@
+package currency
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"mnctech-restapi/cmd/rest-api/money"
+	"testing"
+)
+
+// rates is an FxRateProvider of fixed mid rates with a 0.5% spread.
+type rates map[string]*big.Rat
+
+func (r rates) Rate(ctx context.Context, from, to string) (Rate, error) {
+	mid, ok := r[from+"/"+to]
+	if !ok {
+		return Rate{}, ErrNoRate
+	}
+	return Rate{From: from, To: to, Mid: mid, Spread: big.NewRat(5, 1000)}, nil
+}
+
+func TestNumeric(t *testing.T) {
+	tests := []struct {
+		code    string
+		numeric string
+	}{
+		{code: "IDR", numeric: "360"},
+		{code: "USD", numeric: "840"},
+		{code: "SGD", numeric: "702"},
+		{code: "EUR", numeric: "978"},
+		{code: "MYR", numeric: "458"},
+	}
+	for _, tt := range tests {
+		if got, ok := Numeric(tt.code); !ok || got != tt.numeric {
+			t.Errorf("Numeric(%q) = %q, %v, want %q", tt.code, got, ok, tt.numeric)
+		}
+		if got, ok := FromNumeric(tt.numeric); !ok || got != tt.code {
+			t.Errorf("FromNumeric(%q) = %q, %v, want %q", tt.numeric, got, ok, tt.code)
+		}
+	}
+
+	if _, ok := Numeric("JPY"); ok {
+		t.Errorf("Numeric(JPY) found an unsupported currency")
+	}
+	if _, ok := FromNumeric("392"); ok {
+		t.Errorf("FromNumeric(392) found an unsupported currency")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	usdIDR := Rate{From: "USD", To: "IDR", Mid: big.NewRat(15700, 1), Spread: big.NewRat(5, 1000)}
+	idrUSD := Rate{From: "IDR", To: "USD", Mid: big.NewRat(1, 15700), Spread: new(big.Rat)}
+
+	tests := []struct {
+		name    string
+		rate    Rate
+		amount  money.Money
+		want    money.Money
+		wantErr error
+	}{
+		{name: "spread taken off", rate: usdIDR, amount: money.FromMajor(1), want: money.FromMinor(1562150)},
+		{name: "rounded down", rate: usdIDR, amount: money.FromMinor(1), want: money.FromMinor(15621)},
+		{name: "negative rounded towards zero", rate: usdIDR, amount: money.FromMinor(-1), want: money.FromMinor(-15621)},
+		{name: "less than a minor unit", rate: idrUSD, amount: money.FromMajor(100), want: 0},
+		{name: "inverse rounded down", rate: idrUSD, amount: money.FromMajor(100000), want: money.FromMinor(636)},
+		{name: "zero", rate: usdIDR, amount: 0, want: 0},
+		{name: "overflow", rate: usdIDR, amount: math.MaxInt64, wantErr: money.ErrOutOfRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rate.Convert(tt.amount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToBase(t *testing.T) {
+	provider := rates{"USD/IDR": big.NewRat(15700, 1)}
+
+	tests := []struct {
+		name     string
+		provider FxRateProvider
+		amount   money.Money
+		code     string
+		want     money.Money
+		wantErr  error
+	}{
+		{name: "base currency", provider: provider, amount: money.FromMajor(10), code: Base, want: money.FromMajor(10)},
+		{name: "base currency without provider", amount: money.FromMajor(10), code: Base, want: money.FromMajor(10)},
+		{name: "zero without provider", amount: 0, code: "USD", want: 0},
+		{name: "at the mid rate", provider: provider, amount: money.FromMinor(150), code: "USD", want: money.FromMajor(23550)},
+		{name: "without provider", amount: money.FromMajor(1), code: "USD", wantErr: ErrNoRate},
+		{name: "missing rate", provider: provider, amount: money.FromMajor(1), code: "SGD", wantErr: ErrNoRate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToBase(context.Background(), tt.provider, tt.amount, tt.code)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tests := []struct {
+		rate *big.Rat
+		want string
+	}{
+		{rate: big.NewRat(15700, 1), want: "15700"},
+		{rate: big.NewRat(31243, 2), want: "15621.5"},
+		{rate: big.NewRat(1, 15700), want: "0.0000636943"},
+	}
+	for _, tt := range tests {
+		if got := FormatRate(tt.rate); got != tt.want {
+			t.Errorf("FormatRate(%s) = %q, want %q", tt.rate, got, tt.want)
+		}
+	}
+}
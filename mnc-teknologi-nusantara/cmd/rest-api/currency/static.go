@@ -0,0 +1,88 @@
+package currency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// StaticRates serves fixed exchange rates read from a JSON file. Meant for local
+// development and tests; the rates never change while the process runs.
+type StaticRates struct {
+	rates  map[string]*big.Rat // By "FROM/TO"
+	spread *big.Rat
+	asOf   time.Time
+}
+
+// staticRatesFile is the layout of the file read by LoadStaticRates.
+type staticRatesFile struct {
+	Spread string            `json:"spread"` // e.g. "0.005" for 0.5%
+	Rates  map[string]string `json:"rates"`  // e.g. {"USD/IDR": "15700"}
+}
+
+// LoadStaticRates reads rates such as
+// {"spread": "0.005", "rates": {"USD/IDR": "15700", "SGD/IDR": "11650"}}.
+// The inverse of a pair is derived when the file does not list it.
+func LoadStaticRates(path string) (*StaticRates, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file staticRatesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &StaticRates{rates: make(map[string]*big.Rat), spread: new(big.Rat), asOf: info.ModTime()}
+	if file.Spread != "" {
+		if _, ok := s.spread.SetString(file.Spread); !ok || s.spread.Sign() < 0 || s.spread.Cmp(big.NewRat(1, 1)) >= 0 {
+			return nil, fmt.Errorf("invalid spread %q", file.Spread)
+		}
+	}
+	for pair, value := range file.Rates {
+		from, to, ok := strings.Cut(pair, "/")
+		if !ok || !Valid(from) || !Valid(to) || from == to {
+			return nil, fmt.Errorf("invalid pair %q", pair)
+		}
+		rate, ok := new(big.Rat).SetString(value)
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid rate %q for %s", value, pair)
+		}
+		s.rates[pair] = rate
+	}
+	for pair, rate := range s.rates {
+		from, to, _ := strings.Cut(pair, "/")
+		if _, ok := s.rates[to+"/"+from]; !ok {
+			s.rates[to+"/"+from] = new(big.Rat).Inv(rate)
+		}
+	}
+	return s, nil
+}
+
+func (s *StaticRates) Rate(ctx context.Context, from, to string) (Rate, error) {
+	if !Valid(from) || !Valid(to) {
+		return Rate{}, ErrUnsupported
+	}
+	if from == to {
+		return Rate{From: from, To: to, Mid: big.NewRat(1, 1), Spread: new(big.Rat), AsOf: s.asOf}, nil
+	}
+	mid, ok := s.rates[from+"/"+to]
+	if !ok {
+		return Rate{}, fmt.Errorf("%w from %s to %s", ErrNoRate, from, to)
+	}
+	return Rate{
+		From:   from,
+		To:     to,
+		Mid:    new(big.Rat).Set(mid),
+		Spread: new(big.Rat).Set(s.spread),
+		AsOf:   s.asOf,
+	}, nil
+}
@@ -0,0 +1,81 @@
+package currency
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeRates(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rates.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write rates: %v", err)
+	}
+	return path
+}
+
+func TestStaticRates(t *testing.T) {
+	provider, err := LoadStaticRates(writeRates(t, `{"spread": "0.005", "rates": {"USD/IDR": "15700", "SGD/IDR": "11650", "IDR/SGD": "0.0000858"}}`))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		from, to string
+		wantMid  *big.Rat
+		wantErr  error
+	}{
+		{name: "listed", from: "USD", to: "IDR", wantMid: big.NewRat(15700, 1)},
+		{name: "inverse derived", from: "IDR", to: "USD", wantMid: big.NewRat(1, 15700)},
+		{name: "inverse listed", from: "IDR", to: "SGD", wantMid: big.NewRat(858, 10000000)},
+		{name: "same currency", from: "USD", to: "USD", wantMid: big.NewRat(1, 1)},
+		{name: "missing", from: "USD", to: "SGD", wantErr: ErrNoRate},
+		{name: "unsupported", from: "JPY", to: "IDR", wantErr: ErrUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, err := provider.Rate(context.Background(), tt.from, tt.to)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && rate.Mid.Cmp(tt.wantMid) != 0 {
+				t.Errorf("got mid %s, want %s", rate.Mid, tt.wantMid)
+			}
+		})
+	}
+
+	// Callers may change the returned rate without changing the provider
+	rate, _ := provider.Rate(context.Background(), "USD", "IDR")
+	rate.Mid.SetInt64(1)
+	if rate, _ := provider.Rate(context.Background(), "USD", "IDR"); rate.Mid.Cmp(big.NewRat(15700, 1)) != 0 {
+		t.Errorf("got mid %s after changing a returned rate, want 15700", rate.Mid)
+	}
+}
+
+func TestLoadStaticRatesRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "not JSON", content: `USD/IDR=15700`},
+		{name: "negative spread", content: `{"spread": "-0.1", "rates": {}}`},
+		{name: "whole spread", content: `{"spread": "1", "rates": {}}`},
+		{name: "pair without slash", content: `{"rates": {"USDIDR": "15700"}}`},
+		{name: "unsupported currency", content: `{"rates": {"JPY/IDR": "105"}}`},
+		{name: "same currency", content: `{"rates": {"IDR/IDR": "1"}}`},
+		{name: "zero rate", content: `{"rates": {"USD/IDR": "0"}}`},
+		{name: "rate not a number", content: `{"rates": {"USD/IDR": "many"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadStaticRates(writeRates(t, tt.content)); err == nil {
+				t.Errorf("loaded %s", tt.content)
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"mnctech-restapi/cmd/rest-api/config"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"mnctech-restapi/cmd/rest-api/models"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// ConnectDB establishes a connection to the PostgreSQL database configured by the DB_* environment variables.
+func ConnectDB() *gorm.DB {
+	var err error
+
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
+		config.GetEnv("DB_HOST", "db"),
+		config.GetEnv("DB_USER", "mnctech"),
+		config.GetEnv("DB_PASSWORD", "mnctechpass"),
+		config.GetEnv("DB_NAME", "mnctechdb"),
+		config.GetEnv("DB_PORT", "5432"),
+	)
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Retrieve the underlying sql.DB from GORM and configure connection pool
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set up connection pool settings
+	sqlDB.SetMaxIdleConns(10)           // Set maximum number of idle connections
+	sqlDB.SetMaxOpenConns(100)          // Set maximum number of open connections
+	sqlDB.SetConnMaxLifetime(time.Hour) // Set maximum connection lifetime
+
+	return db
+}
+
+// CloseDBConnection closes the database connection pool.
+func CloseDBConnection(db *gorm.DB) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Printf("Error getting DB from GORM: %v", err)
+		return
+	}
+	if err := sqlDB.Close(); err != nil {
+		log.Printf("Error closing DB connection: %v", err)
+	} else {
+		log.Println("Database connection closed successfully.")
+	}
+}
+
+// MigrateDatabase initializes the database migrations
+func MigrateDatabase(db *gorm.DB) error {
+	m := gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "20241020_09",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(
+					&models.User{},
+					&models.UserAccount{},
+					&models.AccountTransactionLog{},
+					&models.TopUpTransaction{},
+					&models.PaymentTransaction{},
+					&models.TransferTransaction{},
+				); err != nil {
+					log.Printf("Error during migration: %v", err)
+					return err
+				}
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				// Define rollback logic here
+				return tx.Migrator().DropTable("users")
+			},
+		},
+		{
+			ID: "20241021_01",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.RefreshToken{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable("refresh_tokens")
+			},
+		},
+		{
+			ID: "20241022_01",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Session{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable("sessions")
+			},
+		},
+		{
+			ID: "20241023_01",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.LoginThrottle{}, &models.LoginLockEvent{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable("login_throttles", "login_lock_events")
+			},
+		},
+		{
+			ID: "20241024_01",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.OTPCode{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable("otp_codes")
+			},
+		},
+		{
+			ID: "20241025_01",
+			Migrate: func(tx *gorm.DB) error {
+				for _, column := range moneyColumns {
+					if err := convertToMinorUnits(tx, column[0], column[1]); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, column := range moneyColumns {
+					if err := convertFromMinorUnits(tx, column[0], column[1]); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			ID: "20241026_01",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.IdempotencyKey{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable("idempotency_keys")
+			},
+		},
+		{
+			ID: "20241027_01",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(&models.LedgerAccount{}, &models.JournalEntry{}, &models.Posting{}); err != nil {
+					return err
+				}
+
+				// Balances that existed before the ledger are posted against the opening balance account
+				var userAccounts []models.UserAccount
+				if err := tx.Where("current_balance <> 0").Find(&userAccounts).Error; err != nil {
+					return err
+				}
+				for _, userAccount := range userAccounts {
+					if _, err := ledger.Post(tx, fmt.Sprintf("OPENING-%d", userAccount.ID), "OPENING_BALANCE", "Opening balance",
+						ledger.Transfer(ledger.AccountOpeningBalance, ledger.UserAccountCode(userAccount.ID), userAccount.CurrentBalance)...); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable("postings", "journal_entries", "ledger_accounts")
+			},
+		},
+		{
+			ID: "20241028_01",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.TransferTransaction{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropColumn(&models.TransferTransaction{}, "err_message")
+			},
+		},
+		{
+			ID: "20241029_01",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.OutboxMessage{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable("outbox_messages")
+			},
+		},
+		{
+			ID: "20241030_01",
+			Migrate: func(tx *gorm.DB) error {
+				// Index the transaction reference used to look up a single transaction
+				return tx.AutoMigrate(&models.AccountTransactionLog{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropIndex(&models.AccountTransactionLog{}, "TransactionReff")
+			},
+		},
+		{
+			ID: "20241031_01",
+			Migrate: func(tx *gorm.DB) error {
+				// Serve the pages of GET /transactions from the index
+				return tx.Exec("CREATE INDEX IF NOT EXISTS idx_account_transaction_logs_account_id_id ON account_transaction_logs (user_account_id, id)").Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Exec("DROP INDEX IF EXISTS idx_account_transaction_logs_account_id_id").Error
+			},
+		},
+		{
+			ID: "20241101_01",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.User{}, &models.Refund{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable("refunds"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&models.User{}, "role")
+			},
+		},
+		{
+			ID: "20241102_01",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.TransferInquiry{}, &models.TransferInquiryLookup{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable("transfer_inquiry_lookups", "transfer_inquiries")
+			},
+		},
+		{
+			ID: "20241103_01",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.User{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropColumn(&models.User{}, "kyc_tier")
+			},
+		},
+		{
+			ID: "20241104_01",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(&models.KYCSubmission{}, &models.KYCTierChange{}); err != nil {
+					return err
+				}
+
+				// An identity number may be approved for one user only
+				return tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_kyc_submissions_nik_approved ON kyc_submissions (nik) WHERE status = 'APPROVED' AND deleted_at IS NULL").Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable("kyc_tier_changes", "kyc_submissions")
+			},
+		},
+		{
+			ID: "20241105_01",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(&models.UserAccount{}); err != nil {
+					return err
+				}
+
+				// Accounts that existed before wallets become the default wallet of their user
+				var userAccounts []models.UserAccount
+				if err := tx.Unscoped().Where("uid IS NULL").Find(&userAccounts).Error; err != nil {
+					return err
+				}
+				for _, userAccount := range userAccounts {
+					if err := tx.Unscoped().Model(&userAccount).Updates(map[string]interface{}{
+						"uid":        uuid.New().String(),
+						"is_default": userAccount.DeletedAt.Time.IsZero(),
+					}).Error; err != nil {
+						return err
+					}
+				}
+
+				// A user has a single default wallet and names their open wallets uniquely
+				if err := tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_user_accounts_default ON user_accounts (user_id) WHERE is_default AND deleted_at IS NULL").Error; err != nil {
+					return err
+				}
+				return tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_user_accounts_name ON user_accounts (user_id, lower(name)) WHERE deleted_at IS NULL").Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Exec("DROP INDEX IF EXISTS idx_user_accounts_default, idx_user_accounts_name").Error; err != nil {
+					return err
+				}
+				for _, column := range []string{"uid", "name", "is_default"} {
+					if err := tx.Migrator().DropColumn(&models.UserAccount{}, column); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			ID: "20241106_01",
+			Migrate: func(tx *gorm.DB) error {
+				// Balances and transactions from before currencies are in rupiah, the column default
+				if err := tx.AutoMigrate(
+					&models.UserAccount{},
+					&models.AccountTransactionLog{},
+					&models.TopUpTransaction{},
+					&models.PaymentTransaction{},
+					&models.TransferTransaction{},
+					&models.Refund{},
+					&models.FxQuote{},
+					&models.FxConversion{},
+				); err != nil {
+					return err
+				}
+
+				// Earlier transfers credited the target what they debited the sender
+				return tx.Exec("UPDATE transfer_transactions SET target_amount = amount WHERE target_amount = 0").Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable("fx_conversions", "fx_quotes"); err != nil {
+					return err
+				}
+				for _, model := range []interface{}{
+					&models.UserAccount{},
+					&models.AccountTransactionLog{},
+					&models.TopUpTransaction{},
+					&models.PaymentTransaction{},
+					&models.TransferTransaction{},
+					&models.Refund{},
+				} {
+					if err := tx.Migrator().DropColumn(model, "currency"); err != nil {
+						return err
+					}
+				}
+				for _, column := range []string{"target_amount", "target_currency", "fx_rate", "fx_spread"} {
+					if err := tx.Migrator().DropColumn(&models.TransferTransaction{}, column); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			ID: "20241107_01",
+			Migrate: func(tx *gorm.DB) error {
+				// Earlier payments have no merchant and stay with the merchant settlement account
+				return tx.AutoMigrate(
+					&models.Merchant{},
+					&models.MerchantSettlement{},
+					&models.PaymentTransaction{},
+					&models.Refund{},
+				)
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, model := range []interface{}{&models.PaymentTransaction{}, &models.Refund{}} {
+					for _, column := range []string{"merchant_id", "settlement_id"} {
+						if err := tx.Migrator().DropColumn(model, column); err != nil {
+							return err
+						}
+					}
+				}
+				return tx.Migrator().DropTable("merchant_settlements", "merchants")
+			},
+		},
+		{
+			ID: "20241108_01",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Merchant{}, &models.MerchantQRCode{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable("merchant_qr_codes"); err != nil {
+					return err
+				}
+				for _, column := range []string{"city", "category_code", "postal_code"} {
+					if err := tx.Migrator().DropColumn(&models.Merchant{}, column); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			ID: "20241109_01",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.UserAccount{}, &models.Hold{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable("holds"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&models.UserAccount{}, "held_balance")
+			},
+		},
+	})
+
+	// Execute migrations
+	if err := m.Migrate(); err != nil {
+		log.Printf("Error running migrations: %v", err) // Log any errors
+		return err
+	}
+	log.Println("Migrations ran successfully!")
+	return nil
+}
+
+// moneyColumns lists the table and column of every amount stored as money.Money.
+var moneyColumns = [][2]string{
+	{"user_accounts", "current_balance"},
+	{"user_accounts", "last_balance"},
+	{"account_transaction_logs", "amount"},
+	{"account_transaction_logs", "balance_before"},
+	{"account_transaction_logs", "balance_after"},
+	{"top_up_transactions", "amount"},
+	{"payment_transactions", "amount"},
+	{"transfer_transactions", "amount"},
+}
+
+// columnDataType returns the SQL data type of a column.
+func columnDataType(tx *gorm.DB, table, column string) (string, error) {
+	var dataType string
+	err := tx.Raw("SELECT data_type FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?",
+		table, column).Scan(&dataType).Error
+	return dataType, err
+}
+
+// convertToMinorUnits turns a float column holding rupiah into a bigint column holding minor units.
+// Columns created by AutoMigrate after money.Money was introduced are already bigint and left alone.
+func convertToMinorUnits(tx *gorm.DB, table, column string) error {
+	dataType, err := columnDataType(tx, table, column)
+	if err != nil || dataType != "double precision" {
+		return err
+	}
+	return tx.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE bigint USING round(%s * 100)::bigint", table, column, column)).Error
+}
+
+// convertFromMinorUnits reverts convertToMinorUnits.
+func convertFromMinorUnits(tx *gorm.DB, table, column string) error {
+	dataType, err := columnDataType(tx, table, column)
+	if err != nil || dataType != "bigint" {
+		return err
+	}
+	return tx.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE double precision USING %s / 100.0", table, column, column)).Error
+}
@@ -0,0 +1,55 @@
+// Package databasetest connects tests to a PostgreSQL database migrated like the
+// one the services run on.
+package databasetest
+
+import (
+	"mnctech-restapi/cmd/rest-api/database"
+	"os"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// migrationLockID is the PostgreSQL advisory lock held while migrating, since the
+// test binaries of several packages run side by side.
+const migrationLockID = 20241020
+
+var (
+	migrateOnce sync.Once
+	migrateErr  error
+)
+
+// Open connects to the database in TEST_DATABASE_DSN, e.g. "host=localhost
+// user=mnctech password=mnctechpass dbname=mnctech_test port=5433 sslmode=disable",
+// and runs the migrations of the services on it. The test is skipped when
+// TEST_DATABASE_DSN is not set.
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	migrateOnce.Do(func() {
+		// The advisory lock belongs to a session, so lock, migrate and unlock on one connection
+		migrateErr = db.Connection(func(conn *gorm.DB) error {
+			if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockID).Error; err != nil {
+				return err
+			}
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockID)
+			return database.MigrateDatabase(conn)
+		})
+	})
+	if migrateErr != nil {
+		t.Fatalf("migrate: %v", migrateErr)
+	}
+	return db
+}
@@ -0,0 +1,115 @@
+package events
+
+import (
+	"encoding/json"
+	"mnctech-restapi/cmd/rest-api/money"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types. The type is also the routing key events are published with.
+const (
+	TypeTopUpCompleted    = "TopUpCompleted"
+	TypePaymentCompleted  = "PaymentCompleted"
+	TypeTransferCompleted = "TransferCompleted"
+	TypeRefundCompleted   = "RefundCompleted"
+	TypeKYCReviewed       = "KYCReviewed"
+	TypeMerchantSettled   = "MerchantSettled"
+)
+
+// Version is the schema version of the event data defined in this package. It is
+// raised when a field is removed or changes meaning; adding a field keeps it.
+const Version = 1
+
+// Envelope is the JSON document published for every event. Consumers must
+// tolerate receiving the same event twice and deduplicate on ID.
+type Envelope struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Version    int             `json:"version"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// TopUpCompleted is published when a top-up has been credited.
+type TopUpCompleted struct {
+	TopUpID      string      `json:"top_up_id"`
+	UserID       string      `json:"user_id"`
+	Amount       money.Money `json:"amount"`
+	Currency     string      `json:"currency"`
+	BalanceAfter money.Money `json:"balance_after"`
+}
+
+// PaymentCompleted is published when a payment has been debited.
+type PaymentCompleted struct {
+	PaymentID    string      `json:"payment_id"`
+	UserID       string      `json:"user_id"`
+	MerchantID   string      `json:"merchant_id"`
+	Amount       money.Money `json:"amount"`
+	Currency     string      `json:"currency"`
+	Remarks      string      `json:"remarks"`
+	BalanceAfter money.Money `json:"balance_after"`
+}
+
+// TransferCompleted is published when the target of a transfer has been credited.
+type TransferCompleted struct {
+	TransferID     string      `json:"transfer_id"`
+	SenderUserID   string      `json:"sender_user_id"`
+	TargetUserID   string      `json:"target_user_id"`
+	Amount         money.Money `json:"amount"` // Debited from the sender
+	Currency       string      `json:"currency"`
+	TargetAmount   money.Money `json:"target_amount"` // Credited to the target
+	TargetCurrency string      `json:"target_currency"`
+	Remarks        string      `json:"remarks"`
+}
+
+// New wraps the event data in an envelope with a fresh ID.
+func New(eventType string, data interface{}) (Envelope, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{
+		ID:         uuid.New().String(),
+		Type:       eventType,
+		Version:    Version,
+		OccurredAt: time.Now().UTC(),
+		Data:       encoded,
+	}, nil
+}
+
+// RefundCompleted is published when a payment or transfer has been refunded.
+type RefundCompleted struct {
+	RefundID            string      `json:"refund_id"`
+	TransactionID       string      `json:"transaction_id"`       // payment_id or transfer_id
+	TransactionCategory string      `json:"transaction_category"` // PAYMENT or TRANSFER
+	TransactionStatus   string      `json:"transaction_status"`   // REFUNDED or PARTIALLY_REFUNDED
+	Amount              money.Money `json:"amount"`
+	Currency            string      `json:"currency"`
+	Reason              string      `json:"reason"`
+}
+
+// KYCReviewed is published when an admin approved or rejected a KYC submission.
+type KYCReviewed struct {
+	SubmissionID string `json:"submission_id"`
+	UserID       string `json:"user_id"`
+	Status       string `json:"status"`   // APPROVED or REJECTED
+	KYCTier      string `json:"kyc_tier"` // Tier of the user after the review
+	Reason       string `json:"reason"`
+}
+
+// MerchantSettled is published when a settlement batch is created, for the net
+// amount to be paid out to the bank account of the merchant.
+type MerchantSettled struct {
+	SettlementID        string      `json:"settlement_id"`
+	MerchantID          string      `json:"merchant_id"`
+	BusinessDate        string      `json:"business_date"` // YYYY-MM-DD
+	Currency            string      `json:"currency"`
+	GrossAmount         money.Money `json:"gross_amount"`
+	RefundAmount        money.Money `json:"refund_amount"`
+	NetAmount           money.Money `json:"net_amount"` // To pay out
+	PayoutBankCode      string      `json:"payout_bank_code"`
+	PayoutAccountNumber string      `json:"payout_account_number"`
+	PayoutAccountName   string      `json:"payout_account_name"`
+}
@@ -4,39 +4,50 @@ import (
 	"encoding/json"
 	"errors"
 	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/events"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"mnctech-restapi/cmd/rest-api/middlewares"
 	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/outbox"
+	"mnctech-restapi/cmd/rest-api/responses"
 	"net/http"
 	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 // TopUpRequest represents the expected payload for a top-up request.
 type TopUpRequest struct {
-	Amount float64 `json:"amount" validate:"required"` // Amount to top up
+	Amount    money.Money `json:"amount"`                               // Amount to top up, checked against the AmountPolicy
+	AccountID string      `json:"account_id" validate:"omitempty,uuid"` // Account to credit, the default account when omitted
+	Currency  string      `json:"currency" validate:"omitempty,len=3"`  // Must be the currency of the account when given
 }
 
 // TopUpResult represents the structure of the top-up response result.
 type TopUpResult struct {
-	TopUpID       string    `json:"top_up_id"`      // Unique identifier for the top-up transaction
-	AmountTopUp   float64   `json:"amount_top_up"`  // Amount that was topped up
-	BalanceBefore float64   `json:"balance_before"` // Balance before the top-up
-	BalanceAfter  float64   `json:"balance_after"`  // Balance after the top-up
-	CreatedDate   time.Time `json:"created_date"`   // Date of the top-up
+	TopUpID       string      `json:"top_up_id"`      // Unique identifier for the top-up transaction
+	AccountID     string      `json:"account_id"`     // Account that was credited
+	AmountTopUp   money.Money `json:"amount_top_up"`  // Amount that was topped up
+	Currency      string      `json:"currency"`       // Currency of the account and the amounts
+	BalanceBefore money.Money `json:"balance_before"` // Balance before the top-up
+	BalanceAfter  money.Money `json:"balance_after"`  // Balance after the top-up
+	CreatedDate   time.Time   `json:"created_date"`   // Date of the top-up
 }
 
 type TransactionResponse struct {
-	TransferID      string    `json:"transfer_id"`
-	Status          string    `json:"status"`
-	UserID          string    `json:"user_id"`
-	TransactionType string    `json:"transaction_type"`
-	Amount          float64   `json:"amount"`
-	Remarks         string    `json:"remarks"`
-	BalanceBefore   float64   `json:"balance_before"`
-	BalanceAfter    float64   `json:"balance_after"`
-	CreatedDate     time.Time `json:"created_date"`
+	TransferID      string      `json:"transfer_id"`
+	Status          string      `json:"status"`
+	UserID          string      `json:"user_id"`
+	TransactionType string      `json:"transaction_type"`
+	Amount          money.Money `json:"amount"`
+	Currency        string      `json:"currency"`
+	Remarks         string      `json:"remarks"`
+	BalanceBefore   money.Money `json:"balance_before"`
+	BalanceAfter    money.Money `json:"balance_after"`
+	CreatedDate     time.Time   `json:"created_date"`
 }
 
 // TopUp handles user top-ups.
@@ -46,21 +57,24 @@ func (h *AppHandler) HandleTopUp(w http.ResponseWriter, r *http.Request) {
 
 	if !ok {
 		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(NewFailedResponse("User ID not found in context"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
 		return
 	}
 
 	var req TopUpRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if fieldErrors := amountDecodeError(err); fieldErrors != nil {
+			writeValidationErrors(w, fieldErrors)
+			return
+		}
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(NewFailedResponse("Invalid request payload"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
 		return
 	}
 
 	// Validate input
-	validate := validator.New()
-	if err := validate.Struct(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if fieldErrors := append(validateRequest(req), h.AmountPolicy.Check("amount", req.Amount)...); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
 		return
 	}
 
@@ -74,47 +88,48 @@ func (h *AppHandler) HandleTopUp(w http.ResponseWriter, r *http.Request) {
 
 	// Start a new transaction
 	err := h.DB.Transaction(func(tx *gorm.DB) error {
-		var user models.User
-		if err := tx.Where("uid = ?", userID).First(&user).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return errors.New("user not found") // Return an error if the user does not exist
-			}
-			return err // Return the error to rollback the transaction for other errors
+		user, err := findUserByUID(tx, userID)
+		if err != nil {
+			return err
 		}
-		// Fetch the user's account
-		var userAccount models.UserAccount
-		if err := tx.Where("user_id = ?", user.ID).First(&userAccount).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				// If not found, create a new UserAccount
-				userAccount = models.UserAccount{
-					UserID:         user.ID, // Use the user's ID from the User model
-					CurrentBalance: 0,       // Set initial balance or any other default values
-					LastBalance:    0,
-				}
-
-				if err := tx.Create(&userAccount).Error; err != nil {
-					return err // Return the error to rollback the transaction
-				}
-			} else {
-				return err // Return the error to rollback the transaction for other errors
-			}
+
+		// Fetch the chosen account, creating the default account on the first top-up
+		accountID, err := resolveAccount(tx, user.ID, req.AccountID)
+		if err != nil {
+			return err
+		}
+
+		// Lock the account so concurrent balance changes cannot overwrite each other
+		accounts, err := lockAccounts(tx, accountID)
+		if err != nil {
+			return err
+		}
+		userAccount := accounts[accountID]
+		if req.Currency != "" && req.Currency != userAccount.Currency {
+			return errCurrencyMismatch
+		}
+
+		// Top-ups count towards the limits of the user's KYC tier and its maximum balance
+		if err := h.checkLimits(r.Context(), tx, user, req.Amount, userAccount.Currency, true); err != nil {
+			return err
 		}
 
 		// Update balances
-		userAccount.LastBalance = userAccount.CurrentBalance // Save the previous balance
-		userAccount.CurrentBalance += req.Amount             // Update current balance
+		userAccount.LastBalance = userAccount.CurrentBalance                    // Save the previous balance
+		userAccount.CurrentBalance = userAccount.CurrentBalance.Add(req.Amount) // Update current balance
 
 		// Save the updated account
-		if err := tx.Save(&userAccount).Error; err != nil {
+		if err := tx.Save(userAccount).Error; err != nil {
 			return err // Return the error to rollback the transaction
 		}
 
 		// Create a top-up transaction record
 		topUpTransactionPayload := models.TopUpTransaction{
 			UID:           topupTrxID,
-			UserAccountID: userAccount.ID, // Reference to the user's account
-			Amount:        req.Amount,     // The amount topped up
-			Status:        "SUCCESS",      // Status of the transaction
+			UserAccountID: userAccount.ID,       // Reference to the user's account
+			Amount:        req.Amount,           // The amount topped up
+			Currency:      userAccount.Currency, // Currency of the account
+			Status:        "SUCCESS",            // Status of the transaction
 		}
 
 		if err := tx.Create(&topUpTransactionPayload).Error; err != nil {
@@ -123,7 +138,7 @@ func (h *AppHandler) HandleTopUp(w http.ResponseWriter, r *http.Request) {
 
 		// Fetch the user's account
 		var updatedUserAccount models.UserAccount
-		if err := tx.Where("user_id = ?", user.ID).First(&updatedUserAccount).Error; err != nil {
+		if err := tx.First(&updatedUserAccount, userAccount.ID).Error; err != nil {
 			return err
 		}
 
@@ -134,6 +149,7 @@ func (h *AppHandler) HandleTopUp(w http.ResponseWriter, r *http.Request) {
 			TransactionReff:     topupTrxID,
 			UserAccountID:       userAccount.ID,
 			Amount:              req.Amount,
+			Currency:            updatedUserAccount.Currency,
 			BalanceBefore:       updatedUserAccount.LastBalance,
 			BalanceAfter:        updatedUserAccount.CurrentBalance,
 		}
@@ -147,26 +163,55 @@ func (h *AppHandler) HandleTopUp(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 
+		// Money enters the wallet from the top-up source
+		if err := postJournal(tx, topupTrxID, "TOPUP", "Top up",
+			ledger.Transfer(ledger.SystemAccount(ledger.AccountTopUpSource, userAccount.Currency), ledger.UserAccountCode(userAccount.ID), req.Amount),
+			&updatedUserAccount); err != nil {
+			return err
+		}
+
+		if err := outbox.EnqueueEvent(tx, events.TypeTopUpCompleted, events.TopUpCompleted{
+			TopUpID:      topupTrxID,
+			UserID:       user.UID,
+			Amount:       req.Amount,
+			Currency:     updatedUserAccount.Currency,
+			BalanceAfter: updatedUserAccount.CurrentBalance,
+		}); err != nil {
+			return err // Return the error to rollback the transaction
+		}
+
+		topupResult.AccountID = updatedUserAccount.UID
+		topupResult.Currency = updatedUserAccount.Currency
 		topupResult.BalanceBefore = updatedUserAccount.LastBalance
 		topupResult.BalanceAfter = updatedUserAccount.CurrentBalance
 		topupResult.CreatedDate = topupTransaction.CreatedAt
 
-		return nil // Commit the transaction if everything is successful
+		// A retry with the Idempotency-Key gets this response once it commits
+		return middlewares.CompleteIdempotencyKey(r.Context(), tx, http.StatusOK, responses.NewSuccessResponse(topupResult))
 	})
 
 	if err != nil {
+		var limitErr *LimitExceededError
 		if err.Error() == "user not found" {
 			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(NewFailedResponse("User not found"))
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		} else if errors.As(err, &limitErr) {
+			writeLimitExceeded(w, limitErr)
+		} else if errors.Is(err, errAccountNotFound) {
+			writeAccountNotFound(w)
+		} else if errors.Is(err, errCurrencyMismatch) {
+			writeCurrencyMismatch(w)
+		} else if errors.Is(err, currency.ErrNoRate) {
+			writeFxUnavailable(w)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(NewFailedResponse("Transaction failed"))
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Transaction failed"))
 		}
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(NewSuccessResponse(topupResult))
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(topupResult))
 }
 
 func (h *AppHandler) GetTransactionList(w http.ResponseWriter, r *http.Request) {
@@ -175,7 +220,13 @@ func (h *AppHandler) GetTransactionList(w http.ResponseWriter, r *http.Request)
 
 	if !ok {
 		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(NewFailedResponse("User ID not found in context"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	query, fieldErrors := parseTransactionListQuery(r.URL.Query())
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
 		return
 	}
 
@@ -183,30 +234,40 @@ func (h *AppHandler) GetTransactionList(w http.ResponseWriter, r *http.Request)
 	if err := h.DB.Where("uid = ?", userID).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(NewFailedResponse("User ID not found in context"))
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
 			return
 		}
 		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NewFailedResponse("User ID not found in context"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
 		return
 	}
 
-	var userAccount models.UserAccount
-	if err := h.DB.Where("user_id = ?", user.ID).First(&userAccount).Error; err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(NewFailedResponse("User Account not found in context"))
-		return
+	// Entries of all the user's accounts unless account_id picks one
+	accounts := accountIDsOfUser(h.DB, user.ID)
+	if accountUID := r.URL.Query().Get("account_id"); accountUID != "" {
+		if _, err := uuid.Parse(accountUID); err != nil {
+			writeAccountNotFound(w)
+			return
+		}
+		accounts = accounts.Where("uid = ?", accountUID)
 	}
 
 	var transactionLogs []models.AccountTransactionLog
-	if err := h.DB.Where("user_account_id = ?", userAccount.ID).Order("id desc").Find(&transactionLogs).Error; err != nil {
+	if err := query.Apply(h.DB.Where("user_account_id IN (?)", accounts)).Find(&transactionLogs).Error; err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NewFailedResponse("Failed to retrieve transactions"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve transactions"))
 		return
 	}
 
+	// The extra row only tells that another page follows
+	var nextCursor *string
+	if len(transactionLogs) > query.Limit {
+		transactionLogs = transactionLogs[:query.Limit]
+		nextCursor = query.nextCursor(transactionLogs[len(transactionLogs)-1].ID)
+	}
+
 	// Convert the logs to response format
-	var transactions []TransactionResponse
+	transactions := make([]TransactionResponse, 0, len(transactionLogs))
 	for _, log := range transactionLogs {
 		transactions = append(transactions, TransactionResponse{
 			TransferID:      log.TransactionReff, // Assuming UID is used as transfer_id
@@ -214,6 +275,7 @@ func (h *AppHandler) GetTransactionList(w http.ResponseWriter, r *http.Request)
 			UserID:          userID, // Assuming the user ID is the same for all logs retrieved
 			TransactionType: log.TransactionType,
 			Amount:          log.Amount,
+			Currency:        log.Currency,
 			Remarks:         log.Remarks,
 			BalanceBefore:   log.BalanceBefore,
 			BalanceAfter:    log.BalanceAfter,
@@ -222,5 +284,8 @@ func (h *AppHandler) GetTransactionList(w http.ResponseWriter, r *http.Request)
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(NewSuccessResponse(transactions))
+	json.NewEncoder(w).Encode(responses.PaginatedSuccessResponse{
+		SuccessResponse: responses.NewSuccessResponse(transactions),
+		NextCursor:      nextCursor,
+	})
 }
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"errors"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"sort"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// findUserByUID loads the user with the given UID, returning an error with the
+// message "user not found" when it does not exist.
+func findUserByUID(tx *gorm.DB, uid string) (models.User, error) {
+	var user models.User
+	if err := tx.Where("uid = ?", uid).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.User{}, errors.New("user not found") // Return an error if the user does not exist
+		}
+		return models.User{}, err // Return the error to rollback the transaction for other errors
+	}
+	return user, nil
+}
+
+// accountIDsOfUser returns a subquery selecting the IDs of the user's accounts,
+// including closed ones so their history stays visible.
+func accountIDsOfUser(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Unscoped().Model(&models.UserAccount{}).Select("id").Where("user_id = ?", userID)
+}
+
+// userUIDOfAccount returns the UID of the owner of the account.
+func userUIDOfAccount(tx *gorm.DB, accountID uint) (string, error) {
+	var uid string
+	err := tx.Model(&models.User{}).
+		Joins("JOIN user_accounts ON user_accounts.user_id = users.id").
+		Where("user_accounts.id = ?", accountID).
+		Select("users.uid").
+		Scan(&uid).Error
+	return uid, err
+}
+
+// ensureAccount returns the ID of the user's default account, creating an empty
+// default account when the user has none yet. It does not lock the user: the unique
+// index on the default account of a user lets only one of concurrent requests
+// create it, and the others read the one it created.
+func ensureAccount(tx *gorm.DB, userID uint) (uint, error) {
+	var userAccount models.UserAccount
+	err := tx.Where("user_id = ? AND is_default", userID).First(&userAccount).Error
+	if err == nil {
+		return userAccount.ID, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	// If not found, create a new UserAccount
+	userAccount = models.UserAccount{
+		UID:            uuid.New().String(),
+		UserID:         userID, // Use the user's ID from the User model
+		Name:           defaultAccountName,
+		IsDefault:      true,
+		Currency:       currency.Base,
+		CurrentBalance: 0, // Set initial balance or any other default values
+		LastBalance:    0,
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&userAccount)
+	if result.Error != nil {
+		return 0, result.Error // Return the error to rollback the transaction
+	}
+	if result.RowsAffected == 1 {
+		return userAccount.ID, nil
+	}
+
+	// Another request created it after the first look
+	if err := tx.Where("user_id = ? AND is_default", userID).First(&userAccount).Error; err != nil {
+		return 0, err
+	}
+	return userAccount.ID, nil
+}
+
+// resolveAccount returns the ID of the open account of the user with the given
+// UID, or of their default account when accountUID is empty.
+func resolveAccount(tx *gorm.DB, userID uint, accountUID string) (uint, error) {
+	if accountUID == "" {
+		return ensureAccount(tx, userID)
+	}
+	var userAccount models.UserAccount
+	err := tx.Where("uid = ? AND user_id = ?", accountUID, userID).First(&userAccount).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, errAccountNotFound
+	}
+	return userAccount.ID, err
+}
+
+// openAccountOrDefault returns the account ID when the account is still open and
+// the ID of its owner's default account when it has been closed.
+func openAccountOrDefault(tx *gorm.DB, accountID uint) (uint, error) {
+	var userAccount models.UserAccount
+	if err := tx.Unscoped().First(&userAccount, accountID).Error; err != nil {
+		return 0, err
+	}
+	if !userAccount.DeletedAt.Valid {
+		return userAccount.ID, nil
+	}
+	return ensureAccount(tx, userAccount.UserID)
+}
+
+// lockAccounts loads the accounts with SELECT ... FOR UPDATE so their balances
+// cannot change until the transaction ends. Rows are locked in ascending ID order,
+// so two transactions locking the same accounts cannot deadlock.
+func lockAccounts(tx *gorm.DB, accountIDs ...uint) (map[uint]*models.UserAccount, error) {
+	ids := append([]uint(nil), accountIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	accounts := make(map[uint]*models.UserAccount, len(ids))
+	for _, id := range ids {
+		if _, ok := accounts[id]; ok {
+			continue
+		}
+		var userAccount models.UserAccount
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&userAccount, id).Error; err != nil {
+			return nil, err
+		}
+		accounts[id] = &userAccount
+	}
+	return accounts, nil
+}
+
+// availableBalance is the part of the balance of an account that is not reserved
+// by active holds. Debits are checked against it.
+func availableBalance(userAccount *models.UserAccount) money.Money {
+	return userAccount.CurrentBalance.Sub(userAccount.HeldBalance)
+}
+
+// postJournal records the balanced journal entry of a balance change, then checks
+// that the stored balances of the changed accounts still match the ledger. When an
+// account appears twice the last copy is taken as its final state.
+func postJournal(tx *gorm.DB, transactionReff, category, description string, lines []ledger.Line, changed ...*models.UserAccount) error {
+	if _, err := ledger.Post(tx, transactionReff, category, description, lines...); err != nil {
+		return err
+	}
+
+	final := make(map[uint]*models.UserAccount, len(changed))
+	for _, userAccount := range changed {
+		final[userAccount.ID] = userAccount
+	}
+	for _, userAccount := range final {
+		if err := ledger.VerifyUserAccount(tx, *userAccount); err != nil {
+			return err // Return the error to rollback the transaction
+		}
+	}
+	return nil
+}
+
+// creditAccount adds amount to a locked account and writes the CREDIT log row.
+func creditAccount(tx *gorm.DB, userAccount *models.UserAccount, amount money.Money, category, transactionReff, remarks string) error {
+	return changeBalance(tx, userAccount, amount, "CREDIT", category, transactionReff, remarks)
+}
+
+// debitAccount takes amount from a locked account and writes the DEBIT log row.
+// The caller checks the balance first.
+func debitAccount(tx *gorm.DB, userAccount *models.UserAccount, amount money.Money, category, transactionReff, remarks string) error {
+	return changeBalance(tx, userAccount, amount.Neg(), "DEBIT", category, transactionReff, remarks)
+}
+
+func changeBalance(tx *gorm.DB, userAccount *models.UserAccount, delta money.Money, transactionType, category, transactionReff, remarks string) error {
+	userAccount.LastBalance = userAccount.CurrentBalance               // Save the previous balance
+	userAccount.CurrentBalance = userAccount.CurrentBalance.Add(delta) // Update current balance
+
+	// Save the updated account
+	if err := tx.Save(userAccount).Error; err != nil {
+		return err // Return the error to rollback the transaction
+	}
+
+	amount := delta
+	if amount.IsNegative() {
+		amount = amount.Neg()
+	}
+	transactionLog := models.AccountTransactionLog{
+		TransactionType:     transactionType,
+		TransactionCategory: category,
+		TransactionReff:     transactionReff,
+		UserAccountID:       userAccount.ID,
+		Amount:              amount,
+		Currency:            userAccount.Currency,
+		Remarks:             remarks,
+		BalanceBefore:       userAccount.LastBalance,
+		BalanceAfter:        userAccount.CurrentBalance,
+	}
+	return tx.Create(&transactionLog).Error
+}
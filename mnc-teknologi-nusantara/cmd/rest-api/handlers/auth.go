@@ -4,11 +4,11 @@ import (
 	"encoding/json"
 	"mnctech-restapi/cmd/rest-api/auth"
 	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/otp"
+	"mnctech-restapi/cmd/rest-api/responses"
 	"net/http"
-	"time"
 
 	"github.com/go-playground/validator/v10"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
@@ -16,8 +16,10 @@ import (
 
 type AuthHandler struct {
 	*AppHandler
-	AccessTokenKey  []byte // Include the access token key
-	RefreshTokenKey []byte // Include the refresh token key
+	AccessTokenKeys *auth.KeyManager    // Signs access tokens
+	RefreshTokenKey []byte              // Include the refresh token key
+	LoginThrottle   LoginThrottleConfig // Lockout policy for failed logins
+	OTPSender       otp.Sender          // Delivers one-time passwords to phone numbers
 }
 
 // RegisterRequest represents the expected payload for registration.
@@ -26,7 +28,7 @@ type RegisterRequest struct {
 	LastName    string `json:"last_name" validate:"required"`
 	PhoneNumber string `json:"phone_number" validate:"required"`
 	Address     string `json:"address" validate:"required"`
-	PIN         string `json:"pin" validate:"required"`
+	PIN         string `json:"pin" validate:"required,pin"`
 }
 
 type RegisterResponse struct {
@@ -57,22 +59,20 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(NewFailedResponse("Invalid request payload"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
 		return
 	}
 
 	// Validate input
-	validate := validator.New()
-	if err := validate.Struct(req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(NewFailedResponse("Invalid request payload"))
+	if fieldErrors := validateRequest(req); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
 		return
 	}
 
 	var existingUser models.User
 	if err := h.DB.Where("phone_number = ?", req.PhoneNumber).First(&existingUser).Error; err == nil {
 		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(NewFailedResponse("User not found"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
 		return
 	}
 
@@ -80,7 +80,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	hashedPin, err := bcrypt.GenerateFromPassword([]byte(req.PIN), bcrypt.DefaultCost)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NewFailedResponse("Invalid PIN"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid PIN"))
 		return
 	}
 
@@ -97,7 +97,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	// Insert user into the database using GORM
 	if err := h.DB.Create(&user).Error; err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NewFailedResponse("Gagal mendaftarkan pengguna"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Gagal mendaftarkan pengguna"))
 		return
 	}
 
@@ -112,7 +112,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	// Respond with success
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(NewSuccessResponse(userResponse))
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(userResponse))
 }
 
 // Login handles user login and generates a JWT token.
@@ -121,7 +121,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(NewFailedResponse("Invalid request payload"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
 		return
 	}
 
@@ -129,63 +129,78 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	validate := validator.New()
 	if err := validate.Struct(req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(NewFailedResponse("Invalid request payload"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	ip := clientIP(r)
+
+	// Count the attempt before checking the PIN, refusing it while the phone number or client is locked
+	lockedUntil, err := h.LoginThrottle.reserveLoginAttempt(h.DB, req.PhoneNumber, ip)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to check login attempts"))
+		return
+	}
+	if lockedUntil != nil {
+		writeAccountLocked(w, *lockedUntil)
 		return
 	}
 
 	var user models.User
 	if err := h.DB.Where("phone_number = ?", req.PhoneNumber).First(&user).Error; err != nil {
+		// Unknown numbers count too, so they cannot be probed without limit
+		lockedUntil, err := h.LoginThrottle.recordLoginFailure(h.DB, req.PhoneNumber, ip)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to record login attempt"))
+			return
+		}
+		if lockedUntil != nil {
+			writeAccountLocked(w, *lockedUntil)
+			return
+		}
 		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(NewFailedResponse("User not found"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
 		return
 	}
 
 	// Check if PIN matches
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Pin), []byte(req.PIN)); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NewFailedResponse("Invalid PIN"))
-		return
-	}
-
-	// Create JWT token
-	accessExpirationTime := time.Now().Add(24 * time.Hour) // Token expires in 24 hours
-	accessClaims := &auth.CustomClaims{
-		UID: user.UID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(accessExpirationTime),
-		},
-	}
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	// Sign the access token with the secret key
-	accessTokenString, err := accessToken.SignedString(h.AccessTokenKey)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NewFailedResponse("Invalid access token"))
+		lockedUntil, err := h.LoginThrottle.recordLoginFailure(h.DB, req.PhoneNumber, ip)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to record login attempt"))
+			return
+		}
+		if lockedUntil != nil {
+			writeAccountLocked(w, *lockedUntil)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("INVALID_PIN", "Invalid PIN"))
 		return
 	}
 
-	// Create JWT refresh token
-	refreshExpirationTime := time.Now().Add(7 * 24 * time.Hour) // Token expires in 7 days
-	refreshClaims := &auth.CustomClaims{
-		UID: user.UID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(refreshExpirationTime),
-		}}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-
-	// Sign the refresh token with the secret key
-	refreshTokenString, err := refreshToken.SignedString(h.RefreshTokenKey)
+	// Start a new session and issue its first token pair
+	var response LoginResponse
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := releaseLoginAttempt(tx, req.PhoneNumber, ip); err != nil {
+			return err
+		}
+		session, err := startSession(tx, user, r)
+		if err != nil {
+			return err
+		}
+		response, err = h.issueTokens(tx, user, session)
+		return err
+	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NewFailedResponse("Invalid refresh token"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to issue tokens"))
 		return
 	}
 
-	// Respond with the tokens
-	response := LoginResponse{
-		AccessToken:  accessTokenString,
-		RefreshToken: refreshTokenString,
-	}
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(NewSuccessResponse(response))
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(response))
 }
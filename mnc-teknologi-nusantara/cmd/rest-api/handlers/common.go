@@ -1,29 +1,45 @@
 package handlers
 
-import "gorm.io/gorm"
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/notify"
+	"mnctech-restapi/cmd/rest-api/storage"
+	"net"
+	"net/http"
+	"time"
 
-type AppHandler struct {
-	DB *gorm.DB
-}
+	"gorm.io/gorm"
+)
 
-type SuccessResponse struct {
-	Status string      `json:"status"`
-	Result interface{} `json:"result"` // Result can hold any type of data
-}
-
-type FailedResponse struct {
-	Message string `json:"message"`
+type AppHandler struct {
+	DB           *gorm.DB
+	AmountPolicy AmountPolicy            // Limits applied to amounts of /topup, /pay and /transfer
+	Limits       LimitPolicy             // Per-user limits of each KYC tier, nil means unlimited
+	Store        storage.Store           // Keeps uploaded KYC documents
+	Notifier     notify.Notifier         // Tells users the outcome of reviews, nil disables notifications
+	FxRates      currency.FxRateProvider // Exchange rates, nil rejects everything crossing currencies
+	HoldTTL      time.Duration           // How long holds last before they expire, DefaultHoldTTL when zero
+	InquiryLimit InquiryRateLimit        // Recipient lookups allowed per user, zero is unlimited
 }
 
-func NewSuccessResponse(result interface{}) SuccessResponse {
-	return SuccessResponse{
-		Status: "SUCCESS", // Default status
-		Result: result,    // Dynamic result data
+// clientIP returns the IP address of the client that sent the request. Forwarding
+// headers are not trusted, so behind a reverse proxy every client has the address
+// of the proxy and shares its login throttle.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
+	return host
 }
 
-func NewFailedResponse(failedMessage string) FailedResponse {
-	return FailedResponse{
-		Message: failedMessage,
+// newOpaqueToken returns a random URL-safe token handed to clients for a follow-up request.
+func newOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/broker"
+	"mnctech-restapi/cmd/rest-api/database/databasetest"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/outbox"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// createTestUser creates a user whose account holds the given balance.
+func createTestUser(t *testing.T, db *gorm.DB, balance money.Money) (models.User, models.UserAccount) {
+	t.Helper()
+	user := models.User{UID: uuid.New().String(), PhoneNumber: uuid.New().String()}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	userAccount := models.UserAccount{UID: uuid.New().String(), UserID: user.ID, Name: defaultAccountName, IsDefault: true, CurrentBalance: balance}
+	if err := db.Create(&userAccount).Error; err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+	if _, err := ledger.Post(db, uuid.New().String(), "OPENING_BALANCE", "Test balance",
+		ledger.Transfer(ledger.AccountOpeningBalance, ledger.UserAccountCode(userAccount.ID), balance)...); err != nil {
+		t.Fatalf("open balance: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Unscoped().Where("user_account_id = ?", userAccount.ID).Delete(&models.AccountTransactionLog{})
+		db.Unscoped().Where("user_account_id = ?", userAccount.ID).Delete(&models.PaymentTransaction{})
+		db.Unscoped().Where("user_account_id = ?", userAccount.ID).Delete(&models.TransferTransaction{})
+		db.Unscoped().Where("user_account_id = ?", userAccount.ID).Delete(&models.Hold{})
+		db.Unscoped().Delete(&userAccount)
+		db.Unscoped().Delete(&user)
+	})
+	return user, userAccount
+}
+
+// createTestMerchant creates an active merchant accepting rupiah.
+func createTestMerchant(t *testing.T, db *gorm.DB) models.Merchant {
+	t.Helper()
+	_, keyID, secretHash, err := newMerchantAPIKey()
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+	merchant := models.Merchant{
+		UID:                 uuid.New().String(),
+		Name:                "Test merchant",
+		Currency:            "IDR",
+		Status:              merchantStatusActive,
+		APIKeyID:            keyID,
+		APIKeyHash:          secretHash,
+		PayoutBankCode:      "014",
+		PayoutAccountNumber: "1234567890",
+		PayoutAccountName:   "Test merchant",
+	}
+	if err := db.Create(&merchant).Error; err != nil {
+		t.Fatalf("create merchant: %v", err)
+	}
+	t.Cleanup(func() { db.Unscoped().Delete(&merchant) })
+	return merchant
+}
+
+// createTestInquiry returns the token of a transfer inquiry from sender to target.
+func createTestInquiry(t *testing.T, db *gorm.DB, sender, target models.User) string {
+	t.Helper()
+	token := uuid.New().String()
+	inquiry := models.TransferInquiry{
+		UID:          uuid.New().String(),
+		UserID:       sender.ID,
+		TargetUserID: target.ID,
+		TokenHash:    auth.HashToken(token),
+		ExpiresAt:    time.Now().Add(transferInquiryTTL),
+	}
+	if err := db.Create(&inquiry).Error; err != nil {
+		t.Fatalf("create inquiry: %v", err)
+	}
+	t.Cleanup(func() { db.Unscoped().Delete(&inquiry) })
+	return token
+}
+
+// callAsUser invokes the handler with the user's UID in the request context.
+func callAsUser(handler http.HandlerFunc, userUID string, payload interface{}) int {
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userUID))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec.Code
+}
+
+func TestConcurrentPaymentsDoNotOverdraw(t *testing.T) {
+	db := databasetest.Open(t)
+	h := &AppHandler{DB: db, AmountPolicy: DefaultAmountPolicy()}
+
+	amount := money.FromMajor(10)
+	user, userAccount := createTestUser(t, db, money.FromMajor(100))
+	merchant := createTestMerchant(t, db)
+
+	const workers = 50
+	var wg sync.WaitGroup
+	codes := make(chan int, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- callAsUser(h.HandlePayment, user.UID, PaymentRequest{MerchantID: merchant.UID, Amount: amount, Remarks: "concurrency"})
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	succeeded := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusBadRequest: // Balance is not enough
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if succeeded != 10 {
+		t.Errorf("got %d successful payments, want 10", succeeded)
+	}
+
+	var reloaded models.UserAccount
+	db.First(&reloaded, userAccount.ID)
+	if !reloaded.CurrentBalance.IsZero() {
+		t.Errorf("got balance %s, want 0.00", reloaded.CurrentBalance)
+	}
+}
+
+func TestConcurrentOppositeTransfersKeepTotal(t *testing.T) {
+	db := databasetest.Open(t)
+	h := &AppHandler{DB: db, AmountPolicy: DefaultAmountPolicy()}
+
+	alice, aliceAccount := createTestUser(t, db, money.FromMajor(100))
+	bob, bobAccount := createTestUser(t, db, money.FromMajor(100))
+
+	const workers = 25
+	var wg sync.WaitGroup
+	codes := make(chan int, 2*workers)
+	for i := 0; i < workers; i++ {
+		toBob := createTestInquiry(t, db, alice, bob)
+		toAlice := createTestInquiry(t, db, bob, alice)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			codes <- callAsUser(h.HandleTransfer, alice.UID, TransferRequest{InquiryToken: toBob, Amount: money.FromMajor(1)})
+		}()
+		go func() {
+			defer wg.Done()
+			codes <- callAsUser(h.HandleTransfer, bob.UID, TransferRequest{InquiryToken: toAlice, Amount: money.FromMajor(1)})
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		if code != http.StatusAccepted {
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+
+	// Let the outbox relay and the transfer worker credit every target
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messageBroker := broker.NewMemory()
+	relay := outbox.DefaultRelay(db, messageBroker)
+	relay.Interval = 10 * time.Millisecond
+	go relay.Run(ctx)
+	go messageBroker.Consume(ctx, TransferQueue, broker.RetryPolicy{MaxAttempts: 3, Delay: 10 * time.Millisecond}, h.ProcessTransfer)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var pending int64
+		db.Model(&models.TransferTransaction{}).
+			Where("user_account_id IN ? AND status = ?", []uint{aliceAccount.ID, bobAccount.ID}, transferStatusPending).
+			Count(&pending)
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d transfers still pending", pending)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	var reloadedAlice, reloadedBob models.UserAccount
+	db.First(&reloadedAlice, aliceAccount.ID)
+	db.First(&reloadedBob, bobAccount.ID)
+	if total := reloadedAlice.CurrentBalance.Add(reloadedBob.CurrentBalance); total != money.FromMajor(200) {
+		t.Errorf("got total %s, want 200.00", total)
+	}
+}
+
+func TestConcurrentHoldsAndPaymentsDoNotOverdraw(t *testing.T) {
+	db := databasetest.Open(t)
+	h := &AppHandler{DB: db, AmountPolicy: DefaultAmountPolicy()}
+
+	amount := money.FromMajor(10)
+	user, userAccount := createTestUser(t, db, money.FromMajor(100))
+	merchant := createTestMerchant(t, db)
+
+	const workers = 25
+	var wg sync.WaitGroup
+	holdCodes := make(chan int, workers)
+	paymentCodes := make(chan int, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			holdCodes <- callAsUser(h.HandlePlaceHold, user.UID, HoldRequest{MerchantID: merchant.UID, Amount: amount, Remarks: "concurrency"})
+		}()
+		go func() {
+			defer wg.Done()
+			paymentCodes <- callAsUser(h.HandlePayment, user.UID, PaymentRequest{MerchantID: merchant.UID, Amount: amount, Remarks: "concurrency"})
+		}()
+	}
+	wg.Wait()
+	close(holdCodes)
+	close(paymentCodes)
+
+	count := func(codes chan int, success int) int {
+		succeeded := 0
+		for code := range codes {
+			switch code {
+			case success:
+				succeeded++
+			case http.StatusBadRequest: // Balance is not enough
+			default:
+				t.Errorf("unexpected status %d", code)
+			}
+		}
+		return succeeded
+	}
+	held := count(holdCodes, http.StatusCreated)
+	paid := count(paymentCodes, http.StatusOK)
+	if held+paid != 10 {
+		t.Errorf("got %d holds and %d payments, want 10 in total", held, paid)
+	}
+
+	var reloaded models.UserAccount
+	db.First(&reloaded, userAccount.ID)
+	if want := money.FromMajor(int64(10 * held)); reloaded.HeldBalance != want {
+		t.Errorf("got held balance %s, want %s", reloaded.HeldBalance, want)
+	}
+	if !availableBalance(&reloaded).IsZero() {
+		t.Errorf("got available balance %s, want 0.00", availableBalance(&reloaded))
+	}
+
+	// Once expired the holds give the funds back
+	if _, err := h.ExpireHolds(context.Background(), time.Now().Add(DefaultHoldTTL+time.Minute)); err != nil {
+		t.Fatalf("expire holds: %v", err)
+	}
+	db.First(&reloaded, userAccount.ID)
+	if !reloaded.HeldBalance.IsZero() {
+		t.Errorf("got held balance %s after expiry, want 0.00", reloaded.HeldBalance)
+	}
+}
@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"mnctech-restapi/cmd/rest-api/middlewares"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// fxQuoteTTL is how long the rate of a quote is held for its conversion.
+const fxQuoteTTL = time.Minute
+
+var (
+	errCurrencyMismatch = errors.New("currency does not match the account")
+	errFxAmountTooSmall = errors.New("converted amount is zero")
+	errFxSameCurrency   = errors.New("accounts hold the same currency")
+	errFxQuoteNotFound  = errors.New("quote not found")
+	errFxQuoteExpired   = errors.New("quote has expired")
+	errFxQuoteExecuted  = errors.New("quote has already been used")
+)
+
+// FxQuoteRequest represents the expected payload of POST /fx/quotes.
+type FxQuoteRequest struct {
+	SourceAccountID      string      `json:"source_account_id" validate:"required,uuid"`
+	DestinationAccountID string      `json:"destination_account_id" validate:"required,uuid"`
+	Amount               money.Money `json:"amount"` // In the currency of the source account
+}
+
+// FxConversionRequest represents the expected payload of POST /fx/conversions.
+type FxConversionRequest struct {
+	QuoteID string `json:"quote_id" validate:"required,uuid"`
+}
+
+// FxQuoteResponse is a rate held for a conversion between two accounts of the caller.
+type FxQuoteResponse struct {
+	QuoteID              string      `json:"quote_id"`
+	SourceAccountID      string      `json:"source_account_id"`
+	DestinationAccountID string      `json:"destination_account_id"`
+	SourceCurrency       string      `json:"source_currency"`
+	DestinationCurrency  string      `json:"destination_currency"`
+	SourceAmount         money.Money `json:"source_amount"`      // Debited from the source account
+	DestinationAmount    money.Money `json:"destination_amount"` // Credited to the destination account
+	MidRate              string      `json:"mid_rate"`           // Mid-market rate of the provider
+	Spread               string      `json:"spread"`             // Fraction taken off the mid rate
+	Rate                 string      `json:"rate"`               // Rate the source amount is converted at
+	ExpiresAt            time.Time   `json:"expires_at"`         // Execute the quote before this time
+}
+
+// FxConversionResult represents the structure of the conversion response result.
+type FxConversionResult struct {
+	ConversionID            string      `json:"conversion_id"`
+	QuoteID                 string      `json:"quote_id"`
+	SourceAccountID         string      `json:"source_account_id"`
+	DestinationAccountID    string      `json:"destination_account_id"`
+	SourceCurrency          string      `json:"source_currency"`
+	DestinationCurrency     string      `json:"destination_currency"`
+	SourceAmount            money.Money `json:"source_amount"`
+	DestinationAmount       money.Money `json:"destination_amount"`
+	Rate                    string      `json:"rate"`
+	Spread                  string      `json:"spread"`
+	SourceBalanceAfter      money.Money `json:"source_balance_after"`
+	DestinationBalanceAfter money.Money `json:"destination_balance_after"`
+	CreatedDate             time.Time   `json:"created_date"`
+}
+
+// convertedAmount is an amount in the currency it was converted into.
+type convertedAmount struct {
+	Amount money.Money
+	Rate   *string // Applied rate, nil when the currency did not change
+	Spread *string // Spread included in Rate, nil when the currency did not change
+}
+
+// rate asks the FxRates provider for the rate between two currencies, failing
+// with currency.ErrNoRate when no provider is configured.
+func (h *AppHandler) rate(ctx context.Context, from, to string) (currency.Rate, error) {
+	if h.FxRates == nil {
+		return currency.Rate{}, fmt.Errorf("%w from %s to %s", currency.ErrNoRate, from, to)
+	}
+	return h.FxRates.Rate(ctx, from, to)
+}
+
+// convert returns amount in the currency to at the applied rate of the provider.
+// Amounts that stay in their currency are returned as they are.
+func (h *AppHandler) convert(ctx context.Context, amount money.Money, from, to string) (convertedAmount, error) {
+	if from == to {
+		return convertedAmount{Amount: amount}, nil
+	}
+	rate, err := h.rate(ctx, from, to)
+	if err != nil {
+		return convertedAmount{}, err
+	}
+	converted, err := rate.Convert(amount)
+	if err != nil {
+		return convertedAmount{}, err
+	}
+	if !converted.IsPositive() {
+		return convertedAmount{}, errFxAmountTooSmall
+	}
+	applied, spread := currency.FormatRate(rate.Applied()), currency.FormatRate(rate.Spread)
+	return convertedAmount{Amount: converted, Rate: &applied, Spread: &spread}, nil
+}
+
+// writeCurrencyMismatch responds to an amount in another currency than its account.
+func writeCurrencyMismatch(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("CURRENCY_MISMATCH", "Currency does not match the account"))
+}
+
+// writeFxUnavailable responds when no exchange rate is known for a pair of currencies.
+func writeFxUnavailable(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("FX_UNAVAILABLE", "Exchange rate is not available"))
+}
+
+// writeFxAmountTooSmall responds to an amount worth nothing in the other currency.
+func writeFxAmountTooSmall(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("FX_AMOUNT_TOO_SMALL", "Amount is too small to convert"))
+}
+
+// writeFxError responds with the failure of a quote or conversion.
+func writeFxError(w http.ResponseWriter, err error) {
+	switch {
+	case err.Error() == "insufficient balance":
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Balance is not enough"))
+	case errors.Is(err, errFxSameCurrency):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("SAME_CURRENCY", "Accounts hold the same currency, move between them instead"))
+	case errors.Is(err, errFxQuoteNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("QUOTE_NOT_FOUND", "Quote not found"))
+	case errors.Is(err, errFxQuoteExpired):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("QUOTE_EXPIRED", "Quote has expired, ask for a new one"))
+	case errors.Is(err, errFxQuoteExecuted):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("QUOTE_ALREADY_USED", "Quote has already been converted"))
+	case errors.Is(err, errFxAmountTooSmall):
+		writeFxAmountTooSmall(w)
+	case errors.Is(err, currency.ErrNoRate):
+		writeFxUnavailable(w)
+	default:
+		writeAccountError(w, err)
+	}
+}
+
+// CreateFxQuote quotes converting an amount from one account of the caller into
+// another account of theirs in a different currency. The rate is held for
+// fxQuoteTTL.
+func (h *AppHandler) CreateFxQuote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var req FxQuoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if fieldErrors := amountDecodeError(err); fieldErrors != nil {
+			writeValidationErrors(w, fieldErrors)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input
+	if fieldErrors := append(validateRequest(req), h.AmountPolicy.Check("amount", req.Amount)...); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+	if req.SourceAccountID == req.DestinationAccountID {
+		writeAccountError(w, errSameAccount)
+		return
+	}
+
+	var quote models.FxQuote
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := findUserByUID(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		sourceID, err := resolveAccount(tx, user.ID, req.SourceAccountID)
+		if err != nil {
+			return err
+		}
+		destinationID, err := resolveAccount(tx, user.ID, req.DestinationAccountID)
+		if err != nil {
+			return err
+		}
+		var sourceAccount, destinationAccount models.UserAccount
+		if err := tx.First(&sourceAccount, sourceID).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&destinationAccount, destinationID).Error; err != nil {
+			return err
+		}
+		if sourceAccount.Currency == destinationAccount.Currency {
+			return errFxSameCurrency
+		}
+
+		rate, err := h.rate(r.Context(), sourceAccount.Currency, destinationAccount.Currency)
+		if err != nil {
+			return err
+		}
+		destinationAmount, err := rate.Convert(req.Amount)
+		if err != nil {
+			return err
+		}
+		if !destinationAmount.IsPositive() {
+			return errFxAmountTooSmall
+		}
+
+		quote = models.FxQuote{
+			UID:                  uuid.New().String(),
+			UserID:               user.ID,
+			SourceAccountID:      sourceAccount.ID,
+			DestinationAccountID: destinationAccount.ID,
+			SourceCurrency:       sourceAccount.Currency,
+			DestinationCurrency:  destinationAccount.Currency,
+			SourceAmount:         req.Amount,
+			DestinationAmount:    destinationAmount,
+			MidRate:              currency.FormatRate(rate.Mid),
+			Spread:               currency.FormatRate(rate.Spread),
+			Rate:                 currency.FormatRate(rate.Applied()),
+			ExpiresAt:            time.Now().Add(fxQuoteTTL),
+		}
+		return tx.Create(&quote).Error
+	})
+
+	if err != nil {
+		writeFxError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(FxQuoteResponse{
+		QuoteID:              quote.UID,
+		SourceAccountID:      req.SourceAccountID,
+		DestinationAccountID: req.DestinationAccountID,
+		SourceCurrency:       quote.SourceCurrency,
+		DestinationCurrency:  quote.DestinationCurrency,
+		SourceAmount:         quote.SourceAmount,
+		DestinationAmount:    quote.DestinationAmount,
+		MidRate:              quote.MidRate,
+		Spread:               quote.Spread,
+		Rate:                 quote.Rate,
+		ExpiresAt:            quote.ExpiresAt,
+	}))
+}
+
+// HandleFxConversion executes a quote of the caller that has not expired,
+// debiting the source account and crediting the destination account at the rate
+// of the quote. Like moves, conversions do not count towards the limits of the
+// user's KYC tier.
+func (h *AppHandler) HandleFxConversion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var req FxConversionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+	if fieldErrors := validateRequest(req); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	var result FxConversionResult
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := findUserByUID(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		// Lock both accounts in a fixed order, then the quote so it is executed once
+		var quote models.FxQuote
+		if err := tx.Where("uid = ? AND user_id = ?", req.QuoteID, user.ID).First(&quote).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errFxQuoteNotFound
+			}
+			return err
+		}
+		accounts, err := lockAccounts(tx, quote.SourceAccountID, quote.DestinationAccountID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errAccountNotFound // One of the accounts was closed after the quote
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&quote, quote.ID).Error; err != nil {
+			return err
+		}
+		if quote.ExecutedAt != nil {
+			return errFxQuoteExecuted
+		}
+		now := time.Now()
+		if now.After(quote.ExpiresAt) {
+			return errFxQuoteExpired
+		}
+
+		sourceAccount := accounts[quote.SourceAccountID]
+		destinationAccount := accounts[quote.DestinationAccountID]
+		if availableBalance(sourceAccount).LessThan(quote.SourceAmount) {
+			return errors.New("insufficient balance")
+		}
+
+		conversion := models.FxConversion{
+			UID:                  uuid.New().String(),
+			QuoteID:              quote.ID,
+			SourceAccountID:      sourceAccount.ID,
+			DestinationAccountID: destinationAccount.ID,
+			SourceCurrency:       quote.SourceCurrency,
+			DestinationCurrency:  quote.DestinationCurrency,
+			SourceAmount:         quote.SourceAmount,
+			DestinationAmount:    quote.DestinationAmount,
+			MidRate:              quote.MidRate,
+			Spread:               quote.Spread,
+			Rate:                 quote.Rate,
+		}
+		remarks := fmt.Sprintf("Convert %s %s to %s %s", quote.SourceAmount, quote.SourceCurrency, quote.DestinationAmount, quote.DestinationCurrency)
+
+		if err := debitAccount(tx, sourceAccount, quote.SourceAmount, "FX_CONVERSION", conversion.UID, remarks); err != nil {
+			return err
+		}
+		if err := creditAccount(tx, destinationAccount, quote.DestinationAmount, "FX_CONVERSION", conversion.UID, remarks); err != nil {
+			return err
+		}
+
+		// The FX position buys the source currency and sells the destination currency
+		lines := append(
+			ledger.Transfer(ledger.UserAccountCode(sourceAccount.ID), ledger.SystemAccount(ledger.AccountFxPosition, quote.SourceCurrency), quote.SourceAmount),
+			ledger.Transfer(ledger.SystemAccount(ledger.AccountFxPosition, quote.DestinationCurrency), ledger.UserAccountCode(destinationAccount.ID), quote.DestinationAmount)...)
+		if err := postJournal(tx, conversion.UID, "FX_CONVERSION", remarks, lines, sourceAccount, destinationAccount); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&quote).Update("executed_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&conversion).Error; err != nil {
+			return err // Return the error to rollback the transaction
+		}
+
+		result = FxConversionResult{
+			ConversionID:            conversion.UID,
+			QuoteID:                 quote.UID,
+			SourceAccountID:         sourceAccount.UID,
+			DestinationAccountID:    destinationAccount.UID,
+			SourceCurrency:          quote.SourceCurrency,
+			DestinationCurrency:     quote.DestinationCurrency,
+			SourceAmount:            quote.SourceAmount,
+			DestinationAmount:       quote.DestinationAmount,
+			Rate:                    quote.Rate,
+			Spread:                  quote.Spread,
+			SourceBalanceAfter:      sourceAccount.CurrentBalance,
+			DestinationBalanceAfter: destinationAccount.CurrentBalance,
+			CreatedDate:             conversion.CreatedAt,
+		}
+		return middlewares.CompleteIdempotencyKey(r.Context(), tx, http.StatusOK, responses.NewSuccessResponse(result))
+	})
+
+	if err != nil {
+		writeFxError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(result))
+}
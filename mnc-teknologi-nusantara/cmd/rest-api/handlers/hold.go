@@ -0,0 +1,636 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/events"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"mnctech-restapi/cmd/rest-api/middlewares"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/outbox"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultHoldTTL is how long a hold lasts when AppHandler.HoldTTL is not set.
+	DefaultHoldTTL = 7 * 24 * time.Hour
+
+	// holdSweepBatchSize is the number of holds ExpireHolds expires per call.
+	holdSweepBatchSize = 100
+)
+
+// Statuses of a hold.
+const (
+	holdStatusActive   = "ACTIVE"
+	holdStatusCaptured = "CAPTURED"
+	holdStatusReleased = "RELEASED"
+	holdStatusExpired  = "EXPIRED"
+)
+
+var (
+	errHoldNotFound       = errors.New("hold not found")
+	errHoldNotActive      = errors.New("hold is no longer active")
+	errHoldExpired        = errors.New("hold has expired")
+	errCaptureExceedsHold = errors.New("capture amount exceeds the hold")
+)
+
+// HoldRequest represents the expected payload of POST /holds.
+type HoldRequest struct {
+	MerchantID string      `json:"merchant_id" validate:"required,uuid"` // Merchant the funds are held for
+	Amount     money.Money `json:"amount"`
+	Remarks    string      `json:"remarks" validate:"max=255"`
+	AccountID  string      `json:"account_id" validate:"omitempty,uuid"` // Account to hold funds on, the default account when omitted
+	Currency   string      `json:"currency" validate:"omitempty,len=3"`  // Must be the currency of the account when given
+}
+
+// HoldCaptureRequest represents the expected payload of POST /merchant/holds/{hold_id}/capture.
+type HoldCaptureRequest struct {
+	Amount money.Money `json:"amount"` // Omit to capture the whole hold, the rest is released
+}
+
+// HoldResponse represents a hold.
+type HoldResponse struct {
+	HoldID         string      `json:"hold_id"`
+	AccountID      string      `json:"account_id"`
+	MerchantID     string      `json:"merchant_id"`
+	MerchantName   string      `json:"merchant_name"`
+	Amount         money.Money `json:"amount"`
+	CapturedAmount money.Money `json:"captured_amount"`
+	Currency       string      `json:"currency"`
+	Status         string      `json:"status"` // ACTIVE, CAPTURED, RELEASED or EXPIRED
+	Remarks        string      `json:"remarks"`
+	PaymentID      *string     `json:"payment_id"` // Payment made by the capture
+	ExpiresAt      time.Time   `json:"expires_at"`
+	ClosedAt       *time.Time  `json:"closed_at"`
+	CreatedDate    time.Time   `json:"created_date"`
+}
+
+// newHoldResponse builds the response of a hold with its account, merchant and
+// payment loaded.
+func newHoldResponse(hold models.Hold) HoldResponse {
+	response := HoldResponse{
+		HoldID:         hold.UID,
+		AccountID:      hold.UserAccount.UID,
+		MerchantID:     hold.Merchant.UID,
+		MerchantName:   hold.Merchant.Name,
+		Amount:         hold.Amount,
+		CapturedAmount: hold.CapturedAmount,
+		Currency:       hold.Currency,
+		Status:         hold.Status,
+		Remarks:        hold.Remarks,
+		ExpiresAt:      hold.ExpiresAt,
+		ClosedAt:       hold.ClosedAt,
+		CreatedDate:    hold.CreatedAt,
+	}
+	if hold.PaymentTransaction != nil {
+		response.PaymentID = &hold.PaymentTransaction.UID
+	}
+	return response
+}
+
+// writeHoldError responds with the failure of a hold request.
+func writeHoldError(w http.ResponseWriter, err error) {
+	var limitErr *LimitExceededError
+	switch {
+	case err.Error() == "insufficient balance":
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Balance is not enough"))
+	case err.Error() == "user not found":
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+	case errors.As(err, &limitErr):
+		writeLimitExceeded(w, limitErr)
+	case errors.Is(err, errAccountNotFound):
+		writeAccountNotFound(w)
+	case errors.Is(err, errCurrencyMismatch):
+		writeCurrencyMismatch(w)
+	case errors.Is(err, errMerchantNotFound), errors.Is(err, errMerchantNotActive):
+		writeMerchantError(w, err)
+	case errors.Is(err, currency.ErrNoRate):
+		writeFxUnavailable(w)
+	case errors.Is(err, errHoldNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("HOLD_NOT_FOUND", "Hold not found"))
+	case errors.Is(err, errHoldNotActive):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("HOLD_NOT_ACTIVE", "Hold has already been captured, released or expired"))
+	case errors.Is(err, errHoldExpired):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("HOLD_EXPIRED", "Hold has expired"))
+	case errors.Is(err, errCaptureExceedsHold):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("CAPTURE_EXCEEDS_HOLD", "Amount is more than the hold"))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Hold request failed"))
+	}
+}
+
+// holdTTL is how long new holds last.
+func (h *AppHandler) holdTTL() time.Duration {
+	if h.HoldTTL > 0 {
+		return h.HoldTTL
+	}
+	return DefaultHoldTTL
+}
+
+// HandlePlaceHold reserves funds of the caller for a merchant, which captures or
+// releases them later. The held amount cannot be spent until then.
+func (h *AppHandler) HandlePlaceHold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	// Parse request body
+	var req HoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if fieldErrors := amountDecodeError(err); fieldErrors != nil {
+			writeValidationErrors(w, fieldErrors)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input
+	if fieldErrors := append(validateRequest(req), h.AmountPolicy.Check("amount", req.Amount)...); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	var hold models.Hold
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := findUserByUID(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		merchant, err := findPayableMerchant(tx, req.MerchantID)
+		if err != nil {
+			return err
+		}
+
+		accountID, err := resolveAccount(tx, user.ID, req.AccountID)
+		if err != nil {
+			return err
+		}
+
+		// Lock the account so concurrent payments cannot spend the held funds
+		accounts, err := lockAccounts(tx, accountID)
+		if err != nil {
+			return err
+		}
+		userAccount := accounts[accountID]
+		if req.Currency != "" && req.Currency != userAccount.Currency {
+			return errCurrencyMismatch
+		}
+		// Merchants are paid in their own currency only
+		if userAccount.Currency != merchant.Currency {
+			return errCurrencyMismatch
+		}
+
+		if availableBalance(userAccount).LessThan(req.Amount) {
+			return errors.New("insufficient balance")
+		}
+
+		// The limits are checked when the funds are reserved; active holds count
+		// towards them and a capture never exceeds the hold, so captures are not
+		// checked again
+		if err := h.checkLimits(r.Context(), tx, user, req.Amount, userAccount.Currency, false); err != nil {
+			return err
+		}
+
+		userAccount.HeldBalance = userAccount.HeldBalance.Add(req.Amount)
+		if err := tx.Model(userAccount).Update("held_balance", userAccount.HeldBalance).Error; err != nil {
+			return err
+		}
+
+		hold = models.Hold{
+			UID:           uuid.New().String(),
+			UserAccountID: userAccount.ID,
+			MerchantID:    merchant.ID,
+			Amount:        req.Amount,
+			Currency:      userAccount.Currency,
+			Status:        holdStatusActive,
+			Remarks:       req.Remarks,
+			ExpiresAt:     time.Now().Add(h.holdTTL()),
+		}
+		if err := tx.Create(&hold).Error; err != nil {
+			return err
+		}
+		hold.UserAccount = *userAccount
+		hold.Merchant = merchant
+		return middlewares.CompleteIdempotencyKey(r.Context(), tx, http.StatusCreated, responses.NewSuccessResponse(newHoldResponse(hold)))
+	})
+
+	if err != nil {
+		writeHoldError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(newHoldResponse(hold)))
+}
+
+// GetHolds lists the holds on the accounts of the caller. It takes the pagination,
+// date, status and amount parameters of GET /transactions; the status is one of
+// ACTIVE, CAPTURED, RELEASED or EXPIRED.
+func (h *AppHandler) GetHolds(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	query, fieldErrors := parseTransactionListQuery(r.URL.Query())
+	switch query.Status {
+	case "", holdStatusActive, holdStatusCaptured, holdStatusReleased, holdStatusExpired:
+	default:
+		fieldErrors = append(fieldErrors, responses.FieldError{Field: "status", Code: "INVALID_VALUE", Message: "must be ACTIVE, CAPTURED, RELEASED or EXPIRED"})
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+	// Holds have no type or category
+	query.TransactionType = ""
+	query.TransactionCategory = ""
+
+	user, err := findUserByUID(h.DB, userID)
+	if err != nil {
+		writeHoldError(w, err)
+		return
+	}
+
+	var holds []models.Hold
+	if err := query.Apply(h.DB.Where("user_account_id IN (?)", accountIDsOfUser(h.DB, user.ID))).
+		Preload("UserAccount", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Preload("Merchant").
+		Preload("PaymentTransaction").
+		Find(&holds).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve holds"))
+		return
+	}
+
+	// The extra row only tells that another page follows
+	var nextCursor *string
+	if len(holds) > query.Limit {
+		holds = holds[:query.Limit]
+		nextCursor = query.nextCursor(holds[len(holds)-1].ID)
+	}
+
+	response := make([]HoldResponse, 0, len(holds))
+	for _, hold := range holds {
+		response = append(response, newHoldResponse(hold))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.PaginatedSuccessResponse{
+		SuccessResponse: responses.NewSuccessResponse(response),
+		NextCursor:      nextCursor,
+	})
+}
+
+// GetMerchantHold returns a hold placed for the calling merchant.
+func (h *AppHandler) GetMerchantHold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	merchant, ok := h.callingMerchant(w, r)
+	if !ok {
+		return
+	}
+
+	holdID := mux.Vars(r)["hold_id"]
+	if _, err := uuid.Parse(holdID); err != nil {
+		writeHoldError(w, errHoldNotFound)
+		return
+	}
+
+	var hold models.Hold
+	err := h.DB.Preload("UserAccount", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Preload("Merchant").
+		Preload("PaymentTransaction").
+		Where("uid = ? AND merchant_id = ?", holdID, merchant.ID).
+		First(&hold).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = errHoldNotFound
+	}
+	if err != nil {
+		writeHoldError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(newHoldResponse(hold)))
+}
+
+// HandleCaptureHold pays the calling merchant all or part of an active hold. The
+// rest of the hold is released.
+func (h *AppHandler) HandleCaptureHold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req HoldCaptureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if fieldErrors := amountDecodeError(err); fieldErrors != nil {
+			writeValidationErrors(w, fieldErrors)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input, the amount is optional
+	if !req.Amount.IsZero() {
+		if fieldErrors := h.AmountPolicy.Check("amount", req.Amount); len(fieldErrors) > 0 {
+			writeValidationErrors(w, fieldErrors)
+			return
+		}
+	}
+
+	merchant, ok := h.callingMerchant(w, r)
+	if !ok {
+		return
+	}
+
+	var hold models.Hold
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var userAccount *models.UserAccount
+		var err error
+		hold, userAccount, err = lockHold(tx, merchant.ID, mux.Vars(r)["hold_id"])
+		if err != nil {
+			return err
+		}
+		amount, err := captureAmount(hold, req.Amount, time.Now())
+		if err != nil {
+			return err
+		}
+		if merchant.Status != merchantStatusActive {
+			return errMerchantNotActive
+		}
+
+		// The whole hold is freed and the captured part debited like a payment
+		paymentTrxID := uuid.New().String()
+		payment := models.PaymentTransaction{
+			UID:           paymentTrxID,
+			UserAccountID: userAccount.ID,
+			Remarks:       hold.Remarks,
+			Amount:        amount,
+			Currency:      hold.Currency,
+			MerchantID:    &merchant.ID,
+			Status:        "SUCCESS",
+		}
+		if err := tx.Create(&payment).Error; err != nil {
+			return err
+		}
+		hold.CapturedAmount = amount
+		hold.PaymentTransactionID = &payment.ID
+		if err := closeHold(tx, &hold, userAccount, holdStatusCaptured); err != nil {
+			return err
+		}
+		if err := debitAccount(tx, userAccount, amount, "PAYMENT", paymentTrxID, hold.Remarks); err != nil {
+			return err
+		}
+
+		// Money leaves the wallet to the settlement account of the merchant
+		if err := postJournal(tx, paymentTrxID, "PAYMENT", hold.Remarks,
+			ledger.Transfer(ledger.UserAccountCode(userAccount.ID), ledger.MerchantAccountCode(merchant.ID), amount),
+			userAccount); err != nil {
+			return err
+		}
+
+		userUID, err := userUIDOfAccount(tx, userAccount.ID)
+		if err != nil {
+			return err
+		}
+		if err := outbox.EnqueueEvent(tx, events.TypePaymentCompleted, events.PaymentCompleted{
+			PaymentID:    paymentTrxID,
+			UserID:       userUID,
+			MerchantID:   merchant.UID,
+			Amount:       amount,
+			Currency:     userAccount.Currency,
+			Remarks:      hold.Remarks,
+			BalanceAfter: userAccount.CurrentBalance,
+		}); err != nil {
+			return err // Return the error to rollback the transaction
+		}
+
+		hold.UserAccount = *userAccount
+		hold.Merchant = merchant
+		hold.PaymentTransaction = &payment
+		return middlewares.CompleteIdempotencyKey(r.Context(), tx, http.StatusOK, responses.NewSuccessResponse(newHoldResponse(hold)))
+	})
+
+	if err != nil {
+		writeHoldError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(newHoldResponse(hold)))
+}
+
+// HandleReleaseHold releases an active hold of the calling merchant without paying it.
+func (h *AppHandler) HandleReleaseHold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	merchant, ok := h.callingMerchant(w, r)
+	if !ok {
+		return
+	}
+
+	var hold models.Hold
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var userAccount *models.UserAccount
+		var err error
+		hold, userAccount, err = lockHold(tx, merchant.ID, mux.Vars(r)["hold_id"])
+		if err != nil {
+			return err
+		}
+		if err := closeHold(tx, &hold, userAccount, holdStatusReleased); err != nil {
+			return err
+		}
+		hold.UserAccount = *userAccount
+		hold.Merchant = merchant
+		return nil
+	})
+
+	if err != nil {
+		writeHoldError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(newHoldResponse(hold)))
+}
+
+// lockHold locks an active hold of the merchant and its account, the account
+// first like every other balance change.
+func lockHold(tx *gorm.DB, merchantID uint, holdUID string) (models.Hold, *models.UserAccount, error) {
+	var hold models.Hold
+	if _, err := uuid.Parse(holdUID); err != nil {
+		return hold, nil, errHoldNotFound
+	}
+
+	var accountID uint
+	err := tx.Model(&models.Hold{}).Select("user_account_id").
+		Where("uid = ? AND merchant_id = ?", holdUID, merchantID).
+		Take(&accountID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return hold, nil, errHoldNotFound
+	}
+	if err != nil {
+		return hold, nil, err
+	}
+
+	accounts, err := lockAccounts(tx, accountID)
+	if err != nil {
+		return hold, nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("uid = ?", holdUID).First(&hold).Error; err != nil {
+		return hold, nil, err
+	}
+	if hold.Status != holdStatusActive {
+		return hold, nil, errHoldNotActive
+	}
+	return hold, accounts[accountID], nil
+}
+
+// captureAmount returns what a capture of requested takes from the hold at now,
+// the whole hold when requested is zero. The rest of the hold is released.
+func captureAmount(hold models.Hold, requested money.Money, now time.Time) (money.Money, error) {
+	if hold.Status != holdStatusActive {
+		return 0, errHoldNotActive
+	}
+	if now.After(hold.ExpiresAt) {
+		return 0, errHoldExpired
+	}
+	if requested.IsZero() {
+		return hold.Amount, nil
+	}
+	if requested.GreaterThan(hold.Amount) {
+		return 0, errCaptureExceedsHold
+	}
+	return requested, nil
+}
+
+// endHold moves an active hold to status at now and frees its whole amount from
+// the held balance of its account. Closed holds are left alone.
+func endHold(hold *models.Hold, userAccount *models.UserAccount, status string, now time.Time) error {
+	if hold.Status != holdStatusActive {
+		return errHoldNotActive
+	}
+	userAccount.HeldBalance = userAccount.HeldBalance.Sub(hold.Amount)
+	hold.Status = status
+	hold.ClosedAt = &now
+	return nil
+}
+
+// closeHold ends an active hold with status and stores it together with the
+// held balance of its locked account.
+func closeHold(tx *gorm.DB, hold *models.Hold, userAccount *models.UserAccount, status string) error {
+	if err := endHold(hold, userAccount, status, time.Now()); err != nil {
+		return err
+	}
+	if err := tx.Model(userAccount).Update("held_balance", userAccount.HeldBalance).Error; err != nil {
+		return err
+	}
+	return tx.Model(hold).Updates(map[string]interface{}{
+		"status":                 hold.Status,
+		"captured_amount":        hold.CapturedAmount,
+		"payment_transaction_id": hold.PaymentTransactionID,
+		"closed_at":              hold.ClosedAt,
+	}).Error
+}
+
+// ExpireHolds expires up to holdSweepBatchSize active holds whose expiry time is
+// not after now, freeing their amounts. Each hold is expired in its own
+// transaction, so a failure leaves the others expired. It returns the number of
+// holds expired.
+func (h *AppHandler) ExpireHolds(ctx context.Context, now time.Time) (int, error) {
+	var due []models.Hold
+	if err := h.DB.WithContext(ctx).Select("id", "user_account_id").
+		Where("status = ? AND expires_at <= ?", holdStatusActive, now).
+		Order("id ASC").
+		Limit(holdSweepBatchSize).
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	var failed error
+	for _, candidate := range due {
+		if err := ctx.Err(); err != nil {
+			return expired, err
+		}
+
+		var closed bool
+		err := h.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			accounts, err := lockAccounts(tx, candidate.UserAccountID)
+			if err != nil {
+				return err
+			}
+			// The merchant may have captured or released it meanwhile
+			var hold models.Hold
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&hold, candidate.ID).Error; err != nil {
+				return err
+			}
+			if hold.Status != holdStatusActive || hold.ExpiresAt.After(now) {
+				return nil
+			}
+			closed = true
+			return closeHold(tx, &hold, accounts[candidate.UserAccountID], holdStatusExpired)
+		})
+		if err != nil {
+			log.Printf("Error expiring hold %d: %v", candidate.ID, err)
+			failed = errors.Join(failed, err)
+			continue
+		}
+		if closed {
+			expired++
+		}
+	}
+	return expired, failed
+}
+
+// RunHoldSweeper expires holds every interval until ctx is cancelled.
+func (h *AppHandler) RunHoldSweeper(ctx context.Context, interval time.Duration) error {
+	for {
+		expired, err := h.ExpireHolds(ctx, time.Now())
+		if err != nil {
+			log.Printf("Failed to expire holds: %v", err)
+		}
+
+		// Keep going while there is a backlog
+		if err == nil && expired == holdSweepBatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
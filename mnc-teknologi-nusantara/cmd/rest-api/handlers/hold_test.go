@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/database/databasetest"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCaptureAmount(t *testing.T) {
+	now := time.Date(2024, 11, 9, 12, 0, 0, 0, time.UTC)
+	active := models.Hold{Amount: money.FromMajor(100), Status: holdStatusActive, ExpiresAt: now.Add(time.Hour)}
+
+	tests := []struct {
+		name      string
+		hold      models.Hold
+		requested money.Money
+		want      money.Money
+		wantErr   error
+	}{
+		{name: "whole hold when omitted", hold: active, want: money.FromMajor(100)},
+		{name: "part of the hold", hold: active, requested: money.FromMajor(30), want: money.FromMajor(30)},
+		{name: "exactly the hold", hold: active, requested: money.FromMajor(100), want: money.FromMajor(100)},
+		{name: "more than the hold", hold: active, requested: money.FromMajor(101), wantErr: errCaptureExceedsHold},
+		{name: "after expiry", hold: models.Hold{Amount: money.FromMajor(100), Status: holdStatusActive, ExpiresAt: now.Add(-time.Second)}, wantErr: errHoldExpired},
+		{name: "at expiry", hold: models.Hold{Amount: money.FromMajor(100), Status: holdStatusActive, ExpiresAt: now}, want: money.FromMajor(100)},
+		{name: "captured", hold: models.Hold{Amount: money.FromMajor(100), Status: holdStatusCaptured, ExpiresAt: now.Add(time.Hour)}, wantErr: errHoldNotActive},
+		{name: "released", hold: models.Hold{Amount: money.FromMajor(100), Status: holdStatusReleased, ExpiresAt: now.Add(time.Hour)}, wantErr: errHoldNotActive},
+		{name: "expired", hold: models.Hold{Amount: money.FromMajor(100), Status: holdStatusExpired, ExpiresAt: now.Add(-time.Hour)}, wantErr: errHoldNotActive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := captureAmount(tt.hold, tt.requested, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndHoldFreesWholeHoldOnPartialCapture(t *testing.T) {
+	now := time.Now()
+	userAccount := models.UserAccount{CurrentBalance: money.FromMajor(500), HeldBalance: money.FromMajor(150)}
+	hold := models.Hold{Amount: money.FromMajor(100), Status: holdStatusActive, ExpiresAt: now.Add(time.Hour)}
+
+	amount, err := captureAmount(hold, money.FromMajor(30), now)
+	if err != nil {
+		t.Fatalf("capture amount: %v", err)
+	}
+	hold.CapturedAmount = amount
+	if err := endHold(&hold, &userAccount, holdStatusCaptured, now); err != nil {
+		t.Fatalf("end hold: %v", err)
+	}
+
+	// The 70.00 not captured goes back to the available balance
+	if want := money.FromMajor(50); userAccount.HeldBalance != want {
+		t.Errorf("got held balance %s, want %s", userAccount.HeldBalance, want)
+	}
+	if hold.Status != holdStatusCaptured || hold.CapturedAmount != money.FromMajor(30) || hold.ClosedAt == nil {
+		t.Errorf("got hold %+v, want captured 30.00 and closed", hold)
+	}
+}
+
+func TestEndHoldTwice(t *testing.T) {
+	now := time.Now()
+	userAccount := models.UserAccount{CurrentBalance: money.FromMajor(500), HeldBalance: money.FromMajor(100)}
+	hold := models.Hold{Amount: money.FromMajor(100), Status: holdStatusActive, ExpiresAt: now.Add(time.Hour)}
+
+	if err := endHold(&hold, &userAccount, holdStatusReleased, now); err != nil {
+		t.Fatalf("first release: %v", err)
+	}
+	if err := endHold(&hold, &userAccount, holdStatusReleased, now); !errors.Is(err, errHoldNotActive) {
+		t.Fatalf("got error %v on second release, want %v", err, errHoldNotActive)
+	}
+	if !userAccount.HeldBalance.IsZero() {
+		t.Errorf("got held balance %s, want 0.00", userAccount.HeldBalance)
+	}
+	if hold.Status != holdStatusReleased {
+		t.Errorf("got status %s, want %s", hold.Status, holdStatusReleased)
+	}
+}
+
+// callAsMerchant invokes a handler of /merchant/holds/{hold_id} as the merchant.
+func callAsMerchant(handler http.HandlerFunc, merchantUID, holdUID string, payload interface{}) int {
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), auth.MerchantIDKey, merchantUID))
+	req = mux.SetURLVars(req, map[string]string{"hold_id": holdUID})
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec.Code
+}
+
+func TestHoldCaptureAndRelease(t *testing.T) {
+	db := databasetest.Open(t)
+	h := &AppHandler{DB: db, AmountPolicy: DefaultAmountPolicy()}
+
+	user, userAccount := createTestUser(t, db, money.FromMajor(100))
+	merchant := createTestMerchant(t, db)
+
+	placeHold := func(amount money.Money) models.Hold {
+		t.Helper()
+		if code := callAsUser(h.HandlePlaceHold, user.UID, HoldRequest{MerchantID: merchant.UID, Amount: amount}); code != http.StatusCreated {
+			t.Fatalf("place hold: got status %d", code)
+		}
+		var hold models.Hold
+		db.Where("user_account_id = ?", userAccount.ID).Order("id DESC").First(&hold)
+		return hold
+	}
+	reload := func() models.UserAccount {
+		var reloaded models.UserAccount
+		db.First(&reloaded, userAccount.ID)
+		return reloaded
+	}
+
+	// A partial capture pays the merchant and releases the rest
+	hold := placeHold(money.FromMajor(40))
+	if code := callAsMerchant(h.HandleCaptureHold, merchant.UID, hold.UID, HoldCaptureRequest{Amount: money.FromMajor(25)}); code != http.StatusOK {
+		t.Fatalf("partial capture: got status %d", code)
+	}
+	if account := reload(); account.CurrentBalance != money.FromMajor(75) || !account.HeldBalance.IsZero() {
+		t.Errorf("after partial capture got balance %s held %s, want 75.00 held 0.00", account.CurrentBalance, account.HeldBalance)
+	}
+
+	// A closed hold cannot be captured again
+	if code := callAsMerchant(h.HandleCaptureHold, merchant.UID, hold.UID, HoldCaptureRequest{}); code != http.StatusConflict {
+		t.Errorf("capture of captured hold: got status %d, want %d", code, http.StatusConflict)
+	}
+
+	// An expired hold cannot be captured, even before the sweeper closes it
+	hold = placeHold(money.FromMajor(10))
+	db.Model(&hold).Update("expires_at", time.Now().Add(-time.Minute))
+	if code := callAsMerchant(h.HandleCaptureHold, merchant.UID, hold.UID, HoldCaptureRequest{}); code != http.StatusUnprocessableEntity {
+		t.Errorf("capture after expiry: got status %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+
+	// Releasing twice frees the amount once
+	if code := callAsMerchant(h.HandleReleaseHold, merchant.UID, hold.UID, nil); code != http.StatusOK {
+		t.Fatalf("release: got status %d", code)
+	}
+	if code := callAsMerchant(h.HandleReleaseHold, merchant.UID, hold.UID, nil); code != http.StatusConflict {
+		t.Errorf("second release: got status %d, want %d", code, http.StatusConflict)
+	}
+	if account := reload(); account.CurrentBalance != money.FromMajor(75) || !account.HeldBalance.IsZero() {
+		t.Errorf("after release got balance %s held %s, want 75.00 held 0.00", account.CurrentBalance, account.HeldBalance)
+	}
+}
+
+func TestHoldsCountTowardsLimitsOnce(t *testing.T) {
+	db := databasetest.Open(t)
+	h := &AppHandler{DB: db, AmountPolicy: DefaultAmountPolicy()}
+
+	user, userAccount := createTestUser(t, db, money.FromMajor(100))
+	merchant := createTestMerchant(t, db)
+
+	usage := func() LimitUsage {
+		t.Helper()
+		usage, err := limitUsage(context.Background(), db, nil, user.ID, time.Now())
+		if err != nil {
+			t.Fatalf("limit usage: %v", err)
+		}
+		return usage
+	}
+
+	// An active hold counts today, even when placed last month
+	if code := callAsUser(h.HandlePlaceHold, user.UID, HoldRequest{MerchantID: merchant.UID, Amount: money.FromMajor(40)}); code != http.StatusCreated {
+		t.Fatalf("place hold: got status %d", code)
+	}
+	var hold models.Hold
+	db.Where("user_account_id = ?", userAccount.ID).Order("id DESC").First(&hold)
+	db.Model(&hold).Update("created_at", time.Now().AddDate(0, -1, -1))
+	if got := usage(); got.Daily != money.FromMajor(40) || got.Monthly != money.FromMajor(40) {
+		t.Errorf("with active hold got %+v, want 40.00 today and this month", got)
+	}
+
+	// Once captured only the payment counts, for the captured amount
+	if code := callAsMerchant(h.HandleCaptureHold, merchant.UID, hold.UID, HoldCaptureRequest{Amount: money.FromMajor(25)}); code != http.StatusOK {
+		t.Fatalf("capture: got status %d", code)
+	}
+	if got := usage(); got.Daily != money.FromMajor(25) || got.Monthly != money.FromMajor(25) {
+		t.Errorf("after capture got %+v, want 25.00 today and this month", got)
+	}
+
+	// A released hold does not count
+	if code := callAsUser(h.HandlePlaceHold, user.UID, HoldRequest{MerchantID: merchant.UID, Amount: money.FromMajor(10)}); code != http.StatusCreated {
+		t.Fatalf("place hold: got status %d", code)
+	}
+	db.Where("user_account_id = ?", userAccount.ID).Order("id DESC").First(&hold)
+	if code := callAsMerchant(h.HandleReleaseHold, merchant.UID, hold.UID, nil); code != http.StatusOK {
+		t.Fatalf("release: got status %d", code)
+	}
+	if got := usage(); got.Daily != money.FromMajor(25) || got.Monthly != money.FromMajor(25) {
+		t.Errorf("after release got %+v, want 25.00 today and this month", got)
+	}
+}
@@ -0,0 +1,557 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/events"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/outbox"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"mnctech-restapi/cmd/rest-api/storage"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Statuses of a KYC submission.
+const (
+	kycStatusPending  = "PENDING"
+	kycStatusApproved = "APPROVED"
+	kycStatusRejected = "REJECTED"
+)
+
+const (
+	maxKYCDocumentSize = 5 << 20 // Largest photo accepted per document
+	maxKYCQueueSize    = 100
+)
+
+// kycDocuments are the photos of a KYC submission, by form field name.
+var kycDocuments = []string{"id_card", "selfie"}
+
+// kycDocumentTypes are the content types accepted for KYC photos.
+var kycDocumentTypes = map[string]bool{"image/jpeg": true, "image/png": true}
+
+var (
+	errKYCAlreadyVerified = errors.New("user is already verified")
+	errKYCPending         = errors.New("a submission is already waiting for review")
+	errKYCNIKInUse        = errors.New("identity number belongs to another user")
+	errKYCNotFound        = errors.New("submission not found")
+	errKYCAlreadyReviewed = errors.New("submission has already been reviewed")
+)
+
+// KYCSubmitRequest holds the form fields of POST /kyc besides the photos.
+type KYCSubmitRequest struct {
+	NIK string `json:"nik" validate:"required,numeric,len=16"`
+}
+
+// KYCReviewRequest represents the expected payload of the admin approve and reject endpoints.
+type KYCReviewRequest struct {
+	Reason string `json:"reason" validate:"max=255"` // Required to reject
+}
+
+// KYCSubmissionResponse represents a KYC submission to its user.
+type KYCSubmissionResponse struct {
+	SubmissionID string     `json:"submission_id"`
+	NIK          string     `json:"nik"`    // Masked for users, complete for admins
+	Status       string     `json:"status"` // PENDING, APPROVED or REJECTED
+	ReviewReason string     `json:"review_reason"`
+	SubmittedAt  time.Time  `json:"submitted_at"`
+	ReviewedAt   *time.Time `json:"reviewed_at"`
+}
+
+// KYCStatusResponse shows the caller's tier and their latest submission.
+type KYCStatusResponse struct {
+	KYCTier    string                 `json:"kyc_tier"`
+	Submission *KYCSubmissionResponse `json:"submission"` // null when nothing was submitted yet
+}
+
+// AdminKYCSubmissionResponse represents a KYC submission in the review queue.
+type AdminKYCSubmissionResponse struct {
+	KYCSubmissionResponse
+	UserID      string `json:"user_id"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	PhoneNumber string `json:"phone_number"`
+	KYCTier     string `json:"kyc_tier"`
+}
+
+// SubmitKYC stores the identity number and photos of the caller for review.
+// It expects a multipart form with the fields nik, id_card and selfie.
+func (h *AppHandler) SubmitKYC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(len(kycDocuments))*maxKYCDocumentSize+1<<20)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	req := KYCSubmitRequest{NIK: r.FormValue("nik")}
+	fieldErrors := validateRequest(req)
+	documents := make(map[string][]byte, len(kycDocuments))
+	for _, name := range kycDocuments {
+		document, fieldError := readKYCDocument(r, name)
+		if fieldError != nil {
+			fieldErrors = append(fieldErrors, *fieldError)
+			continue
+		}
+		documents[name] = document
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	user, err := findUserByUID(h.DB, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		return
+	}
+
+	// Refuse early so the photos are not stored for nothing, the check is repeated under lock below
+	if err := checkKYCSubmittable(h.DB, user, req.NIK); err != nil {
+		writeKYCError(w, err)
+		return
+	}
+
+	submission := models.KYCSubmission{
+		UID:    uuid.New().String(),
+		UserID: user.ID,
+		NIK:    req.NIK,
+		Status: kycStatusPending,
+	}
+	submission.IDCardKey = kycDocumentKey(submission.UID, "id_card")
+	submission.SelfieKey = kycDocumentKey(submission.UID, "selfie")
+
+	for _, name := range kycDocuments {
+		if err := h.Store.Put(r.Context(), kycDocumentKey(submission.UID, name), bytes.NewReader(documents[name])); err != nil {
+			log.Printf("Failed to store KYC document %s of %s: %v", name, submission.UID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to store documents"))
+			return
+		}
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the user so concurrent submissions cannot both pass the checks
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, user.ID).Error; err != nil {
+			return err
+		}
+		if err := checkKYCSubmittable(tx, user, req.NIK); err != nil {
+			return err
+		}
+		return tx.Create(&submission).Error
+	})
+
+	if err != nil {
+		writeKYCError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(newKYCSubmissionResponse(submission, true)))
+}
+
+// readKYCDocument reads the photo in the form field name, returning a FieldError
+// when it is missing, too large or not a JPEG or PNG image.
+func readKYCDocument(r *http.Request, name string) ([]byte, *responses.FieldError) {
+	file, header, err := r.FormFile(name)
+	if err != nil {
+		return nil, &responses.FieldError{Field: name, Code: "REQUIRED", Message: "is required"}
+	}
+	defer file.Close()
+
+	if header.Size > maxKYCDocumentSize {
+		return nil, &responses.FieldError{Field: name, Code: "TOO_LARGE", Message: fmt.Sprintf("must be at most %d MB", maxKYCDocumentSize>>20)}
+	}
+	document, err := io.ReadAll(io.LimitReader(file, maxKYCDocumentSize))
+	if err != nil {
+		return nil, &responses.FieldError{Field: name, Code: "INVALID", Message: "could not be read"}
+	}
+	if !kycDocumentTypes[http.DetectContentType(document)] {
+		return nil, &responses.FieldError{Field: name, Code: "INVALID_FILE_TYPE", Message: "must be a JPEG or PNG image"}
+	}
+	return document, nil
+}
+
+// kycDocumentKey returns the storage key of a photo of a submission.
+func kycDocumentKey(submissionUID, name string) string {
+	return "kyc/" + submissionUID + "/" + name
+}
+
+// checkKYCSubmittable returns why the user cannot submit the identity number, if anything.
+func checkKYCSubmittable(tx *gorm.DB, user models.User, nik string) error {
+	if user.KYCTier == KYCTierVerified {
+		return errKYCAlreadyVerified
+	}
+
+	var pending int64
+	if err := tx.Model(&models.KYCSubmission{}).Where("user_id = ? AND status = ?", user.ID, kycStatusPending).Count(&pending).Error; err != nil {
+		return err
+	}
+	if pending > 0 {
+		return errKYCPending
+	}
+
+	return checkNIKAvailable(tx, user.ID, nik)
+}
+
+// checkNIKAvailable returns errKYCNIKInUse when the identity number was approved
+// for a user other than userID. An identity number verifies a single user.
+func checkNIKAvailable(tx *gorm.DB, userID uint, nik string) error {
+	var taken int64
+	if err := tx.Model(&models.KYCSubmission{}).Where("nik = ? AND status = ? AND user_id <> ?", nik, kycStatusApproved, userID).Count(&taken).Error; err != nil {
+		return err
+	}
+	if taken > 0 {
+		return errKYCNIKInUse
+	}
+	return nil
+}
+
+// writeKYCError responds with the failure of a KYC request.
+func writeKYCError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errKYCAlreadyVerified):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("KYC_ALREADY_VERIFIED", "You are already verified"))
+	case errors.Is(err, errKYCPending):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("KYC_PENDING", "A submission is already waiting for review"))
+	case errors.Is(err, errKYCNIKInUse):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("NIK_IN_USE", "Identity number is already verified for another user"))
+	case errors.Is(err, errKYCNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Submission not found"))
+	case errors.Is(err, errKYCAlreadyReviewed):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("KYC_ALREADY_REVIEWED", "Submission has already been reviewed"))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("KYC request failed"))
+	}
+}
+
+// GetKYC returns the caller's KYC tier and their latest submission.
+func (h *AppHandler) GetKYC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	user, err := findUserByUID(h.DB, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		return
+	}
+
+	response := KYCStatusResponse{KYCTier: user.KYCTier}
+	var submission models.KYCSubmission
+	err = h.DB.Where("user_id = ?", user.ID).Order("id DESC").First(&submission).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve KYC status"))
+		return
+	}
+	if err == nil {
+		submissionResponse := newKYCSubmissionResponse(submission, true)
+		response.Submission = &submissionResponse
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(response))
+}
+
+// newKYCSubmissionResponse converts a submission, masking the identity number for its user.
+func newKYCSubmissionResponse(submission models.KYCSubmission, maskNIK bool) KYCSubmissionResponse {
+	nik := submission.NIK
+	if maskNIK && len(nik) > 4 {
+		nik = strings.Repeat("*", len(nik)-4) + nik[len(nik)-4:]
+	}
+	return KYCSubmissionResponse{
+		SubmissionID: submission.UID,
+		NIK:          nik,
+		Status:       submission.Status,
+		ReviewReason: submission.ReviewReason,
+		SubmittedAt:  submission.CreatedAt,
+		ReviewedAt:   submission.ReviewedAt,
+	}
+}
+
+// GetKYCQueue lists KYC submissions for review, oldest first. The status query
+// parameter defaults to PENDING and limit to 20.
+func (h *AppHandler) GetKYCQueue(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var fieldErrors []responses.FieldError
+	status := kycStatusPending
+	if value := r.URL.Query().Get("status"); value != "" {
+		status = strings.ToUpper(value)
+		if status != kycStatusPending && status != kycStatusApproved && status != kycStatusRejected {
+			fieldErrors = append(fieldErrors, responses.FieldError{Field: "status", Code: "INVALID_VALUE", Message: "must be PENDING, APPROVED or REJECTED"})
+		}
+	}
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxKYCQueueSize {
+			fieldErrors = append(fieldErrors, responses.FieldError{Field: "limit", Code: "OUT_OF_RANGE", Message: fmt.Sprintf("must be between 1 and %d", maxKYCQueueSize)})
+		}
+		limit = parsed
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	var submissions []models.KYCSubmission
+	if err := h.DB.Preload("User").Where("status = ?", status).Order("id ASC").Limit(limit).Find(&submissions).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve KYC submissions"))
+		return
+	}
+
+	queue := make([]AdminKYCSubmissionResponse, 0, len(submissions))
+	for _, submission := range submissions {
+		queue = append(queue, AdminKYCSubmissionResponse{
+			KYCSubmissionResponse: newKYCSubmissionResponse(submission, false),
+			UserID:                submission.User.UID,
+			FirstName:             submission.User.FirstName,
+			LastName:              submission.User.LastName,
+			PhoneNumber:           submission.User.PhoneNumber,
+			KYCTier:               submission.User.KYCTier,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(queue))
+}
+
+// GetKYCDocument streams a photo of a KYC submission to the reviewing admin.
+func (h *AppHandler) GetKYCDocument(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	submission, err := findKYCSubmission(h.DB, vars["submission_id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeKYCError(w, err)
+		return
+	}
+
+	var key string
+	switch vars["document"] {
+	case "id_card":
+		key = submission.IDCardKey
+	case "selfie":
+		key = submission.SelfieKey
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Document not found"))
+		return
+	}
+
+	document, err := h.Store.Get(r.Context(), key)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, storage.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Document not found"))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve document"))
+		return
+	}
+	defer document.Close()
+
+	// Only images were accepted, sniff which one it is
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(document, head)
+	w.Header().Set("Content-Type", http.DetectContentType(head[:n]))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(head[:n])
+	io.Copy(w, document)
+}
+
+// findKYCSubmission loads a submission by its UID.
+func findKYCSubmission(tx *gorm.DB, submissionUID string) (models.KYCSubmission, error) {
+	var submission models.KYCSubmission
+	if _, err := uuid.Parse(submissionUID); err != nil {
+		return submission, errKYCNotFound
+	}
+	err := tx.Where("uid = ?", submissionUID).First(&submission).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return submission, errKYCNotFound
+	}
+	return submission, err
+}
+
+// ApproveKYC verifies the user of a pending submission and raises them to the VERIFIED tier.
+func (h *AppHandler) ApproveKYC(w http.ResponseWriter, r *http.Request) {
+	h.reviewKYC(w, r, kycStatusApproved)
+}
+
+// RejectKYC rejects a pending submission. The user keeps their tier and may submit again.
+func (h *AppHandler) RejectKYC(w http.ResponseWriter, r *http.Request) {
+	h.reviewKYC(w, r, kycStatusRejected)
+}
+
+// reviewKYC records the outcome of a review and notifies the user once it is committed.
+func (h *AppHandler) reviewKYC(w http.ResponseWriter, r *http.Request, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	reviewerID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var req KYCReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+	fieldErrors := validateRequest(req)
+	if status == kycStatusRejected && strings.TrimSpace(req.Reason) == "" {
+		fieldErrors = append(fieldErrors, responses.FieldError{Field: "reason", Code: "REQUIRED", Message: "is required"})
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	var submission models.KYCSubmission
+	var user models.User
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		reviewer, err := findUserByUID(tx, reviewerID)
+		if err != nil {
+			return err
+		}
+
+		// Lock the submission so two admins cannot both review it
+		submission, err = findKYCSubmission(tx.Clauses(clause.Locking{Strength: "UPDATE"}), mux.Vars(r)["submission_id"])
+		if err != nil {
+			return err
+		}
+		if submission.Status != kycStatusPending {
+			return errKYCAlreadyReviewed
+		}
+
+		// Another submission with the identity number may have been approved since
+		// this one was submitted; approvals of the same number wait for each other
+		if status == kycStatusApproved {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "kyc:"+submission.NIK).Error; err != nil {
+				return err
+			}
+			if err := checkNIKAvailable(tx, submission.UserID, submission.NIK); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		submission.Status = status
+		submission.ReviewerID = &reviewer.ID
+		submission.ReviewReason = req.Reason
+		submission.ReviewedAt = &now
+		if err := tx.Save(&submission).Error; err != nil {
+			return err // Return the error to rollback the transaction
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, submission.UserID).Error; err != nil {
+			return err
+		}
+		if status == kycStatusApproved && user.KYCTier != KYCTierVerified {
+			tierChange := models.KYCTierChange{
+				UserID:       user.ID,
+				SubmissionID: &submission.ID,
+				FromTier:     user.KYCTier,
+				ToTier:       KYCTierVerified,
+				ReviewerID:   reviewer.ID,
+				Reason:       req.Reason,
+			}
+			if err := tx.Create(&tierChange).Error; err != nil {
+				return err // Return the error to rollback the transaction
+			}
+			user.KYCTier = KYCTierVerified
+			if err := tx.Model(&user).Update("kyc_tier", user.KYCTier).Error; err != nil {
+				return err // Return the error to rollback the transaction
+			}
+		}
+
+		return outbox.EnqueueEvent(tx, events.TypeKYCReviewed, events.KYCReviewed{
+			SubmissionID: submission.UID,
+			UserID:       user.UID,
+			Status:       submission.Status,
+			KYCTier:      user.KYCTier,
+			Reason:       submission.ReviewReason,
+		})
+	})
+
+	if err != nil {
+		if err.Error() == "user not found" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+			return
+		}
+		writeKYCError(w, err)
+		return
+	}
+
+	h.notifyKYCReviewed(r.Context(), user, submission)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(AdminKYCSubmissionResponse{
+		KYCSubmissionResponse: newKYCSubmissionResponse(submission, false),
+		UserID:                user.UID,
+		FirstName:             user.FirstName,
+		LastName:              user.LastName,
+		PhoneNumber:           user.PhoneNumber,
+		KYCTier:               user.KYCTier,
+	}))
+}
+
+// notifyKYCReviewed tells the user the outcome of their submission. The review
+// is already committed, so a failed notification is only logged.
+func (h *AppHandler) notifyKYCReviewed(ctx context.Context, user models.User, submission models.KYCSubmission) {
+	if h.Notifier == nil {
+		return
+	}
+	message := "Your account has been verified."
+	if submission.Status == kycStatusRejected {
+		message = "Your verification was rejected: " + submission.ReviewReason + ". Please submit your documents again."
+	}
+	if err := h.Notifier.Notify(ctx, user.PhoneNumber, "KYC "+strings.ToLower(submission.Status), message); err != nil {
+		log.Printf("Failed to notify %s of KYC submission %s: %v", user.UID, submission.UID, err)
+	}
+}
@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// KYC tiers of a User. Every user starts at KYCTierBasic.
+const (
+	KYCTierBasic    = "BASIC"
+	KYCTierVerified = "VERIFIED"
+)
+
+// Limits a transaction can exceed.
+const (
+	limitPerTransaction = "PER_TRANSACTION"
+	limitDaily          = "DAILY"
+	limitMonthly        = "MONTHLY"
+	limitMaxBalance     = "MAX_BALANCE"
+)
+
+// LimitRule bounds what a user of one KYC tier can do. A zero limit is unlimited.
+// Limits are in currency.Base; amounts in other currencies count at the mid rate.
+type LimitRule struct {
+	PerTransaction money.Money `json:"per_transaction"` // Largest single top-up, payment or transfer
+	Daily          money.Money `json:"daily"`           // Top-ups, payments and outgoing transfers per calendar day
+	Monthly        money.Money `json:"monthly"`         // The same per calendar month
+	MaxBalance     money.Money `json:"max_balance"`     // Largest total of the user's balances top-ups and incoming transfers may reach
+}
+
+// LimitPolicy holds the LimitRule of every KYC tier.
+type LimitPolicy map[string]LimitRule
+
+// DefaultLimitPolicy returns the limits used when none are configured.
+func DefaultLimitPolicy() LimitPolicy {
+	return LimitPolicy{
+		KYCTierBasic: {
+			PerTransaction: money.FromMajor(2_000_000),
+			Daily:          money.FromMajor(5_000_000),
+			Monthly:        money.FromMajor(20_000_000),
+			MaxBalance:     money.FromMajor(2_000_000),
+		},
+		KYCTierVerified: {
+			PerTransaction: money.FromMajor(20_000_000),
+			Daily:          money.FromMajor(50_000_000),
+			Monthly:        money.FromMajor(100_000_000),
+			MaxBalance:     money.FromMajor(20_000_000),
+		},
+	}
+}
+
+// LoadLimitPolicy reads a JSON file mapping tiers to rules, e.g.
+// {"BASIC": {"per_transaction": "2000000", "daily": "5000000", "monthly": "20000000", "max_balance": "2000000"}}.
+// Tiers missing from the file keep their default rule.
+func LoadLimitPolicy(path string) (LimitPolicy, error) {
+	policy := DefaultLimitPolicy()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules LimitPolicy
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for tier, rule := range rules {
+		policy[tier] = rule
+	}
+	return policy, nil
+}
+
+// Rule returns the rule of the tier. Unknown tiers get the rule of KYCTierBasic.
+func (p LimitPolicy) Rule(tier string) LimitRule {
+	if rule, ok := p[tier]; ok {
+		return rule
+	}
+	return p[KYCTierBasic]
+}
+
+// LimitExceededError reports which limit a transaction would exceed.
+type LimitExceededError struct {
+	Limit     string      // PER_TRANSACTION, DAILY, MONTHLY or MAX_BALANCE
+	Remaining money.Money // What the user can still do within the limit
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s limit exceeded, %s remaining", e.Limit, e.Remaining)
+}
+
+// LimitUsage is what a user has used of their daily and monthly limits.
+type LimitUsage struct {
+	Daily   money.Money
+	Monthly money.Money
+}
+
+// limitUsage adds up the top-ups, payments and outgoing transfers of all accounts
+// of the user today and this month, in currency.Base, together with their active
+// holds. A hold counts when it is captured, as the payment it became for the
+// captured amount; until then it counts today and this month whenever it was
+// placed, since it can be captured at any moment. Released and expired holds do
+// not count, nor do incoming transfers, refunds, moves and conversions between
+// the user's own accounts.
+func limitUsage(ctx context.Context, tx *gorm.DB, rates currency.FxRateProvider, userID uint, now time.Time) (LimitUsage, error) {
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	type usageRow struct {
+		Currency string
+		Daily    money.Money
+		Monthly  money.Money
+	}
+	var rows []usageRow
+	err := tx.Model(&models.AccountTransactionLog{}).
+		Select("currency, COALESCE(SUM(CASE WHEN created_at >= ? THEN amount ELSE 0 END), 0) AS daily, COALESCE(SUM(amount), 0) AS monthly", dayStart).
+		Where("user_account_id IN (?) AND status = ? AND created_at >= ?", accountIDsOfUser(tx, userID), "SUCCESS", monthStart).
+		Where("(transaction_category = ? OR (transaction_category IN ? AND transaction_type = ?))", "TOPUP", []string{"PAYMENT", "TRANSFER"}, "DEBIT").
+		Group("currency").
+		Scan(&rows).Error
+	if err != nil {
+		return LimitUsage{}, err
+	}
+
+	// Funds held for merchants count as spent now until the hold is closed
+	var held []struct {
+		Currency string
+		Amount   money.Money
+	}
+	err = tx.Model(&models.Hold{}).
+		Select("currency, COALESCE(SUM(amount), 0) AS amount").
+		Where("user_account_id IN (?) AND status = ?", accountIDsOfUser(tx, userID), holdStatusActive).
+		Group("currency").
+		Scan(&held).Error
+	if err != nil {
+		return LimitUsage{}, err
+	}
+	for _, hold := range held {
+		rows = append(rows, usageRow{Currency: hold.Currency, Daily: hold.Amount, Monthly: hold.Amount})
+	}
+
+	var usage LimitUsage
+	for _, row := range rows {
+		daily, err := currency.ToBase(ctx, rates, row.Daily, row.Currency)
+		if err != nil {
+			return LimitUsage{}, err
+		}
+		monthly, err := currency.ToBase(ctx, rates, row.Monthly, row.Currency)
+		if err != nil {
+			return LimitUsage{}, err
+		}
+		usage.Daily = usage.Daily.Add(daily)
+		usage.Monthly = usage.Monthly.Add(monthly)
+	}
+	return usage, nil
+}
+
+// checkLimits returns a *LimitExceededError when the user may not move amount,
+// in the currency code, in or out of one of their accounts. incoming is true for
+// top-ups, which also have to respect the maximum balance. The caller locks the
+// account first.
+func (h *AppHandler) checkLimits(ctx context.Context, tx *gorm.DB, user models.User, amount money.Money, code string, incoming bool) error {
+	rule := h.Limits.Rule(user.KYCTier)
+
+	amount, err := currency.ToBase(ctx, h.FxRates, amount, code)
+	if err != nil {
+		return err
+	}
+	if !rule.PerTransaction.IsZero() && amount.GreaterThan(rule.PerTransaction) {
+		return &LimitExceededError{Limit: limitPerTransaction, Remaining: rule.PerTransaction}
+	}
+
+	// Lock the user so transactions on different accounts of the user are counted one after another
+	if err := lockUserForLimits(tx, user.ID); err != nil {
+		return err
+	}
+
+	usage, err := limitUsage(ctx, tx, h.FxRates, user.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	if !rule.Daily.IsZero() && usage.Daily.Add(amount).GreaterThan(rule.Daily) {
+		return &LimitExceededError{Limit: limitDaily, Remaining: remaining(rule.Daily, usage.Daily)}
+	}
+	if !rule.Monthly.IsZero() && usage.Monthly.Add(amount).GreaterThan(rule.Monthly) {
+		return &LimitExceededError{Limit: limitMonthly, Remaining: remaining(rule.Monthly, usage.Monthly)}
+	}
+
+	if incoming {
+		return h.checkMaxBalance(ctx, tx, user, amount, currency.Base)
+	}
+	return nil
+}
+
+// checkMaxBalance returns a *LimitExceededError when crediting amount, in the
+// currency code, to one of the user's accounts would take the total of their
+// balances above the maximum balance of their tier. The caller locks the account
+// first.
+func (h *AppHandler) checkMaxBalance(ctx context.Context, tx *gorm.DB, user models.User, amount money.Money, code string) error {
+	rule := h.Limits.Rule(user.KYCTier)
+	if rule.MaxBalance.IsZero() {
+		return nil
+	}
+
+	amount, err := currency.ToBase(ctx, h.FxRates, amount, code)
+	if err != nil {
+		return err
+	}
+	if err := lockUserForLimits(tx, user.ID); err != nil {
+		return err
+	}
+	balance, err := totalBalance(ctx, tx, h.FxRates, user.ID)
+	if err != nil {
+		return err
+	}
+	if balance.Add(amount).GreaterThan(rule.MaxBalance) {
+		return &LimitExceededError{Limit: limitMaxBalance, Remaining: remaining(rule.MaxBalance, balance)}
+	}
+	return nil
+}
+
+// lockUserForLimits locks the user row. Transactions that move money lock their
+// accounts first and the user only here, so this cannot deadlock with another
+// limit check.
+func lockUserForLimits(tx *gorm.DB, userID uint) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, userID).Error
+}
+
+// totalBalance adds up the balances of the user's open accounts in currency.Base.
+func totalBalance(ctx context.Context, tx *gorm.DB, rates currency.FxRateProvider, userID uint) (money.Money, error) {
+	var rows []struct {
+		Currency string
+		Balance  money.Money
+	}
+	err := tx.Model(&models.UserAccount{}).Where("user_id = ?", userID).
+		Select("currency, COALESCE(SUM(current_balance), 0) AS balance").
+		Group("currency").
+		Scan(&rows).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var balance money.Money
+	for _, row := range rows {
+		converted, err := currency.ToBase(ctx, rates, row.Balance, row.Currency)
+		if err != nil {
+			return 0, err
+		}
+		balance = balance.Add(converted)
+	}
+	return balance, nil
+}
+
+// remaining returns what is left of limit after used, never less than zero.
+func remaining(limit, used money.Money) money.Money {
+	if used.GreaterThan(limit) {
+		return 0
+	}
+	return limit.Sub(used)
+}
+
+// remainingOf returns what is left of limit after used, or nil when the limit is zero and so unlimited.
+func remainingOf(limit, used money.Money) *money.Money {
+	if limit.IsZero() {
+		return nil
+	}
+	left := remaining(limit, used)
+	return &left
+}
+
+// writeLimitExceeded responds with the limit a transaction would exceed.
+func writeLimitExceeded(w http.ResponseWriter, err *LimitExceededError) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(LimitExceededResponse{
+		FailedResponse: responses.NewFailedResponseWithCode("LIMIT_EXCEEDED", "Transaction exceeds your "+limitNames[err.Limit]),
+		Limit:          err.Limit,
+		Remaining:      err.Remaining,
+	})
+}
+
+var limitNames = map[string]string{
+	limitPerTransaction: "per transaction limit",
+	limitDaily:          "daily limit",
+	limitMonthly:        "monthly limit",
+	limitMaxBalance:     "maximum balance",
+}
+
+// LimitExceededResponse is the failed response of a transaction over a limit.
+type LimitExceededResponse struct {
+	responses.FailedResponse
+	Limit     string      `json:"limit"`     // PER_TRANSACTION, DAILY, MONTHLY or MAX_BALANCE
+	Remaining money.Money `json:"remaining"` // What the user can still do within the limit
+}
+
+// LimitsResponse shows the limits of the caller's tier and what is left of them.
+// The remaining amounts are null for unlimited limits.
+type LimitsResponse struct {
+	KYCTier             string       `json:"kyc_tier"`
+	PerTransaction      money.Money  `json:"per_transaction"`
+	Daily               money.Money  `json:"daily"`
+	DailyUsed           money.Money  `json:"daily_used"`
+	DailyRemaining      *money.Money `json:"daily_remaining"`
+	Monthly             money.Money  `json:"monthly"`
+	MonthlyUsed         money.Money  `json:"monthly_used"`
+	MonthlyRemaining    *money.Money `json:"monthly_remaining"`
+	MaxBalance          money.Money  `json:"max_balance"`
+	MaxBalanceRemaining *money.Money `json:"max_balance_remaining"` // How much more the balances of all accounts together may grow
+}
+
+// GetLimits returns the limits of the caller's KYC tier with the remaining quota.
+// A zero limit is unlimited.
+func (h *AppHandler) GetLimits(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	user, err := findUserByUID(h.DB, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		return
+	}
+
+	balance, err := totalBalance(r.Context(), h.DB, h.FxRates, user.ID)
+	if err != nil {
+		writeLimitsError(w, err)
+		return
+	}
+	usage, err := limitUsage(r.Context(), h.DB, h.FxRates, user.ID, time.Now())
+	if err != nil {
+		writeLimitsError(w, err)
+		return
+	}
+
+	rule := h.Limits.Rule(user.KYCTier)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(LimitsResponse{
+		KYCTier:             user.KYCTier,
+		PerTransaction:      rule.PerTransaction,
+		Daily:               rule.Daily,
+		DailyUsed:           usage.Daily,
+		DailyRemaining:      remainingOf(rule.Daily, usage.Daily),
+		Monthly:             rule.Monthly,
+		MonthlyUsed:         usage.Monthly,
+		MonthlyRemaining:    remainingOf(rule.Monthly, usage.Monthly),
+		MaxBalance:          rule.MaxBalance,
+		MaxBalanceRemaining: remainingOf(rule.MaxBalance, balance),
+	}))
+}
+
+// writeLimitsError responds with the failure to add up what the user has used.
+func writeLimitsError(w http.ResponseWriter, err error) {
+	if errors.Is(err, currency.ErrNoRate) {
+		writeFxUnavailable(w)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve limits"))
+}
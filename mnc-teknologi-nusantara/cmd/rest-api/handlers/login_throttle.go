@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	loginThrottleScopePhone = "PHONE"
+	loginThrottleScopeIP    = "IP"
+)
+
+// LoginThrottleConfig controls when failed logins lock a phone number or client IP.
+type LoginThrottleConfig struct {
+	MaxAttempts int           // Failed attempts allowed before a lock is applied
+	Cooldown    time.Duration // Duration of the first lock, doubled for every consecutive lock
+	MaxCooldown time.Duration // Upper bound of a single lock, also the idle time after which counters reset
+}
+
+// DefaultLoginThrottleConfig returns the throttle settings used when none are configured.
+func DefaultLoginThrottleConfig() LoginThrottleConfig {
+	return LoginThrottleConfig{
+		MaxAttempts: 5,
+		Cooldown:    5 * time.Minute,
+		MaxCooldown: 24 * time.Hour,
+	}
+}
+
+type AccountLockedResponse struct {
+	responses.FailedResponse
+	LockedUntil       time.Time `json:"locked_until"`
+	RetryAfterSeconds int       `json:"retry_after_seconds"`
+}
+
+// lockDuration returns how long a key is locked for its n-th consecutive lock.
+func (c LoginThrottleConfig) lockDuration(lockCount int) time.Duration {
+	duration := time.Duration(float64(c.Cooldown) * math.Pow(2, float64(lockCount-1)))
+	if duration <= 0 || duration > c.MaxCooldown {
+		return c.MaxCooldown
+	}
+	return duration
+}
+
+// loginThrottleKeys returns the keys a login attempt of the phone number from the
+// client IP counts against.
+func loginThrottleKeys(phoneNumber, ip string) []struct{ scope, key string } {
+	return []struct{ scope, key string }{
+		{loginThrottleScopePhone, phoneNumber},
+		{loginThrottleScopeIP, ip},
+	}
+}
+
+// lockLoginThrottle locks the counter of the key within tx, creating it first.
+func lockLoginThrottle(tx *gorm.DB, scope, key string) (models.LoginThrottle, error) {
+	throttle := models.LoginThrottle{Scope: scope, Key: key}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&throttle).Error; err != nil {
+		return models.LoginThrottle{}, err
+	}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("scope = ? AND key = ?", scope, key).
+		First(&throttle).Error
+	return throttle, err
+}
+
+// lock locks the key of the throttle for its next consecutive lock and records it.
+func (c LoginThrottleConfig) lock(tx *gorm.DB, throttle *models.LoginThrottle, ip string, now time.Time) (time.Time, error) {
+	throttle.FailedCount = 0
+	throttle.LockCount++
+	until := now.Add(c.lockDuration(throttle.LockCount))
+	throttle.LockedUntil = &until
+
+	lockEvent := models.LoginLockEvent{
+		Scope:       throttle.Scope,
+		Key:         throttle.Key,
+		LockCount:   throttle.LockCount,
+		IPAddress:   ip,
+		LockedUntil: until,
+	}
+	if err := tx.Create(&lockEvent).Error; err != nil {
+		return time.Time{}, err
+	}
+	log.Printf("Login locked for %s %s until %s", throttle.Scope, throttle.Key, until.Format(time.RFC3339))
+	return until, nil
+}
+
+// reserveLoginAttempt counts a login attempt against the phone number and client IP
+// before its PIN is checked, so concurrent attempts cannot all pass the check
+// before any failure is counted. It returns the lock refusing the attempt, if any;
+// a key whose attempts are all in use is locked. An attempt that was let through
+// ends with recordLoginFailure or releaseLoginAttempt.
+func (c LoginThrottleConfig) reserveLoginAttempt(db *gorm.DB, phoneNumber, ip string) (*time.Time, error) {
+	var lockedUntil *time.Time
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		throttles := make([]models.LoginThrottle, 0, 2)
+		for _, k := range loginThrottleKeys(phoneNumber, ip) {
+			throttle, err := lockLoginThrottle(tx, k.scope, k.key)
+			if err != nil {
+				return err
+			}
+
+			// Forget old failures once the key has been quiet for long enough
+			if throttle.LastFailedAt != nil && now.Sub(*throttle.LastFailedAt) > c.MaxCooldown {
+				throttle.FailedCount = 0
+				throttle.LockCount = 0
+			}
+
+			switch {
+			case throttle.LockedUntil != nil && throttle.LockedUntil.After(now):
+			case throttle.FailedCount >= c.MaxAttempts:
+				if _, err := c.lock(tx, &throttle, ip, now); err != nil {
+					return err
+				}
+				if err := tx.Save(&throttle).Error; err != nil {
+					return err
+				}
+			default:
+				throttles = append(throttles, throttle)
+				continue
+			}
+			if lockedUntil == nil || throttle.LockedUntil.After(*lockedUntil) {
+				lockedUntil = throttle.LockedUntil
+			}
+		}
+		if lockedUntil != nil {
+			return nil // Refused, nothing to count
+		}
+
+		for i := range throttles {
+			throttles[i].FailedCount++
+			throttles[i].LastFailedAt = &now
+			if err := tx.Save(&throttles[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lockedUntil, nil
+}
+
+// recordLoginFailure ends an attempt reserved by reserveLoginAttempt whose PIN was
+// wrong. The attempt was already counted, so it locks the phone number and client IP
+// when it used up their last attempt, and returns the lock expiry when it did.
+func (c LoginThrottleConfig) recordLoginFailure(db *gorm.DB, phoneNumber, ip string) (*time.Time, error) {
+	var lockedUntil *time.Time
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		for _, k := range loginThrottleKeys(phoneNumber, ip) {
+			throttle, err := lockLoginThrottle(tx, k.scope, k.key)
+			if err != nil {
+				return err
+			}
+			if throttle.FailedCount < c.MaxAttempts || (throttle.LockedUntil != nil && throttle.LockedUntil.After(now)) {
+				continue
+			}
+
+			until, err := c.lock(tx, &throttle, ip, now)
+			if err != nil {
+				return err
+			}
+			if err := tx.Save(&throttle).Error; err != nil {
+				return err
+			}
+			if lockedUntil == nil || until.After(*lockedUntil) {
+				lockedUntil = &until
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lockedUntil, nil
+}
+
+// releaseLoginAttempt ends an attempt reserved by reserveLoginAttempt whose PIN was
+// right. The failures of the phone number are forgotten unless a concurrent attempt
+// locked it meanwhile, and the client IP gets its attempt back.
+func releaseLoginAttempt(tx *gorm.DB, phoneNumber, ip string) error {
+	if err := tx.Model(&models.LoginThrottle{}).
+		Where("scope = ? AND key = ?", loginThrottleScopePhone, phoneNumber).
+		Where("locked_until IS NULL OR locked_until <= ?", time.Now()).
+		Updates(map[string]interface{}{
+			"failed_count": 0,
+			"lock_count":   0,
+			"locked_until": nil,
+		}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.LoginThrottle{}).
+		Where("scope = ? AND key = ? AND failed_count > 0", loginThrottleScopeIP, ip).
+		Update("failed_count", gorm.Expr("failed_count - 1")).Error
+}
+
+// resetLoginFailures clears the failure counter and lock of a phone number, once
+// the user proved they own it.
+func resetLoginFailures(db *gorm.DB, phoneNumber string) error {
+	return db.Model(&models.LoginThrottle{}).
+		Where("scope = ? AND key = ?", loginThrottleScopePhone, phoneNumber).
+		Updates(map[string]interface{}{
+			"failed_count": 0,
+			"lock_count":   0,
+			"locked_until": nil,
+		}).Error
+}
+
+// writeAccountLocked responds that login is locked until the given time.
+func writeAccountLocked(w http.ResponseWriter, lockedUntil time.Time) {
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(http.StatusLocked)
+	json.NewEncoder(w).Encode(AccountLockedResponse{
+		FailedResponse:    responses.NewFailedResponseWithCode("ACCOUNT_LOCKED", "Too many failed login attempts"),
+		LockedUntil:       lockedUntil,
+		RetryAfterSeconds: retryAfter,
+	})
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"mnctech-restapi/cmd/rest-api/database/databasetest"
+	"mnctech-restapi/cmd/rest-api/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// newThrottleKeys returns a phone number and client IP of their own, whose counters are removed after the test.
+func newThrottleKeys(t *testing.T, db *gorm.DB) (string, string) {
+	t.Helper()
+	phoneNumber, ip := uuid.New().String(), uuid.New().String()
+	t.Cleanup(func() {
+		db.Unscoped().Where("key IN ?", []string{phoneNumber, ip}).Delete(&models.LoginThrottle{})
+		db.Unscoped().Where("key IN ?", []string{phoneNumber, ip}).Delete(&models.LoginLockEvent{})
+	})
+	return phoneNumber, ip
+}
+
+func TestParallelLoginAttemptsStopAtMaxAttempts(t *testing.T) {
+	db := databasetest.Open(t)
+	throttle := LoginThrottleConfig{MaxAttempts: 5, Cooldown: time.Minute, MaxCooldown: time.Hour}
+	phoneNumber, ip := newThrottleKeys(t, db)
+
+	const workers = 20
+	var wg sync.WaitGroup
+	allowed := make(chan bool, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lockedUntil, err := throttle.reserveLoginAttempt(db, phoneNumber, ip)
+			if err != nil {
+				t.Errorf("reserve: %v", err)
+				return
+			}
+			allowed <- lockedUntil == nil
+		}()
+	}
+	wg.Wait()
+	close(allowed)
+
+	count := 0
+	for ok := range allowed {
+		if ok {
+			count++
+		}
+	}
+	if count != throttle.MaxAttempts {
+		t.Errorf("got %d attempts let through, want %d", count, throttle.MaxAttempts)
+	}
+
+	// A right PIN among them does not lift the lock the others caused
+	if err := releaseLoginAttempt(db, phoneNumber, ip); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	lockedUntil, err := throttle.reserveLoginAttempt(db, phoneNumber, ip)
+	if err != nil {
+		t.Fatalf("reserve after release: %v", err)
+	}
+	if lockedUntil == nil {
+		t.Errorf("attempt let through after the lock")
+	}
+}
+
+func TestLoginFailureLocksOnLastAttempt(t *testing.T) {
+	db := databasetest.Open(t)
+	throttle := LoginThrottleConfig{MaxAttempts: 3, Cooldown: time.Minute, MaxCooldown: time.Hour}
+	phoneNumber, ip := newThrottleKeys(t, db)
+
+	for attempt := 1; attempt <= throttle.MaxAttempts; attempt++ {
+		if lockedUntil, err := throttle.reserveLoginAttempt(db, phoneNumber, ip); err != nil || lockedUntil != nil {
+			t.Fatalf("attempt %d: got lock %v, error %v", attempt, lockedUntil, err)
+		}
+		lockedUntil, err := throttle.recordLoginFailure(db, phoneNumber, ip)
+		if err != nil {
+			t.Fatalf("attempt %d: record failure: %v", attempt, err)
+		}
+		if locked := lockedUntil != nil; locked != (attempt == throttle.MaxAttempts) {
+			t.Errorf("attempt %d: got locked %v", attempt, locked)
+		}
+	}
+
+	// A right PIN after a few failures starts the count again, for the phone number and the client IP
+	phoneNumber, ip = newThrottleKeys(t, db)
+	for attempt := 1; attempt < throttle.MaxAttempts; attempt++ {
+		throttle.reserveLoginAttempt(db, phoneNumber, ip)
+		throttle.recordLoginFailure(db, phoneNumber, ip)
+	}
+	throttle.reserveLoginAttempt(db, phoneNumber, ip)
+	if err := releaseLoginAttempt(db, phoneNumber, ip); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	var throttles []models.LoginThrottle
+	db.Where("key IN ?", []string{phoneNumber, ip}).Find(&throttles)
+	for _, got := range throttles {
+		want := 0
+		if got.Scope == loginThrottleScopeIP {
+			want = throttle.MaxAttempts - 1 // Only the successful attempt is given back
+		}
+		if got.FailedCount != want {
+			t.Errorf("got %s failed count %d, want %d", got.Scope, got.FailedCount, want)
+		}
+	}
+}
@@ -0,0 +1,553 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/middlewares"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Statuses of a merchant. Suspended merchants can neither be paid nor use their API key.
+const (
+	merchantStatusActive    = "ACTIVE"
+	merchantStatusSuspended = "SUSPENDED"
+)
+
+// Defaults of the fields merchants show in their QR codes.
+const (
+	defaultMerchantCity         = "JAKARTA"
+	defaultMerchantCategoryCode = "5999" // Miscellaneous and specialty retail stores
+)
+
+var (
+	errMerchantNotFound  = errors.New("merchant not found")
+	errMerchantNotActive = errors.New("merchant does not accept payments")
+)
+
+// MerchantRequest represents the expected payload of POST /admin/merchants.
+type MerchantRequest struct {
+	Name                string `json:"name" validate:"required,max=100"`
+	Currency            string `json:"currency" validate:"omitempty,len=3"` // Defaults to IDR
+	PayoutBankCode      string `json:"payout_bank_code" validate:"required,max=20"`
+	PayoutAccountNumber string `json:"payout_account_number" validate:"required,numeric,max=34"`
+	PayoutAccountName   string `json:"payout_account_name" validate:"required,max=100"`
+	City                string `json:"city" validate:"omitempty,max=15"`                 // Shown in QR codes, defaults to JAKARTA
+	CategoryCode        string `json:"category_code" validate:"omitempty,numeric,len=4"` // ISO 18245, defaults to 5999
+	PostalCode          string `json:"postal_code" validate:"omitempty,numeric,max=10"`
+}
+
+// MerchantStatusRequest represents the expected payload of PUT /admin/merchants/{merchant_id}/status.
+type MerchantStatusRequest struct {
+	Status string `json:"status" validate:"required,oneof=ACTIVE SUSPENDED"`
+}
+
+// MerchantRefundRequest represents the expected payload of POST /merchant/refunds.
+type MerchantRefundRequest struct {
+	PaymentID string      `json:"payment_id" validate:"required,uuid"`
+	Amount    money.Money `json:"amount"` // Omit to refund everything not refunded yet
+	Reason    string      `json:"reason" validate:"required,max=255"`
+}
+
+// MerchantResponse represents a merchant to admins.
+type MerchantResponse struct {
+	MerchantID          string    `json:"merchant_id"`
+	Name                string    `json:"name"`
+	Currency            string    `json:"currency"`
+	Status              string    `json:"status"`
+	APIKeyID            string    `json:"api_key_id"`
+	PayoutBankCode      string    `json:"payout_bank_code"`
+	PayoutAccountNumber string    `json:"payout_account_number"`
+	PayoutAccountName   string    `json:"payout_account_name"`
+	City                string    `json:"city"`
+	CategoryCode        string    `json:"category_code"`
+	PostalCode          string    `json:"postal_code"`
+	CreatedDate         time.Time `json:"created_date"`
+}
+
+// MerchantCredentialsResponse carries a new API key. The key cannot be retrieved again.
+type MerchantCredentialsResponse struct {
+	MerchantResponse
+	APIKey string `json:"api_key"` // Send as the X-API-Key header
+}
+
+// MerchantPaymentResponse represents a payment received by the calling merchant.
+type MerchantPaymentResponse struct {
+	PaymentID    string      `json:"payment_id"`
+	Amount       money.Money `json:"amount"`
+	Currency     string      `json:"currency"`
+	Status       string      `json:"status"` // SUCCESS, PARTIALLY_REFUNDED or REFUNDED
+	Remarks      string      `json:"remarks"`
+	SettlementID *string     `json:"settlement_id"` // null until the payment is settled
+	CreatedDate  time.Time   `json:"created_date"`
+}
+
+// MerchantSettlementResponse represents a settlement batch of the calling merchant.
+type MerchantSettlementResponse struct {
+	SettlementID        string      `json:"settlement_id"`
+	BusinessDate        string      `json:"business_date"` // YYYY-MM-DD
+	Currency            string      `json:"currency"`
+	GrossAmount         money.Money `json:"gross_amount"`
+	RefundAmount        money.Money `json:"refund_amount"`
+	NetAmount           money.Money `json:"net_amount"`
+	PaymentCount        int         `json:"payment_count"`
+	RefundCount         int         `json:"refund_count"`
+	PayoutBankCode      string      `json:"payout_bank_code"`
+	PayoutAccountNumber string      `json:"payout_account_number"`
+	PayoutAccountName   string      `json:"payout_account_name"`
+	CreatedDate         time.Time   `json:"created_date"`
+}
+
+func newMerchantResponse(merchant models.Merchant) MerchantResponse {
+	return MerchantResponse{
+		MerchantID:          merchant.UID,
+		Name:                merchant.Name,
+		Currency:            merchant.Currency,
+		Status:              merchant.Status,
+		APIKeyID:            merchant.APIKeyID,
+		PayoutBankCode:      merchant.PayoutBankCode,
+		PayoutAccountNumber: merchant.PayoutAccountNumber,
+		PayoutAccountName:   merchant.PayoutAccountName,
+		City:                merchant.City,
+		CategoryCode:        merchant.CategoryCode,
+		PostalCode:          merchant.PostalCode,
+		CreatedDate:         merchant.CreatedAt,
+	}
+}
+
+// newMerchantAPIKey returns a key of the form "<key id>.<secret>" along with the
+// key ID and the hash of the secret to store.
+func newMerchantAPIKey() (key, keyID, secretHash string, err error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", "", err
+	}
+	secret, err := newOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	keyID = "mk_" + hex.EncodeToString(id)
+	return keyID + "." + secret, keyID, auth.HashToken(secret), nil
+}
+
+// findMerchant loads a merchant by its UID.
+func findMerchant(tx *gorm.DB, merchantUID string) (models.Merchant, error) {
+	var merchant models.Merchant
+	if _, err := uuid.Parse(merchantUID); err != nil {
+		return merchant, errMerchantNotFound
+	}
+	err := tx.Where("uid = ?", merchantUID).First(&merchant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return merchant, errMerchantNotFound
+	}
+	return merchant, err
+}
+
+// findPayableMerchant loads a merchant that accepts payments.
+func findPayableMerchant(tx *gorm.DB, merchantUID string) (models.Merchant, error) {
+	merchant, err := findMerchant(tx, merchantUID)
+	if err != nil {
+		return merchant, err
+	}
+	if merchant.Status != merchantStatusActive {
+		return merchant, errMerchantNotActive
+	}
+	return merchant, nil
+}
+
+// writeMerchantError responds with the failure of a merchant lookup.
+func writeMerchantError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errMerchantNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("MERCHANT_NOT_FOUND", "Merchant not found"))
+	case errors.Is(err, errMerchantNotActive):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("MERCHANT_NOT_ACTIVE", "Merchant does not accept payments"))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve merchant"))
+	}
+}
+
+// CreateMerchant registers a merchant and returns its first API key.
+func (h *AppHandler) CreateMerchant(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req MerchantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	req.Currency = strings.ToUpper(req.Currency)
+	if req.Currency == "" {
+		req.Currency = currency.Base
+	}
+	req.City = strings.ToUpper(req.City)
+	if req.City == "" {
+		req.City = defaultMerchantCity
+	}
+	if req.CategoryCode == "" {
+		req.CategoryCode = defaultMerchantCategoryCode
+	}
+	if fieldErrors := append(validateRequest(req), checkCurrency("currency", req.Currency)...); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	key, keyID, secretHash, err := newMerchantAPIKey()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to create merchant"))
+		return
+	}
+
+	merchant := models.Merchant{
+		UID:                 uuid.New().String(),
+		Name:                req.Name,
+		Currency:            req.Currency,
+		Status:              merchantStatusActive,
+		APIKeyID:            keyID,
+		APIKeyHash:          secretHash,
+		PayoutBankCode:      req.PayoutBankCode,
+		PayoutAccountNumber: req.PayoutAccountNumber,
+		PayoutAccountName:   req.PayoutAccountName,
+		City:                req.City,
+		CategoryCode:        req.CategoryCode,
+		PostalCode:          req.PostalCode,
+	}
+	if err := h.DB.Create(&merchant).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to create merchant"))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(MerchantCredentialsResponse{
+		MerchantResponse: newMerchantResponse(merchant),
+		APIKey:           key,
+	}))
+}
+
+// GetMerchants lists the merchants, oldest first. The optional status query
+// parameter is ACTIVE or SUSPENDED.
+func (h *AppHandler) GetMerchants(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := h.DB.Order("id ASC")
+	if value := r.URL.Query().Get("status"); value != "" {
+		status := strings.ToUpper(value)
+		if status != merchantStatusActive && status != merchantStatusSuspended {
+			writeValidationErrors(w, []responses.FieldError{{Field: "status", Code: "INVALID_VALUE", Message: "must be ACTIVE or SUSPENDED"}})
+			return
+		}
+		query = query.Where("status = ?", status)
+	}
+
+	var merchants []models.Merchant
+	if err := query.Find(&merchants).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve merchants"))
+		return
+	}
+
+	response := make([]MerchantResponse, 0, len(merchants))
+	for _, merchant := range merchants {
+		response = append(response, newMerchantResponse(merchant))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(response))
+}
+
+// RotateMerchantAPIKey replaces the API key of a merchant. The old key stops working at once.
+func (h *AppHandler) RotateMerchantAPIKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	key, keyID, secretHash, err := newMerchantAPIKey()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to rotate API key"))
+		return
+	}
+
+	merchant, err := findMerchant(h.DB, mux.Vars(r)["merchant_id"])
+	if err != nil {
+		writeMerchantError(w, err)
+		return
+	}
+	merchant.APIKeyID = keyID
+	merchant.APIKeyHash = secretHash
+	if err := h.DB.Model(&merchant).Updates(map[string]interface{}{"api_key_id": keyID, "api_key_hash": secretHash}).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to rotate API key"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(MerchantCredentialsResponse{
+		MerchantResponse: newMerchantResponse(merchant),
+		APIKey:           key,
+	}))
+}
+
+// SetMerchantStatus suspends a merchant or makes it accept payments again.
+func (h *AppHandler) SetMerchantStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req MerchantStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+	if fieldErrors := validateRequest(req); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	merchant, err := findMerchant(h.DB, mux.Vars(r)["merchant_id"])
+	if err != nil {
+		writeMerchantError(w, err)
+		return
+	}
+	merchant.Status = req.Status
+	if err := h.DB.Model(&merchant).Update("status", req.Status).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to update merchant"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(newMerchantResponse(merchant)))
+}
+
+// callingMerchant loads the merchant authenticated by MerchantAPIKey.
+func (h *AppHandler) callingMerchant(w http.ResponseWriter, r *http.Request) (models.Merchant, bool) {
+	merchantID, ok := r.Context().Value(auth.MerchantIDKey).(string)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Merchant ID not found in context"))
+		return models.Merchant{}, false
+	}
+	merchant, err := findMerchant(h.DB, merchantID)
+	if err != nil {
+		writeMerchantError(w, err)
+		return models.Merchant{}, false
+	}
+	return merchant, true
+}
+
+// GetMerchantPayments lists the payments received by the calling merchant. It takes
+// the pagination, date, status and amount parameters of GET /transactions.
+func (h *AppHandler) GetMerchantPayments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query, fieldErrors := parseTransactionListQuery(r.URL.Query())
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+	// Every payment is a debit of the payer
+	query.TransactionType = ""
+	query.TransactionCategory = ""
+
+	merchant, ok := h.callingMerchant(w, r)
+	if !ok {
+		return
+	}
+
+	var payments []models.PaymentTransaction
+	if err := query.Apply(h.DB.Where("merchant_id = ?", merchant.ID)).Find(&payments).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve payments"))
+		return
+	}
+
+	// The extra row only tells that another page follows
+	var nextCursor *string
+	if len(payments) > query.Limit {
+		payments = payments[:query.Limit]
+		nextCursor = query.nextCursor(payments[len(payments)-1].ID)
+	}
+
+	var settlementIDs []uint
+	for _, payment := range payments {
+		if payment.SettlementID != nil {
+			settlementIDs = append(settlementIDs, *payment.SettlementID)
+		}
+	}
+	settlementUIDs := make(map[uint]string, len(settlementIDs))
+	if len(settlementIDs) > 0 {
+		var settlements []models.MerchantSettlement
+		if err := h.DB.Select("id", "uid").Where("id IN ?", settlementIDs).Find(&settlements).Error; err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve payments"))
+			return
+		}
+		for _, settlement := range settlements {
+			settlementUIDs[settlement.ID] = settlement.UID
+		}
+	}
+
+	response := make([]MerchantPaymentResponse, 0, len(payments))
+	for _, payment := range payments {
+		item := MerchantPaymentResponse{
+			PaymentID:   payment.UID,
+			Amount:      payment.Amount,
+			Currency:    payment.Currency,
+			Status:      payment.Status,
+			Remarks:     payment.Remarks,
+			CreatedDate: payment.CreatedAt,
+		}
+		if payment.SettlementID != nil {
+			settlementUID := settlementUIDs[*payment.SettlementID]
+			item.SettlementID = &settlementUID
+		}
+		response = append(response, item)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.PaginatedSuccessResponse{
+		SuccessResponse: responses.NewSuccessResponse(response),
+		NextCursor:      nextCursor,
+	})
+}
+
+// GetMerchantSettlements lists the settlement batches of the calling merchant,
+// newest first. It takes the limit, order, cursor, from and to parameters of
+// GET /transactions; the dates filter on the business date.
+func (h *AppHandler) GetMerchantSettlements(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query, fieldErrors := parseTransactionListQuery(r.URL.Query())
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	merchant, ok := h.callingMerchant(w, r)
+	if !ok {
+		return
+	}
+
+	db := h.DB.Where("merchant_id = ?", merchant.ID)
+	if query.From != nil {
+		db = db.Where("business_date >= ?", query.From.Format("2006-01-02"))
+	}
+	if query.To != nil {
+		db = db.Where("business_date < ?", query.To.Format("2006-01-02"))
+	}
+	// The remaining filters are for payments and do not apply to batches
+	query.From, query.To = nil, nil
+	query.TransactionType, query.TransactionCategory, query.Status = "", "", ""
+	query.MinAmount, query.MaxAmount = nil, nil
+
+	var settlements []models.MerchantSettlement
+	if err := query.Apply(db).Find(&settlements).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve settlements"))
+		return
+	}
+
+	var nextCursor *string
+	if len(settlements) > query.Limit {
+		settlements = settlements[:query.Limit]
+		nextCursor = query.nextCursor(settlements[len(settlements)-1].ID)
+	}
+
+	response := make([]MerchantSettlementResponse, 0, len(settlements))
+	for _, settlement := range settlements {
+		response = append(response, MerchantSettlementResponse{
+			SettlementID:        settlement.UID,
+			BusinessDate:        settlement.BusinessDate.Format("2006-01-02"),
+			Currency:            settlement.Currency,
+			GrossAmount:         settlement.GrossAmount,
+			RefundAmount:        settlement.RefundAmount,
+			NetAmount:           settlement.NetAmount,
+			PaymentCount:        settlement.PaymentCount,
+			RefundCount:         settlement.RefundCount,
+			PayoutBankCode:      settlement.PayoutBankCode,
+			PayoutAccountNumber: settlement.PayoutAccountNumber,
+			PayoutAccountName:   settlement.PayoutAccountName,
+			CreatedDate:         settlement.CreatedAt,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.PaginatedSuccessResponse{
+		SuccessResponse: responses.NewSuccessResponse(response),
+		NextCursor:      nextCursor,
+	})
+}
+
+// HandleMerchantRefund refunds all or part of a payment received by the calling
+// merchant. The refund is deducted from its next settlement.
+func (h *AppHandler) HandleMerchantRefund(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req MerchantRefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if fieldErrors := amountDecodeError(err); fieldErrors != nil {
+			writeValidationErrors(w, fieldErrors)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input, the amount is optional
+	fieldErrors := validateRequest(req)
+	if !req.Amount.IsZero() {
+		fieldErrors = append(fieldErrors, h.AmountPolicy.Check("amount", req.Amount)...)
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	merchant, ok := h.callingMerchant(w, r)
+	if !ok {
+		return
+	}
+
+	var result RefundResult
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = h.refundTransaction(r.Context(), tx, refundRequest{
+			TransactionReff: req.PaymentID,
+			Amount:          req.Amount,
+			Reason:          req.Reason,
+			InitiatorType:   refundInitiatorMerchant,
+			InitiatorID:     merchant.UID,
+			MerchantID:      merchant.ID,
+		})
+		if err != nil {
+			return err
+		}
+		return middlewares.CompleteIdempotencyKey(r.Context(), tx, http.StatusOK, responses.NewSuccessResponse(result))
+	})
+
+	if err != nil {
+		writeRefundError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(result))
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/otp"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	otpDigits         = 6
+	otpTTL            = 5 * time.Minute
+	otpResendInterval = time.Minute
+	otpMaxAttempts    = 5
+
+	otpPurposePINReset = "PIN_RESET"
+)
+
+var (
+	errInvalidOTP  = errors.New("invalid otp")
+	errOTPExpired  = errors.New("otp expired")
+	errOTPTooSoon  = errors.New("otp requested too soon")
+	errOTPNotFound = errors.New("otp not found")
+)
+
+// issueOTP generates a one-time password for the user and purpose, stores its hash
+// and sends it to the destination phone number. Earlier unused codes for the same
+// purpose stop being valid.
+func issueOTP(ctx context.Context, db *gorm.DB, sender otp.Sender, user models.User, purpose, destination string) (models.OTPCode, error) {
+	code, err := otp.Generate(otpDigits)
+	if err != nil {
+		return models.OTPCode{}, err
+	}
+	codeHash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return models.OTPCode{}, err
+	}
+
+	now := time.Now()
+	otpCode := models.OTPCode{
+		UID:         uuid.New().String(),
+		UserID:      user.ID,
+		Purpose:     purpose,
+		Destination: destination,
+		CodeHash:    string(codeHash),
+		ExpiresAt:   now.Add(otpTTL),
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Serialize OTP requests of the same user
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.User{}, user.ID).Error; err != nil {
+			return err
+		}
+
+		var recentCount int64
+		if err := tx.Model(&models.OTPCode{}).
+			Where("user_id = ? AND purpose = ? AND created_at > ?", user.ID, purpose, now.Add(-otpResendInterval)).
+			Count(&recentCount).Error; err != nil {
+			return err
+		}
+		if recentCount > 0 {
+			return errOTPTooSoon
+		}
+
+		if err := tx.Model(&models.OTPCode{}).
+			Where("user_id = ? AND purpose = ? AND consumed_at IS NULL", user.ID, purpose).
+			Update("consumed_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&otpCode).Error
+	})
+	if err != nil {
+		return models.OTPCode{}, err
+	}
+
+	if err := sender.Send(ctx, destination, code, purpose); err != nil {
+		// The code never reached the user, so do not let it block a new request
+		db.Delete(&otpCode)
+		return models.OTPCode{}, err
+	}
+	return otpCode, nil
+}
+
+// verifyOTP checks the code against the latest pending one-time password of the user
+// and purpose. When it matches, onVerified runs in the same transaction that marks the
+// code verified; wrong codes are counted even though an error is returned.
+func verifyOTP(db *gorm.DB, userID uint, purpose, code string, onVerified func(tx *gorm.DB, otpCode *models.OTPCode) error) error {
+	invalid := false
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var otpCode models.OTPCode
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND purpose = ? AND verified_at IS NULL AND consumed_at IS NULL", userID, purpose).
+			Order("id desc").First(&otpCode).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errOTPNotFound
+			}
+			return err
+		}
+
+		if time.Now().After(otpCode.ExpiresAt) || otpCode.Attempts >= otpMaxAttempts {
+			return errOTPExpired
+		}
+
+		if err := bcrypt.CompareHashAndPassword([]byte(otpCode.CodeHash), []byte(code)); err != nil {
+			// Keep the attempt even though the request fails
+			invalid = true
+			otpCode.Attempts++
+			return tx.Save(&otpCode).Error
+		}
+
+		now := time.Now()
+		otpCode.VerifiedAt = &now
+		if err := onVerified(tx, &otpCode); err != nil {
+			return err
+		}
+		return tx.Save(&otpCode).Error
+	})
+	if err != nil {
+		return err
+	}
+	if invalid {
+		return errInvalidOTP
+	}
+	return nil
+}
+
+// writeOTPError responds with the error returned by issueOTP or verifyOTP.
+func writeOTPError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidOTP):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("INVALID_OTP", "Invalid OTP"))
+	case errors.Is(err, errOTPExpired), errors.Is(err, errOTPNotFound):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("OTP_EXPIRED", "OTP has expired, please request a new one"))
+	case errors.Is(err, errOTPTooSoon):
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("OTP_RESEND_TOO_SOON", "Please wait before requesting another OTP"))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to process OTP"))
+	}
+}
@@ -4,27 +4,42 @@ import (
 	"encoding/json"
 	"errors"
 	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/events"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"mnctech-restapi/cmd/rest-api/middlewares"
 	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/outbox"
+	"mnctech-restapi/cmd/rest-api/responses"
 	"net/http"
 	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type PaymentRequest struct {
-	Amount  float64 `json:"amount"`
-	Remarks string  `json:"remarks"`
+	MerchantID string      `json:"merchant_id" validate:"required,uuid"` // Merchant to pay
+	Amount     money.Money `json:"amount"`
+	Remarks    string      `json:"remarks" validate:"max=255"`
+	AccountID  string      `json:"account_id" validate:"omitempty,uuid"` // Account to pay from, the default account when omitted
+	Currency   string      `json:"currency" validate:"omitempty,len=3"`  // Must be the currency of the account when given
+
+	qrReference string // Dynamic QR code being paid, set by /pay/qr
 }
 
 type PaymentResult struct {
-	PaymentID     string    `json:"payment_id"`
-	Amount        float64   `json:"amount"`
-	Remarks       string    `json:"remarks"`
-	BalanceBefore float64   `json:"balance_before"`
-	BalanceAfter  float64   `json:"balance_after"`
-	CreatedDate   time.Time `json:"created_date"`
+	PaymentID     string      `json:"payment_id"`
+	AccountID     string      `json:"account_id"` // Account that was debited
+	MerchantID    string      `json:"merchant_id"`
+	MerchantName  string      `json:"merchant_name"`
+	Amount        money.Money `json:"amount"`
+	Currency      string      `json:"currency"`
+	Remarks       string      `json:"remarks"`
+	BalanceBefore money.Money `json:"balance_before"`
+	BalanceAfter  money.Money `json:"balance_after"`
+	CreatedDate   time.Time   `json:"created_date"`
 }
 
 func (h *AppHandler) HandlePayment(w http.ResponseWriter, r *http.Request) {
@@ -33,25 +48,34 @@ func (h *AppHandler) HandlePayment(w http.ResponseWriter, r *http.Request) {
 
 	if !ok {
 		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(NewFailedResponse("User ID not found in context"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
 		return
 	}
 
 	// Parse request body
 	var req PaymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if fieldErrors := amountDecodeError(err); fieldErrors != nil {
+			writeValidationErrors(w, fieldErrors)
+			return
+		}
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(NewFailedResponse("Invalid request payload"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
 		return
 	}
 
 	// Validate input
-	validate := validator.New()
-	if err := validate.Struct(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if fieldErrors := append(validateRequest(req), h.AmountPolicy.Check("amount", req.Amount)...); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
 		return
 	}
 
+	h.executePayment(w, r, userID, req)
+}
+
+// executePayment debits the account of the user, credits the merchant and writes
+// the response. The request has been validated.
+func (h *AppHandler) executePayment(w http.ResponseWriter, r *http.Request, userID string, req PaymentRequest) {
 	paymentTrxID := uuid.New().String()
 
 	paymentResult := PaymentResult{
@@ -71,25 +95,54 @@ func (h *AppHandler) HandlePayment(w http.ResponseWriter, r *http.Request) {
 
 	// Start a new transaction
 	err := h.DB.Transaction(func(tx *gorm.DB) error {
-		var user models.User
-		if err := tx.Where("uid = ?", userID).First(&user).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return errors.New("user not found") // Return an error if the user does not exist
-			}
-			return err // Return the error to rollback the transaction for other errors
+		user, err := findUserByUID(tx, userID)
+		if err != nil {
+			return err
 		}
 
-		if err := tx.Where("user_id = ?", user.ID).First(&userAccount).Error; err != nil {
+		merchant, err := findPayableMerchant(tx, req.MerchantID)
+		if err != nil {
 			return err
 		}
 
-		if userAccount.CurrentBalance < req.Amount {
+		accountID, err := resolveAccount(tx, user.ID, req.AccountID)
+		if err != nil {
+			return err
+		}
+
+		// Lock the account so concurrent payments cannot both pass the balance check
+		accounts, err := lockAccounts(tx, accountID)
+		if err != nil {
+			return err
+		}
+		userAccount = *accounts[accountID]
+		if req.Currency != "" && req.Currency != userAccount.Currency {
+			return errCurrencyMismatch
+		}
+		// Merchants are paid in their own currency only
+		if userAccount.Currency != merchant.Currency {
+			return errCurrencyMismatch
+		}
+
+		// A dynamic QR code is paid once, for its amount
+		var qrCode models.MerchantQRCode
+		if req.qrReference != "" {
+			if qrCode, err = claimQRCode(tx, merchant.ID, req.qrReference, req.Amount); err != nil {
+				return err
+			}
+		}
+
+		if availableBalance(&userAccount).LessThan(req.Amount) {
 			return errors.New("insufficient balance")
 		}
 
+		if err := h.checkLimits(r.Context(), tx, user, req.Amount, userAccount.Currency, false); err != nil {
+			return err
+		}
+
 		// Update balances
-		userAccount.LastBalance = userAccount.CurrentBalance // Save the previous balance
-		userAccount.CurrentBalance -= req.Amount             // Update curr
+		userAccount.LastBalance = userAccount.CurrentBalance                    // Save the previous balance
+		userAccount.CurrentBalance = userAccount.CurrentBalance.Sub(req.Amount) // Update curr
 
 		// Save the updated account
 		if err := tx.Save(&userAccount).Error; err != nil {
@@ -100,22 +153,34 @@ func (h *AppHandler) HandlePayment(w http.ResponseWriter, r *http.Request) {
 			UID:           paymentTrxID,
 			UserAccountID: userAccount.ID, // Reference to the user's account
 			Remarks:       req.Remarks,
-			Amount:        req.Amount, // The amount topped up
-			Status:        "SUCCESS",  // Status of the transaction
+			Amount:        req.Amount,           // The amount topped up
+			Currency:      userAccount.Currency, // Currency of the account
+			MerchantID:    &merchant.ID,         // Merchant being paid
+			Status:        "SUCCESS",            // Status of the transaction
 		}
 
 		if err := tx.Create(&paymentTransactionPayoad).Error; err != nil {
 			return err // Return the error to rollback the transaction
 		}
 
+		if req.qrReference != "" {
+			if err := tx.Model(&qrCode).Updates(map[string]interface{}{
+				"payment_transaction_id": paymentTransactionPayoad.ID,
+				"paid_at":                time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+		}
+
 		// Fetch the user's account
 		var updatedUserAccount models.UserAccount
-		if err := tx.Where("user_id = ?", user.ID).First(&updatedUserAccount).Error; err != nil {
+		if err := tx.First(&updatedUserAccount, userAccount.ID).Error; err != nil {
 			return err
 		}
 
 		transactionLog := baseTransactionLog
 		transactionLog.UserAccountID = userAccount.ID
+		transactionLog.Currency = userAccount.Currency
 		transactionLog.TransactionType = "DEBIT"
 		transactionLog.BalanceBefore = updatedUserAccount.LastBalance
 		transactionLog.BalanceAfter = updatedUserAccount.CurrentBalance
@@ -129,18 +194,44 @@ func (h *AppHandler) HandlePayment(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 
+		// Money leaves the wallet to the settlement account of the merchant
+		if err := postJournal(tx, paymentTrxID, "PAYMENT", req.Remarks,
+			ledger.Transfer(ledger.UserAccountCode(userAccount.ID), ledger.MerchantAccountCode(merchant.ID), req.Amount),
+			&updatedUserAccount); err != nil {
+			return err
+		}
+
+		if err := outbox.EnqueueEvent(tx, events.TypePaymentCompleted, events.PaymentCompleted{
+			PaymentID:    paymentTrxID,
+			UserID:       user.UID,
+			MerchantID:   merchant.UID,
+			Amount:       req.Amount,
+			Currency:     updatedUserAccount.Currency,
+			Remarks:      req.Remarks,
+			BalanceAfter: updatedUserAccount.CurrentBalance,
+		}); err != nil {
+			return err // Return the error to rollback the transaction
+		}
+
+		paymentResult.AccountID = updatedUserAccount.UID
+		paymentResult.MerchantID = merchant.UID
+		paymentResult.MerchantName = merchant.Name
+		paymentResult.Currency = updatedUserAccount.Currency
 		paymentResult.BalanceBefore = updatedUserAccount.LastBalance
 		paymentResult.BalanceAfter = updatedUserAccount.CurrentBalance
 		paymentResult.CreatedDate = paymentTransaction.CreatedAt
 
-		return nil // Commit the transaction if everything is successful
+		// A retry with the Idempotency-Key gets this response once it commits
+		return middlewares.CompleteIdempotencyKey(r.Context(), tx, http.StatusOK, responses.NewSuccessResponse(paymentResult))
 	})
 
 	if err != nil {
+		var limitErr *LimitExceededError
 		if err.Error() == "insufficient balance" {
 			errMessage := "Balance is not enough"
 			transactionLog := baseTransactionLog
 			transactionLog.UserAccountID = userAccount.ID
+			transactionLog.Currency = userAccount.Currency
 			transactionLog.TransactionType = "DEBIT"
 			transactionLog.BalanceBefore = userAccount.LastBalance
 			transactionLog.BalanceAfter = userAccount.CurrentBalance
@@ -149,21 +240,33 @@ func (h *AppHandler) HandlePayment(w http.ResponseWriter, r *http.Request) {
 
 			if err := h.DB.Create(&transactionLog).Error; err != nil {
 				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(NewFailedResponse(errMessage))
+				json.NewEncoder(w).Encode(responses.NewFailedResponse(errMessage))
 			}
 
 			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(NewFailedResponse(errMessage))
+			json.NewEncoder(w).Encode(responses.NewFailedResponse(errMessage))
 		} else if err.Error() == "user not found" {
 			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(NewFailedResponse("User not found"))
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		} else if errors.As(err, &limitErr) {
+			writeLimitExceeded(w, limitErr)
+		} else if errors.Is(err, errAccountNotFound) {
+			writeAccountNotFound(w)
+		} else if errors.Is(err, errCurrencyMismatch) {
+			writeCurrencyMismatch(w)
+		} else if errors.Is(err, errMerchantNotFound) || errors.Is(err, errMerchantNotActive) {
+			writeMerchantError(w, err)
+		} else if errors.Is(err, errQRCodeNotFound) || errors.Is(err, errQRCodeExpired) || errors.Is(err, errQRCodePaid) || errors.Is(err, errQRAmountMismatch) {
+			writeQRError(w, err)
+		} else if errors.Is(err, currency.ErrNoRate) {
+			writeFxUnavailable(w)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(NewFailedResponse("Transaction failed"))
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Transaction failed"))
 		}
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(NewSuccessResponse(paymentResult))
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(paymentResult))
 }
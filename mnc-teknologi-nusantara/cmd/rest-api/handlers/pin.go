@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const pinResetTokenTTL = 10 * time.Minute
+
+type ChangePINRequest struct {
+	OldPIN string `json:"old_pin" validate:"required"`
+	NewPIN string `json:"new_pin" validate:"required,pin,nefield=OldPIN"`
+}
+
+type PINResetRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required"`
+}
+
+type PINResetRequestResult struct {
+	ExpiresAt time.Time `json:"expires_at"` // When the OTP sent to the phone number expires
+}
+
+type PINResetVerifyRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required"`
+	OTP         string `json:"otp" validate:"required"`
+}
+
+type PINResetVerifyResult struct {
+	ResetToken string    `json:"reset_token"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type ResetPINRequest struct {
+	ResetToken string `json:"reset_token" validate:"required"`
+	NewPIN     string `json:"new_pin" validate:"required,pin"`
+}
+
+// setPIN stores a new PIN for the user and invalidates all of the user's sessions.
+func setPIN(tx *gorm.DB, user models.User, pin string) error {
+	// Hash the PIN before saving it
+	hashedPin, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Model(&user).Update("pin", string(hashedPin)).Error; err != nil {
+		return err
+	}
+	return revokeSessions(tx, "user_id = ?", user.ID)
+}
+
+// ChangePIN replaces the PIN of the authenticated user after checking the old PIN.
+func (h *AuthHandler) ChangePIN(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var req ChangePINRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input
+	if fieldErrors := validateRequest(req); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	var user models.User
+	if err := h.DB.Where("uid = ?", userID).First(&user).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		return
+	}
+
+	// The old PIN is guarded by the same lockout as login
+	ip := clientIP(r)
+	lockedUntil, err := h.LoginThrottle.reserveLoginAttempt(h.DB, user.PhoneNumber, ip)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to check login attempts"))
+		return
+	}
+	if lockedUntil != nil {
+		writeAccountLocked(w, *lockedUntil)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Pin), []byte(req.OldPIN)); err != nil {
+		lockedUntil, err := h.LoginThrottle.recordLoginFailure(h.DB, user.PhoneNumber, ip)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to record login attempt"))
+			return
+		}
+		if lockedUntil != nil {
+			writeAccountLocked(w, *lockedUntil)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("INVALID_PIN", "Invalid PIN"))
+		return
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := releaseLoginAttempt(tx, user.PhoneNumber, ip); err != nil {
+			return err
+		}
+		return setPIN(tx, user, req.NewPIN)
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to change PIN"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(nil))
+}
+
+// RequestPINReset sends an OTP to the phone number of a user who forgot the PIN.
+func (h *AuthHandler) RequestPINReset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req PINResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	var user models.User
+	if err := h.DB.Where("phone_number = ?", req.PhoneNumber).First(&user).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		return
+	}
+
+	otpCode, err := issueOTP(r.Context(), h.DB, h.OTPSender, user, otpPurposePINReset, user.PhoneNumber)
+	if err != nil {
+		writeOTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(PINResetRequestResult{ExpiresAt: otpCode.ExpiresAt}))
+}
+
+// VerifyPINReset checks the OTP sent by RequestPINReset and hands out a short-lived reset token.
+func (h *AuthHandler) VerifyPINReset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req PINResetVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	var user models.User
+	if err := h.DB.Where("phone_number = ?", req.PhoneNumber).First(&user).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		return
+	}
+
+	resetToken, err := newOpaqueToken()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to process OTP"))
+		return
+	}
+	result := PINResetVerifyResult{
+		ResetToken: resetToken,
+		ExpiresAt:  time.Now().Add(pinResetTokenTTL),
+	}
+
+	err = verifyOTP(h.DB, user.ID, otpPurposePINReset, req.OTP, func(tx *gorm.DB, otpCode *models.OTPCode) error {
+		otpCode.TokenHash = auth.HashToken(resetToken)
+		otpCode.ExpiresAt = result.ExpiresAt
+		return nil
+	})
+	if err != nil {
+		writeOTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(result))
+}
+
+// ResetPIN sets a new PIN using the reset token returned by VerifyPINReset.
+func (h *AuthHandler) ResetPIN(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req ResetPINRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input
+	if fieldErrors := validateRequest(req); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var otpCode models.OTPCode
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND purpose = ? AND verified_at IS NOT NULL AND consumed_at IS NULL AND expires_at > ?",
+				auth.HashToken(req.ResetToken), otpPurposePINReset, time.Now()).
+			First(&otpCode).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("invalid reset token")
+			}
+			return err
+		}
+
+		var user models.User
+		if err := tx.First(&user, otpCode.UserID).Error; err != nil {
+			return err
+		}
+
+		if err := setPIN(tx, user, req.NewPIN); err != nil {
+			return err
+		}
+
+		// The user proved ownership of the phone number, so lift any login lock
+		if err := resetLoginFailures(tx, user.PhoneNumber); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		otpCode.ConsumedAt = &now
+		return tx.Save(&otpCode).Error
+	})
+
+	if err != nil {
+		if err.Error() == "invalid reset token" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("INVALID_RESET_TOKEN", "Invalid or expired reset token"))
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to reset PIN"))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(nil))
+}
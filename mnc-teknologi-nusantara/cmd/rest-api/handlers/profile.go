@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+const otpPurposePhoneChange = "PHONE_CHANGE"
+
+type ProfileResponse struct {
+	RegisterResponse
+	UpdatedDate string `json:"updated_date"`
+}
+
+type UpdateProfileRequest struct {
+	FirstName string `json:"first_name" validate:"required"`
+	LastName  string `json:"last_name" validate:"required"`
+	Address   string `json:"address" validate:"required"`
+}
+
+type ChangePhoneRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required"`
+}
+
+type ChangePhoneResult struct {
+	ExpiresAt time.Time `json:"expires_at"` // When the OTP sent to the new phone number expires
+}
+
+type VerifyPhoneChangeRequest struct {
+	OTP string `json:"otp" validate:"required"`
+}
+
+func newProfileResponse(user models.User) ProfileResponse {
+	return ProfileResponse{
+		RegisterResponse: RegisterResponse{
+			UserID:      user.UID,
+			FirstName:   user.FirstName,
+			LastName:    user.LastName,
+			PhoneNumber: user.PhoneNumber,
+			Address:     user.Address,
+			CreatedDate: user.CreatedAt.Format("2006-01-02 15:04:05"),
+		},
+		UpdatedDate: user.UpdatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// GetProfile returns the record of the authenticated user.
+func (h *AuthHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var user models.User
+	if err := h.DB.Where("uid = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve profile"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(newProfileResponse(user)))
+}
+
+// UpdateProfile changes the name and address of the authenticated user.
+func (h *AuthHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	var user models.User
+	if err := h.DB.Where("uid = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to update profile"))
+		return
+	}
+
+	if err := h.DB.Model(&user).Updates(map[string]interface{}{
+		"first_name": req.FirstName,
+		"last_name":  req.LastName,
+		"address":    req.Address,
+	}).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to update profile"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(newProfileResponse(user)))
+}
+
+// RequestPhoneChange sends an OTP to the new phone number of the authenticated user.
+// The phone number only changes once the OTP is confirmed with VerifyPhoneChange.
+func (h *AuthHandler) RequestPhoneChange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var req ChangePhoneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	var user models.User
+	if err := h.DB.Where("uid = ?", userID).First(&user).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		return
+	}
+
+	if req.PhoneNumber == user.PhoneNumber {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Phone number is unchanged"))
+		return
+	}
+
+	var existingUser models.User
+	if err := h.DB.Where("phone_number = ?", req.PhoneNumber).First(&existingUser).Error; err == nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Phone number is already registered"))
+		return
+	}
+
+	otpCode, err := issueOTP(r.Context(), h.DB, h.OTPSender, user, otpPurposePhoneChange, req.PhoneNumber)
+	if err != nil {
+		writeOTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(ChangePhoneResult{ExpiresAt: otpCode.ExpiresAt}))
+}
+
+// VerifyPhoneChange confirms the OTP sent by RequestPhoneChange and applies the new phone number.
+func (h *AuthHandler) VerifyPhoneChange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var req VerifyPhoneChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	var user models.User
+	if err := h.DB.Where("uid = ?", userID).First(&user).Error; err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		return
+	}
+
+	err := verifyOTP(h.DB, user.ID, otpPurposePhoneChange, req.OTP, func(tx *gorm.DB, otpCode *models.OTPCode) error {
+		// The number may have been registered by someone else since the OTP was sent
+		var existingUser models.User
+		if err := tx.Where("phone_number = ?", otpCode.Destination).First(&existingUser).Error; err == nil {
+			return errors.New("phone number already registered")
+		}
+
+		if err := tx.Model(&user).Update("phone_number", otpCode.Destination).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		otpCode.ConsumedAt = &now
+		return nil
+	})
+	if err != nil {
+		if err.Error() == "phone number already registered" {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Phone number is already registered"))
+		} else {
+			writeOTPError(w, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(newProfileResponse(user)))
+}
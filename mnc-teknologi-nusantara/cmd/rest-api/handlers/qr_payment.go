@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/qris"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// qrGloballyUniqueID identifies this wallet in the merchant account template of
+	// QR codes; codes without it belong to other payment networks.
+	qrGloballyUniqueID   = "ID.MNCTECH.WWW"
+	qrMerchantAccountTag = "26"
+
+	// dynamicQRTTL is how long a dynamic QR code can be paid.
+	dynamicQRTTL = 15 * time.Minute
+)
+
+// Statuses of a dynamic QR code.
+const (
+	qrStatusPending = "PENDING"
+	qrStatusPaid    = "PAID"
+	qrStatusExpired = "EXPIRED"
+)
+
+var (
+	errQRNotSupported   = errors.New("QR code is for another payment network")
+	errQRCodeNotFound   = errors.New("QR code not found")
+	errQRCodeExpired    = errors.New("QR code has expired")
+	errQRCodePaid       = errors.New("QR code has already been paid")
+	errQRAmountMismatch = errors.New("amount does not match the QR code")
+)
+
+// MerchantQRRequest represents the expected payload of POST /merchant/qr.
+type MerchantQRRequest struct {
+	Amount     money.Money `json:"amount"`                        // Omit for a static code, the payer enters the amount
+	BillNumber string      `json:"bill_number" validate:"max=25"` // Invoice number shown to the payer, codes with an amount only
+}
+
+// MerchantQRResponse carries the payload to render as a QR code.
+type MerchantQRResponse struct {
+	Type       string       `json:"type"`    // STATIC or DYNAMIC
+	Payload    string       `json:"payload"` // EMVCo merchant-presented QR payload
+	Reference  *string      `json:"reference"`
+	Amount     *money.Money `json:"amount"`
+	Currency   string       `json:"currency"`
+	BillNumber string       `json:"bill_number,omitempty"`
+	ExpiresAt  *time.Time   `json:"expires_at"` // null for static codes, which do not expire
+}
+
+// MerchantQRStatusResponse shows whether a dynamic QR code has been paid.
+type MerchantQRStatusResponse struct {
+	Reference  string      `json:"reference"`
+	Amount     money.Money `json:"amount"`
+	Currency   string      `json:"currency"`
+	BillNumber string      `json:"bill_number"`
+	Status     string      `json:"status"`     // PENDING, PAID or EXPIRED
+	PaymentID  *string     `json:"payment_id"` // Set once paid
+	ExpiresAt  time.Time   `json:"expires_at"`
+	PaidAt     *time.Time  `json:"paid_at"`
+}
+
+// QRPaymentRequest represents the expected payload of POST /pay/qr.
+type QRPaymentRequest struct {
+	QRPayload string      `json:"qr_payload" validate:"required,max=512"` // Text of the scanned code
+	Amount    money.Money `json:"amount"`                                 // Required for static codes, must match dynamic ones when given
+	Remarks   string      `json:"remarks" validate:"max=255"`
+	AccountID string      `json:"account_id" validate:"omitempty,uuid"` // Account to pay from, the default account when omitted
+}
+
+// writeQRError responds with the failure of a QR code.
+func writeQRError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, qris.ErrMalformed), errors.Is(err, qris.ErrInvalidCRC):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("INVALID_QR", "QR code cannot be read"))
+	case errors.Is(err, errQRNotSupported):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("QR_NOT_SUPPORTED", "QR code is for another payment network"))
+	case errors.Is(err, errQRCodeNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("QR_NOT_FOUND", "QR code not found"))
+	case errors.Is(err, errQRCodeExpired):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("QR_EXPIRED", "QR code has expired"))
+	case errors.Is(err, errQRCodePaid):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("QR_ALREADY_PAID", "QR code has already been paid"))
+	case errors.Is(err, errQRAmountMismatch):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("QR_AMOUNT_MISMATCH", "Amount does not match the QR code"))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to process QR code"))
+	}
+}
+
+// newQRReference returns a random reference label for a dynamic QR code.
+func newQRReference() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// merchantQRPayload encodes a QR code paying the merchant. Dynamic codes carry
+// the amount and the reference of their MerchantQRCode.
+func merchantQRPayload(merchant models.Merchant, qrCode *models.MerchantQRCode) (string, error) {
+	numericCurrency, ok := currency.Numeric(merchant.Currency)
+	if !ok {
+		return "", currency.ErrUnsupported
+	}
+	payload := qris.Payload{
+		PointOfInitiation: qris.Static,
+		MerchantAccounts: []qris.MerchantAccount{{
+			Tag:              qrMerchantAccountTag,
+			GloballyUniqueID: qrGloballyUniqueID,
+			MerchantID:       merchant.UID,
+		}},
+		MerchantCategoryCode: merchant.CategoryCode,
+		Currency:             numericCurrency,
+		CountryCode:          qris.CountryIndonesia,
+		MerchantName:         qris.Clean(merchant.Name, qris.MaxMerchantName),
+		MerchantCity:         qris.Clean(merchant.City, qris.MaxMerchantCity),
+		PostalCode:           qris.Clean(merchant.PostalCode, qris.MaxPostalCode),
+	}
+	if qrCode != nil {
+		payload.PointOfInitiation = qris.Dynamic
+		payload.Amount = qrCode.Amount.String()
+		payload.ReferenceLabel = qrCode.Reference
+		payload.BillNumber = qris.Clean(qrCode.BillNumber, qris.MaxBillNumber)
+	}
+	return qris.Encode(payload)
+}
+
+// GenerateMerchantQR creates a QR code paying the calling merchant. Without an
+// amount the code is static and can be printed; with one it is dynamic, bound to
+// that amount and paid once within 15 minutes.
+func (h *AppHandler) GenerateMerchantQR(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req MerchantQRRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if fieldErrors := amountDecodeError(err); fieldErrors != nil {
+			writeValidationErrors(w, fieldErrors)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input, the amount is optional
+	fieldErrors := validateRequest(req)
+	if !req.Amount.IsZero() {
+		fieldErrors = append(fieldErrors, h.AmountPolicy.Check("amount", req.Amount)...)
+	} else if req.BillNumber != "" {
+		fieldErrors = append(fieldErrors, responses.FieldError{Field: "bill_number", Code: "INVALID", Message: "is only allowed with an amount"})
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	merchant, ok := h.callingMerchant(w, r)
+	if !ok {
+		return
+	}
+	if merchant.Status != merchantStatusActive {
+		writeMerchantError(w, errMerchantNotActive)
+		return
+	}
+
+	if req.Amount.IsZero() {
+		payload, err := merchantQRPayload(merchant, nil)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to generate QR code"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(responses.NewSuccessResponse(MerchantQRResponse{
+			Type:     "STATIC",
+			Payload:  payload,
+			Currency: merchant.Currency,
+		}))
+		return
+	}
+
+	reference, err := newQRReference()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to generate QR code"))
+		return
+	}
+	qrCode := models.MerchantQRCode{
+		Reference:  reference,
+		MerchantID: merchant.ID,
+		Amount:     req.Amount,
+		Currency:   merchant.Currency,
+		BillNumber: req.BillNumber,
+		ExpiresAt:  time.Now().Add(dynamicQRTTL),
+	}
+	payload, err := merchantQRPayload(merchant, &qrCode)
+	if err == nil {
+		err = h.DB.Create(&qrCode).Error
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to generate QR code"))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(MerchantQRResponse{
+		Type:       "DYNAMIC",
+		Payload:    payload,
+		Reference:  &qrCode.Reference,
+		Amount:     &qrCode.Amount,
+		Currency:   qrCode.Currency,
+		BillNumber: qrCode.BillNumber,
+		ExpiresAt:  &qrCode.ExpiresAt,
+	}))
+}
+
+// GetMerchantQR returns the status of a dynamic QR code of the calling merchant.
+func (h *AppHandler) GetMerchantQR(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	merchant, ok := h.callingMerchant(w, r)
+	if !ok {
+		return
+	}
+
+	var qrCode models.MerchantQRCode
+	err := h.DB.Preload("PaymentTransaction").
+		Where("reference = ? AND merchant_id = ?", mux.Vars(r)["reference"], merchant.ID).
+		First(&qrCode).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeQRError(w, errQRCodeNotFound)
+		return
+	}
+	if err != nil {
+		writeQRError(w, err)
+		return
+	}
+
+	response := MerchantQRStatusResponse{
+		Reference:  qrCode.Reference,
+		Amount:     qrCode.Amount,
+		Currency:   qrCode.Currency,
+		BillNumber: qrCode.BillNumber,
+		Status:     qrStatusPending,
+		ExpiresAt:  qrCode.ExpiresAt,
+		PaidAt:     qrCode.PaidAt,
+	}
+	switch {
+	case qrCode.PaymentTransaction != nil:
+		response.Status = qrStatusPaid
+		response.PaymentID = &qrCode.PaymentTransaction.UID
+	case time.Now().After(qrCode.ExpiresAt):
+		response.Status = qrStatusExpired
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(response))
+}
+
+// claimQRCode locks a dynamic QR code of the merchant for payment of amount.
+func claimQRCode(tx *gorm.DB, merchantID uint, reference string, amount money.Money) (models.MerchantQRCode, error) {
+	var qrCode models.MerchantQRCode
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("reference = ? AND merchant_id = ?", reference, merchantID).
+		First(&qrCode).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return qrCode, errQRCodeNotFound
+	}
+	if err != nil {
+		return qrCode, err
+	}
+	if qrCode.PaymentTransactionID != nil {
+		return qrCode, errQRCodePaid
+	}
+	if time.Now().After(qrCode.ExpiresAt) {
+		return qrCode, errQRCodeExpired
+	}
+	// The checksum does not stop anyone from changing the amount in a payload
+	if qrCode.Amount != amount {
+		return qrCode, errQRAmountMismatch
+	}
+	return qrCode, nil
+}
+
+// HandleQRPayment pays the merchant of a scanned QR code. Static codes are paid
+// the amount of the request; dynamic codes are paid their own amount, once.
+func (h *AppHandler) HandleQRPayment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var req QRPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if fieldErrors := amountDecodeError(err); fieldErrors != nil {
+			writeValidationErrors(w, fieldErrors)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+	if fieldErrors := validateRequest(req); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	paymentReq, err := qrPaymentRequest(req)
+	if err != nil {
+		writeQRError(w, err)
+		return
+	}
+	if paymentReq.Amount.IsZero() {
+		writeValidationErrors(w, []responses.FieldError{{Field: "amount", Code: "REQUIRED", Message: "is required for a QR code without an amount"}})
+		return
+	}
+	if fieldErrors := h.AmountPolicy.Check("amount", paymentReq.Amount); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	h.executePayment(w, r, userID, paymentReq)
+}
+
+// qrPaymentRequest turns a scanned QR code into the payment of its merchant.
+func qrPaymentRequest(req QRPaymentRequest) (PaymentRequest, error) {
+	payload, err := qris.Decode(req.QRPayload)
+	if err != nil {
+		return PaymentRequest{}, err
+	}
+	account, ok := payload.Account(qrGloballyUniqueID)
+	if !ok {
+		return PaymentRequest{}, errQRNotSupported
+	}
+	code, ok := currency.FromNumeric(payload.Currency)
+	if !ok {
+		return PaymentRequest{}, errQRNotSupported
+	}
+
+	paymentReq := PaymentRequest{
+		MerchantID: account.MerchantID,
+		Amount:     req.Amount,
+		Remarks:    req.Remarks,
+		AccountID:  req.AccountID,
+		Currency:   code,
+	}
+	if payload.Amount != "" {
+		amount, err := money.Parse(payload.Amount)
+		if err != nil {
+			return PaymentRequest{}, qris.ErrMalformed
+		}
+		if !req.Amount.IsZero() && req.Amount != amount {
+			return PaymentRequest{}, errQRAmountMismatch
+		}
+		paymentReq.Amount = amount
+	}
+	if payload.PointOfInitiation == qris.Dynamic {
+		// Dynamic codes of this wallet always carry their amount and reference
+		if payload.Amount == "" || payload.ReferenceLabel == "" {
+			return PaymentRequest{}, qris.ErrMalformed
+		}
+		paymentReq.qrReference = payload.ReferenceLabel
+	}
+	return paymentReq, nil
+}
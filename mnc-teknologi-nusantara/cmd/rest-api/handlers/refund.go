@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/events"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"mnctech-restapi/cmd/rest-api/middlewares"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/outbox"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Statuses of a refunded payment or transfer.
+const (
+	transactionStatusRefunded          = "REFUNDED"
+	transactionStatusPartiallyRefunded = "PARTIALLY_REFUNDED"
+)
+
+// Who asked for a refund.
+const (
+	refundInitiatorAdmin    = "ADMIN"
+	refundInitiatorMerchant = "MERCHANT"
+)
+
+var (
+	errRefundTransactionNotFound = errors.New("transaction not found")
+	errRefundNotAllowed          = errors.New("transaction cannot be refunded")
+	errRefundExceedsAmount       = errors.New("refund exceeds the refundable amount")
+	errRefundInsufficientBalance = errors.New("recipient balance is not enough")
+)
+
+// AdminRefundRequest represents the expected payload of POST /admin/refunds.
+type AdminRefundRequest struct {
+	TransactionID string      `json:"transaction_id" validate:"required,uuid"` // payment_id or transfer_id
+	Amount        money.Money `json:"amount"`                                  // Omit to refund everything not refunded yet
+	Reason        string      `json:"reason" validate:"required,max=255"`
+}
+
+// RefundResult represents the structure of the refund response result.
+type RefundResult struct {
+	RefundID            string      `json:"refund_id"`
+	TransactionID       string      `json:"transaction_id"`
+	TransactionCategory string      `json:"transaction_category"`
+	TransactionStatus   string      `json:"transaction_status"` // REFUNDED or PARTIALLY_REFUNDED
+	Amount              money.Money `json:"amount"`             // Amount of this refund
+	RefundedAmount      money.Money `json:"refunded_amount"`    // All refunds of the transaction so far
+	Currency            string      `json:"currency"`           // Currency of the original transaction and the amounts
+	Reason              string      `json:"reason"`
+	CreatedDate         time.Time   `json:"created_date"`
+}
+
+// refundRequest describes a refund, whoever asked for it.
+type refundRequest struct {
+	TransactionReff string
+	Amount          money.Money // Zero refunds everything not refunded yet
+	Reason          string
+	InitiatorType   string
+	InitiatorID     string
+	MerchantID      uint // Only payments to this merchant may be refunded, zero for any transaction
+}
+
+// HandleAdminRefund refunds all or part of a successful payment or transfer.
+func (h *AppHandler) HandleAdminRefund(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var req AdminRefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if fieldErrors := amountDecodeError(err); fieldErrors != nil {
+			writeValidationErrors(w, fieldErrors)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input, the amount is optional
+	fieldErrors := validateRequest(req)
+	if !req.Amount.IsZero() {
+		fieldErrors = append(fieldErrors, h.AmountPolicy.Check("amount", req.Amount)...)
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	var result RefundResult
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = h.refundTransaction(r.Context(), tx, refundRequest{
+			TransactionReff: req.TransactionID,
+			Amount:          req.Amount,
+			Reason:          req.Reason,
+			InitiatorType:   refundInitiatorAdmin,
+			InitiatorID:     userID,
+		})
+		if err != nil {
+			return err
+		}
+		return middlewares.CompleteIdempotencyKey(r.Context(), tx, http.StatusOK, responses.NewSuccessResponse(result))
+	})
+
+	if err != nil {
+		writeRefundError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(result))
+}
+
+// writeRefundError responds with the failure of refundTransaction.
+func writeRefundError(w http.ResponseWriter, err error) {
+	var limitErr *LimitExceededError
+	switch {
+	case errors.As(err, &limitErr):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(LimitExceededResponse{
+			FailedResponse: responses.NewFailedResponseWithCode("LIMIT_EXCEEDED", "Refund would take the recipient above their maximum balance"),
+			Limit:          limitErr.Limit,
+			Remaining:      limitErr.Remaining,
+		})
+	case errors.Is(err, currency.ErrNoRate):
+		writeFxUnavailable(w)
+	case errors.Is(err, errRefundTransactionNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Transaction not found"))
+	case errors.Is(err, errRefundNotAllowed):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("REFUND_NOT_ALLOWED", "Only successful, not fully refunded transactions can be refunded"))
+	case errors.Is(err, errRefundExceedsAmount):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("REFUND_EXCEEDS_AMOUNT", "Refund exceeds the amount not refunded yet"))
+	case errors.Is(err, errRefundInsufficientBalance):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("INSUFFICIENT_BALANCE", "Recipient balance is not enough"))
+	case errors.Is(err, errFxAmountTooSmall):
+		writeFxAmountTooSmall(w)
+	case errors.Is(err, errCurrencyMismatch):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("CURRENCY_MISMATCH", "The account to refund holds another currency"))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Transaction failed"))
+	}
+}
+
+// refundTransaction moves money back for a payment or transfer and records the
+// refund. The original row is locked while the refunds so far are added up, so
+// concurrent refunds can never exceed the original amount.
+func (h *AppHandler) refundTransaction(ctx context.Context, tx *gorm.DB, req refundRequest) (RefundResult, error) {
+	var (
+		category string
+		original *gorm.DB // Query updating the status of the original row
+		amount   money.Money
+		code     string
+		status   string
+	)
+
+	var payment models.PaymentTransaction
+	var transfer models.TransferTransaction
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("uid = ?", req.TransactionReff).First(&payment).Error
+	switch {
+	case err == nil:
+		if req.MerchantID != 0 && (payment.MerchantID == nil || *payment.MerchantID != req.MerchantID) {
+			return RefundResult{}, errRefundTransactionNotFound
+		}
+		category, original, amount, code, status = "PAYMENT", tx.Model(&payment), payment.Amount, payment.Currency, payment.Status
+	case errors.Is(err, gorm.ErrRecordNotFound) && req.MerchantID != 0:
+		return RefundResult{}, errRefundTransactionNotFound
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("uid = ?", req.TransactionReff).First(&transfer).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return RefundResult{}, errRefundTransactionNotFound
+		}
+		if err != nil {
+			return RefundResult{}, err
+		}
+		category, original, amount, code, status = "TRANSFER", tx.Model(&transfer), transfer.Amount, transfer.Currency, transfer.Status
+	default:
+		return RefundResult{}, err
+	}
+
+	if status != "SUCCESS" && status != transactionStatusPartiallyRefunded {
+		return RefundResult{}, errRefundNotAllowed
+	}
+
+	var refunded money.Money
+	if err := tx.Model(&models.Refund{}).Where("transaction_reff = ?", req.TransactionReff).
+		Select("COALESCE(SUM(amount), 0)").Scan(&refunded).Error; err != nil {
+		return RefundResult{}, err
+	}
+	remaining := amount.Sub(refunded)
+	if !remaining.IsPositive() {
+		return RefundResult{}, errRefundNotAllowed
+	}
+	refundAmount := req.Amount
+	if refundAmount.IsZero() {
+		refundAmount = remaining
+	}
+	if refundAmount.GreaterThan(remaining) {
+		return RefundResult{}, errRefundExceedsAmount
+	}
+
+	// Compensating entries carry the reference of the original transaction
+	if category == "PAYMENT" {
+		err = h.refundPayment(ctx, tx, payment, refundAmount, req.Reason)
+	} else {
+		err = h.refundTransfer(ctx, tx, transfer, refunded, refundAmount, req.Reason)
+	}
+	if err != nil {
+		return RefundResult{}, err
+	}
+
+	refund := models.Refund{
+		UID:                 uuid.New().String(),
+		TransactionReff:     req.TransactionReff,
+		TransactionCategory: category,
+		Amount:              refundAmount,
+		Currency:            code,
+		Reason:              req.Reason,
+		InitiatorType:       req.InitiatorType,
+		InitiatorID:         req.InitiatorID,
+		MerchantID:          payment.MerchantID,
+	}
+	if err := tx.Create(&refund).Error; err != nil {
+		return RefundResult{}, err // Return the error to rollback the transaction
+	}
+
+	refunded = refunded.Add(refundAmount)
+	status = transactionStatusPartiallyRefunded
+	if refunded == amount {
+		status = transactionStatusRefunded
+	}
+	if err := original.Update("status", status).Error; err != nil {
+		return RefundResult{}, err
+	}
+
+	if err := outbox.EnqueueEvent(tx, events.TypeRefundCompleted, events.RefundCompleted{
+		RefundID:            refund.UID,
+		TransactionID:       req.TransactionReff,
+		TransactionCategory: category,
+		TransactionStatus:   status,
+		Amount:              refundAmount,
+		Currency:            code,
+		Reason:              req.Reason,
+	}); err != nil {
+		return RefundResult{}, err
+	}
+
+	return RefundResult{
+		RefundID:            refund.UID,
+		TransactionID:       req.TransactionReff,
+		TransactionCategory: category,
+		TransactionStatus:   status,
+		Amount:              refundAmount,
+		RefundedAmount:      refunded,
+		Currency:            code,
+		Reason:              req.Reason,
+		CreatedDate:         refund.CreatedAt,
+	}, nil
+}
+
+// refundPayment credits the payer back from the settlement account of the merchant,
+// or the shared merchant settlement account for payments made before merchants.
+// When the paying account has been closed the payer's default account is credited.
+func (h *AppHandler) refundPayment(ctx context.Context, tx *gorm.DB, payment models.PaymentTransaction, amount money.Money, reason string) error {
+	accountID, err := openAccountOrDefault(tx, payment.UserAccountID)
+	if err != nil {
+		return err
+	}
+	accounts, err := lockAccounts(tx, accountID)
+	if err != nil {
+		return err
+	}
+	userAccount := accounts[accountID]
+	if userAccount.Currency != payment.Currency {
+		return errCurrencyMismatch
+	}
+	if err := h.checkRefundMaxBalance(ctx, tx, userAccount, amount); err != nil {
+		return err
+	}
+
+	if err := creditAccount(tx, userAccount, amount, "REFUND", payment.UID, reason); err != nil {
+		return err
+	}
+
+	source := ledger.SystemAccount(ledger.AccountMerchantSettlement, payment.Currency)
+	if payment.MerchantID != nil {
+		source = ledger.MerchantAccountCode(*payment.MerchantID)
+	}
+	return postJournal(tx, payment.UID, "REFUND", reason,
+		ledger.Transfer(source, ledger.UserAccountCode(userAccount.ID), amount),
+		userAccount)
+}
+
+// refundTransfer moves money from the recipient of a transfer back to the sender.
+// Closed accounts are replaced by the default account of their owner. When the
+// transfer changed currency the recipient returns their share of what they
+// received, at the rate of the transfer; refunded is what was refunded before.
+func (h *AppHandler) refundTransfer(ctx context.Context, tx *gorm.DB, transfer models.TransferTransaction, refunded, amount money.Money, reason string) error {
+	senderAccountID, err := openAccountOrDefault(tx, transfer.UserAccountID)
+	if err != nil {
+		return err
+	}
+	targetAccountID, err := openAccountOrDefault(tx, transfer.TargetUserID)
+	if err != nil {
+		return err
+	}
+
+	// Lock both accounts in a fixed order so opposite transfers cannot deadlock
+	accounts, err := lockAccounts(tx, senderAccountID, targetAccountID)
+	if err != nil {
+		return err
+	}
+	senderAccount := accounts[senderAccountID]
+	targetAccount := accounts[targetAccountID]
+	if senderAccount.Currency != transfer.Currency || targetAccount.Currency != transfer.TargetCurrency {
+		return errCurrencyMismatch
+	}
+
+	// Shares are taken of the running total, so the last refund returns exactly the target amount
+	targetAmount := prorate(transfer.TargetAmount, refunded.Add(amount), transfer.Amount).
+		Sub(prorate(transfer.TargetAmount, refunded, transfer.Amount))
+	if !targetAmount.IsPositive() {
+		return errFxAmountTooSmall
+	}
+	if availableBalance(targetAccount).LessThan(targetAmount) {
+		return errRefundInsufficientBalance
+	}
+
+	if err := debitAccount(tx, targetAccount, targetAmount, "REFUND", transfer.UID, reason); err != nil {
+		return err
+	}
+	// Re-read the sender after saving the target, in case both are the same account
+	if senderAccount.ID == targetAccount.ID {
+		senderAccount = targetAccount
+	}
+	if err := h.checkRefundMaxBalance(ctx, tx, senderAccount, amount); err != nil {
+		return err
+	}
+	if err := creditAccount(tx, senderAccount, amount, "REFUND", transfer.UID, reason); err != nil {
+		return err
+	}
+
+	lines := ledger.Transfer(ledger.UserAccountCode(targetAccount.ID), ledger.UserAccountCode(senderAccount.ID), amount)
+	if transfer.TargetCurrency != transfer.Currency {
+		lines = append(
+			ledger.Transfer(ledger.UserAccountCode(targetAccount.ID), ledger.SystemAccount(ledger.AccountFxPosition, transfer.TargetCurrency), targetAmount),
+			ledger.Transfer(ledger.SystemAccount(ledger.AccountFxPosition, transfer.Currency), ledger.UserAccountCode(senderAccount.ID), amount)...)
+	}
+	return postJournal(tx, transfer.UID, "REFUND", reason, lines, targetAccount, senderAccount)
+}
+
+// checkRefundMaxBalance returns a *LimitExceededError when refunding amount to the
+// account would take its owner above their maximum balance. Such refunds fail like
+// incoming transfers over the maximum, rather than crediting more than the tier
+// allows; a smaller refund can be made once the owner has spent some of their balance.
+func (h *AppHandler) checkRefundMaxBalance(ctx context.Context, tx *gorm.DB, account *models.UserAccount, amount money.Money) error {
+	var user models.User
+	if err := tx.First(&user, account.UserID).Error; err != nil {
+		return err
+	}
+	return h.checkMaxBalance(ctx, tx, user, amount, account.Currency)
+}
+
+// prorate returns the share of total that part is of whole, rounded down.
+func prorate(total, part, whole money.Money) money.Money {
+	share := new(big.Int).Mul(big.NewInt(total.Minor()), big.NewInt(part.Minor()))
+	return money.FromMinor(share.Quo(share, big.NewInt(whole.Minor())).Int64())
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mnctech-restapi/cmd/rest-api/database/databasetest"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"net/http"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestRefundAboveMaxBalanceFails(t *testing.T) {
+	db := databasetest.Open(t)
+	h := &AppHandler{DB: db, AmountPolicy: DefaultAmountPolicy()}
+
+	user, userAccount := createTestUser(t, db, money.FromMajor(100))
+	merchant := createTestMerchant(t, db)
+	if code := callAsUser(h.HandlePayment, user.UID, PaymentRequest{MerchantID: merchant.UID, Amount: money.FromMajor(50), Remarks: "refund"}); code != http.StatusOK {
+		t.Fatalf("payment: got status %d", code)
+	}
+	var payment models.PaymentTransaction
+	db.Where("user_account_id = ?", userAccount.ID).First(&payment)
+	t.Cleanup(func() { db.Unscoped().Where("transaction_reff = ?", payment.UID).Delete(&models.Refund{}) })
+
+	h.Limits = LimitPolicy{KYCTierBasic: {MaxBalance: money.FromMajor(80)}}
+	refund := func(amount money.Money) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			_, err := h.refundTransaction(context.Background(), tx, refundRequest{
+				TransactionReff: payment.UID,
+				Amount:          amount,
+				Reason:          "test",
+				InitiatorType:   refundInitiatorAdmin,
+			})
+			return err
+		})
+	}
+
+	var limitErr *LimitExceededError
+	if err := refund(money.FromMajor(50)); !errors.As(err, &limitErr) || limitErr.Limit != limitMaxBalance || limitErr.Remaining != money.FromMajor(30) {
+		t.Fatalf("refund above the maximum balance: got %v, want MAX_BALANCE with 30.00 remaining", err)
+	}
+	if err := refund(money.FromMajor(30)); err != nil {
+		t.Fatalf("refund up to the maximum balance: %v", err)
+	}
+
+	var reloaded models.UserAccount
+	db.First(&reloaded, userAccount.ID)
+	if want := money.FromMajor(80); reloaded.CurrentBalance != want {
+		t.Errorf("got balance %s, want %s", reloaded.CurrentBalance, want)
+	}
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type SessionResponse struct {
+	SessionID   string    `json:"session_id"`
+	UserAgent   string    `json:"user_agent"`
+	IPAddress   string    `json:"ip_address"`
+	Current     bool      `json:"current"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	CreatedDate time.Time `json:"created_date"`
+}
+
+// revokeSessions revokes every active session matching the condition together
+// with the refresh tokens issued within them.
+func revokeSessions(tx *gorm.DB, query interface{}, args ...interface{}) error {
+	var sessionIDs []string
+	if err := tx.Model(&models.Session{}).Where(query, args...).Where("revoked_at IS NULL").Pluck("uid", &sessionIDs).Error; err != nil {
+		return err
+	}
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if err := tx.Model(&models.Session{}).Where("uid IN ?", sessionIDs).Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.RefreshToken{}).
+		Where("family_id IN ? AND revoked_at IS NULL", sessionIDs).
+		Update("revoked_at", now).Error
+}
+
+// Logout revokes the session of the access token used for the request.
+func (h *AppHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	sessionID, ok := r.Context().Value(auth.SessionIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Session ID not found in context"))
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		return revokeSessions(tx, "uid = ?", sessionID)
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to logout"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(nil))
+}
+
+// LogoutAll revokes every session of the authenticated user.
+func (h *AppHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Where("uid = ?", userID).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("user not found")
+			}
+			return err
+		}
+		return revokeSessions(tx, "user_id = ?", user.ID)
+	})
+	if err != nil {
+		if err.Error() == "user not found" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to logout"))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(nil))
+}
+
+// GetSessions lists the active sessions of the authenticated user.
+func (h *AppHandler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+	currentSessionID, _ := r.Context().Value(auth.SessionIDKey).(string)
+
+	var user models.User
+	if err := h.DB.Where("uid = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve sessions"))
+		return
+	}
+
+	var sessions []models.Session
+	if err := h.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Order("last_seen_at desc").Find(&sessions).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve sessions"))
+		return
+	}
+
+	sessionResponses := []SessionResponse{}
+	for _, session := range sessions {
+		sessionResponses = append(sessionResponses, SessionResponse{
+			SessionID:   session.UID,
+			UserAgent:   session.UserAgent,
+			IPAddress:   session.IPAddress,
+			Current:     session.UID == currentSessionID,
+			LastSeenAt:  session.LastSeenAt,
+			ExpiresAt:   session.ExpiresAt,
+			CreatedDate: session.CreatedAt,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(sessionResponses))
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mnctech-restapi/cmd/rest-api/events"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/outbox"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Payments in these statuses moved money to the merchant. Their refunds are
+// settled separately, when they are made.
+var settledPaymentStatuses = []string{"SUCCESS", transactionStatusPartiallyRefunded, transactionStatusRefunded}
+
+// SettleMerchants creates the settlement batch of every merchant for the business
+// date, covering its payments and refunds made before the end of that day that no
+// earlier batch covered. Each merchant is settled in its own transaction, so a
+// failure leaves the others settled and running it again settles what is left.
+// It returns the number of batches created.
+func (h *AppHandler) SettleMerchants(ctx context.Context, businessDate time.Time) (int, error) {
+	day := time.Date(businessDate.Year(), businessDate.Month(), businessDate.Day(), 0, 0, 0, 0, time.Local)
+
+	var merchantIDs []uint
+	if err := h.DB.WithContext(ctx).Model(&models.Merchant{}).Order("id ASC").Pluck("id", &merchantIDs).Error; err != nil {
+		return 0, err
+	}
+
+	settled := 0
+	var failed error
+	for _, merchantID := range merchantIDs {
+		if err := ctx.Err(); err != nil {
+			return settled, err
+		}
+
+		var created bool
+		err := h.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			created, err = settleMerchant(tx, merchantID, day)
+			return err
+		})
+		if err != nil {
+			log.Printf("Error settling merchant %d: %v", merchantID, err)
+			failed = errors.Join(failed, fmt.Errorf("merchant %d: %w", merchantID, err))
+			continue
+		}
+		if created {
+			settled++
+		}
+	}
+	return settled, failed
+}
+
+// settleMerchant settles one merchant for the business day starting at day. It
+// creates no batch when one exists for the day or nothing is left to pay out;
+// when refunds outweigh payments they are carried to the next batch.
+func settleMerchant(tx *gorm.DB, merchantID uint, day time.Time) (bool, error) {
+	// Concurrent runs wait for this lock, so nothing is settled twice
+	var merchant models.Merchant
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&merchant, merchantID).Error; err != nil {
+		return false, err
+	}
+
+	var existing int64
+	if err := tx.Model(&models.MerchantSettlement{}).
+		Where("merchant_id = ? AND business_date = ?", merchant.ID, day.Format("2006-01-02")).
+		Count(&existing).Error; err != nil {
+		return false, err
+	}
+	if existing > 0 {
+		return false, nil
+	}
+
+	cutoff := day.AddDate(0, 0, 1)
+	var payments []models.PaymentTransaction
+	if err := tx.Select("id", "amount").
+		Where("merchant_id = ? AND settlement_id IS NULL AND created_at < ? AND status IN ?", merchant.ID, cutoff, settledPaymentStatuses).
+		Find(&payments).Error; err != nil {
+		return false, err
+	}
+	var refunds []models.Refund
+	if err := tx.Select("id", "amount").
+		Where("merchant_id = ? AND settlement_id IS NULL AND created_at < ?", merchant.ID, cutoff).
+		Find(&refunds).Error; err != nil {
+		return false, err
+	}
+
+	var gross, refunded money.Money
+	paymentIDs := make([]uint, 0, len(payments))
+	for _, payment := range payments {
+		gross = gross.Add(payment.Amount)
+		paymentIDs = append(paymentIDs, payment.ID)
+	}
+	refundIDs := make([]uint, 0, len(refunds))
+	for _, refund := range refunds {
+		refunded = refunded.Add(refund.Amount)
+		refundIDs = append(refundIDs, refund.ID)
+	}
+	net := gross.Sub(refunded)
+	if !net.IsPositive() {
+		return false, nil
+	}
+
+	settlement := models.MerchantSettlement{
+		UID:                 uuid.New().String(),
+		MerchantID:          merchant.ID,
+		BusinessDate:        day,
+		Currency:            merchant.Currency,
+		GrossAmount:         gross,
+		RefundAmount:        refunded,
+		NetAmount:           net,
+		PaymentCount:        len(payments),
+		RefundCount:         len(refunds),
+		PayoutBankCode:      merchant.PayoutBankCode,
+		PayoutAccountNumber: merchant.PayoutAccountNumber,
+		PayoutAccountName:   merchant.PayoutAccountName,
+	}
+	if err := tx.Create(&settlement).Error; err != nil {
+		return false, err
+	}
+
+	if err := tx.Model(&models.PaymentTransaction{}).Where("id IN ?", paymentIDs).Update("settlement_id", settlement.ID).Error; err != nil {
+		return false, err
+	}
+	if len(refundIDs) > 0 {
+		if err := tx.Model(&models.Refund{}).Where("id IN ?", refundIDs).Update("settlement_id", settlement.ID).Error; err != nil {
+			return false, err
+		}
+	}
+
+	description := fmt.Sprintf("Settlement of %s for %s", merchant.Name, day.Format("2006-01-02"))
+	if err := postJournal(tx, settlement.UID, "SETTLEMENT", description,
+		ledger.Transfer(ledger.MerchantAccountCode(merchant.ID), ledger.SystemAccount(ledger.AccountMerchantPayout, merchant.Currency), net)); err != nil {
+		return false, err
+	}
+
+	if err := outbox.EnqueueEvent(tx, events.TypeMerchantSettled, events.MerchantSettled{
+		SettlementID:        settlement.UID,
+		MerchantID:          merchant.UID,
+		BusinessDate:        day.Format("2006-01-02"),
+		Currency:            merchant.Currency,
+		GrossAmount:         gross,
+		RefundAmount:        refunded,
+		NetAmount:           net,
+		PayoutBankCode:      merchant.PayoutBankCode,
+		PayoutAccountNumber: merchant.PayoutAccountNumber,
+		PayoutAccountName:   merchant.PayoutAccountName,
+	}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
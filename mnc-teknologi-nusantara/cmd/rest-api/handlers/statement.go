@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"mnctech-restapi/cmd/rest-api/statement"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GetStatement renders the caller's statement for ?month=YYYY-MM as CSV or PDF (?format=csv|pdf).
+func (h *AppHandler) GetStatement(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var fieldErrors []responses.FieldError
+	month, err := time.ParseInLocation("2006-01", r.URL.Query().Get("month"), time.Local)
+	if err != nil {
+		fieldErrors = append(fieldErrors, responses.FieldError{Field: "month", Code: "INVALID_FORMAT", Message: "must be a month as YYYY-MM"})
+	} else if month.After(time.Now()) {
+		fieldErrors = append(fieldErrors, responses.FieldError{Field: "month", Code: "INVALID_VALUE", Message: "must not be in the future"})
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "pdf" {
+		fieldErrors = append(fieldErrors, responses.FieldError{Field: "format", Code: "INVALID_VALUE", Message: `must be "csv" or "pdf"`})
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	user, err := findUserByUID(h.DB, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		return
+	}
+
+	// The statement of the default account unless account_id picks another, closed accounts included
+	var userAccount models.UserAccount
+	accounts := h.DB.Unscoped().Where("user_id = ?", user.ID)
+	if accountUID := r.URL.Query().Get("account_id"); accountUID != "" {
+		if _, err := uuid.Parse(accountUID); err != nil {
+			writeAccountNotFound(w)
+			return
+		}
+		accounts = accounts.Where("uid = ?", accountUID)
+	} else {
+		accounts = accounts.Where("is_default AND deleted_at IS NULL")
+	}
+	if err := accounts.First(&userAccount).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeAccountNotFound(w)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to build statement"))
+		return
+	}
+
+	st, err := statement.Build(h.DB, userAccount, user, month)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to build statement"))
+		return
+	}
+
+	// Render into a buffer so a failure can still be answered with an error
+	var body bytes.Buffer
+	contentType := "text/csv; charset=utf-8"
+	if format == "pdf" {
+		contentType = "application/pdf"
+		err = statement.WritePDF(&body, st)
+	} else {
+		err = statement.WriteCSV(&body, st)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to render statement"))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="statement-%s.%s"`, st.Period(), format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
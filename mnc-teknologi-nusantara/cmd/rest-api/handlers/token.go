@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	accessTokenTTL  = 24 * time.Hour     // Access tokens expire in 24 hours
+	refreshTokenTTL = 7 * 24 * time.Hour // Refresh tokens expire in 7 days
+)
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// startSession records a new session for the user logging in from the request.
+func startSession(tx *gorm.DB, user models.User, r *http.Request) (models.Session, error) {
+	now := time.Now()
+	session := models.Session{
+		UID:        uuid.New().String(),
+		UserID:     user.ID,
+		UserAgent:  r.UserAgent(),
+		IPAddress:  clientIP(r),
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL),
+	}
+	if err := tx.Create(&session).Error; err != nil {
+		return models.Session{}, err
+	}
+	return session, nil
+}
+
+// issueTokens signs a new access/refresh token pair for the user within the given
+// session and records the refresh token as a member of the session's token family.
+func (h *AuthHandler) issueTokens(tx *gorm.DB, user models.User, session models.Session) (LoginResponse, error) {
+	now := time.Now()
+
+	// Create JWT token
+	accessClaims := &auth.CustomClaims{
+		UID:       user.UID,
+		SessionID: session.UID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
+		},
+	}
+	// Sign the access token with the active signing key
+	accessTokenString, err := h.AccessTokenKeys.Sign(accessClaims)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	// Create JWT refresh token
+	refreshExpirationTime := now.Add(refreshTokenTTL)
+	refreshClaims := &auth.CustomClaims{
+		UID:       user.UID,
+		SessionID: session.UID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(refreshExpirationTime),
+		},
+	}
+	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
+
+	// Sign the refresh token with the secret key
+	refreshTokenString, err := refreshToken.SignedString(h.RefreshTokenKey)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	// Remember the refresh token so it can only be exchanged once
+	storedToken := models.RefreshToken{
+		UID:       refreshClaims.ID,
+		FamilyID:  session.UID,
+		UserID:    user.ID,
+		ExpiresAt: refreshExpirationTime,
+	}
+	if err := tx.Create(&storedToken).Error; err != nil {
+		return LoginResponse{}, err
+	}
+
+	return LoginResponse{
+		AccessToken:  accessTokenString,
+		RefreshToken: refreshTokenString,
+	}, nil
+}
+
+// RefreshToken exchanges a valid refresh token for a new access/refresh token pair.
+// The presented refresh token is rotated out; presenting it again revokes its whole family.
+func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Parse the refresh token
+	claims := &auth.CustomClaims{}
+	token, err := jwt.ParseWithClaims(req.RefreshToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return h.RefreshTokenKey, nil
+	})
+	if err != nil || !token.Valid || claims.ID == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid refresh token"))
+		return
+	}
+
+	var response LoginResponse
+	reused := false
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the stored token so concurrent exchanges of the same token are serialized
+		var storedToken models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("uid = ?", claims.ID).First(&storedToken).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("invalid refresh token")
+			}
+			return err
+		}
+
+		if storedToken.RevokedAt != nil {
+			return errors.New("invalid refresh token")
+		}
+
+		now := time.Now()
+
+		// A rotated token presented again means it has leaked, so revoke the whole family
+		if storedToken.UsedAt != nil {
+			reused = true
+			return revokeSessions(tx, "uid = ?", storedToken.FamilyID)
+		}
+
+		var session models.Session
+		if err := tx.Where("uid = ? AND revoked_at IS NULL AND expires_at > ?", storedToken.FamilyID, now).First(&session).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("invalid refresh token")
+			}
+			return err
+		}
+
+		var user models.User
+		if err := tx.First(&user, storedToken.UserID).Error; err != nil {
+			return err
+		}
+		if user.UID != claims.UID {
+			return errors.New("invalid refresh token")
+		}
+
+		// Rotate the presented token out
+		storedToken.UsedAt = &now
+		if err := tx.Save(&storedToken).Error; err != nil {
+			return err
+		}
+
+		// Keep the session alive for as long as its newest refresh token
+		session.LastSeenAt = now
+		session.ExpiresAt = now.Add(refreshTokenTTL)
+		if err := tx.Save(&session).Error; err != nil {
+			return err
+		}
+
+		var err error
+		response, err = h.issueTokens(tx, user, session)
+		return err
+	})
+
+	if err != nil {
+		if err.Error() == "invalid refresh token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid refresh token"))
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to refresh token"))
+		}
+		return
+	}
+
+	if reused {
+		log.Printf("Refresh token reuse detected for user %s, session %s revoked", claims.UID, claims.SessionID)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Refresh token has already been used"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(response))
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Directions of a transfer as seen by the caller.
+const (
+	transferDirectionOutgoing = "OUTGOING"
+	transferDirectionIncoming = "INCOMING"
+)
+
+// CounterpartyResponse is the other user of a transfer.
+type CounterpartyResponse struct {
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// PaidMerchantResponse identifies the merchant of a payment.
+type PaidMerchantResponse struct {
+	MerchantID string `json:"merchant_id"`
+	Name       string `json:"name"`
+}
+
+// TransactionDetailResponse represents one top-up, payment or transfer of the caller.
+type TransactionDetailResponse struct {
+	TransactionID       string                `json:"transaction_id"`       // top_up_id, payment_id or transfer_id
+	TransactionCategory string                `json:"transaction_category"` // TOPUP, PAYMENT or TRANSFER
+	Status              string                `json:"status"`
+	Amount              money.Money           `json:"amount"`
+	Currency            string                `json:"currency"`
+	Remarks             string                `json:"remarks"`
+	ErrMessage          string                `json:"err_message,omitempty"`
+	Direction           string                `json:"direction,omitempty"`    // Transfers only: OUTGOING or INCOMING
+	Counterparty        *CounterpartyResponse `json:"counterparty,omitempty"` // Transfers only
+	Merchant            *PaidMerchantResponse `json:"merchant,omitempty"`     // Payments only
+	Entries             []TransactionResponse `json:"entries"`                // Balance changes of the caller's accounts
+	CreatedDate         time.Time             `json:"created_date"`
+	UpdatedDate         time.Time             `json:"updated_date"`
+}
+
+// GetTransactionDetail returns the transaction with the given top_up_id, payment_id
+// or transfer_id, if it touched one of the caller's accounts.
+func (h *AppHandler) GetTransactionDetail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	transactionID := mux.Vars(r)["transaction_id"]
+	if _, err := uuid.Parse(transactionID); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Transaction not found"))
+		return
+	}
+
+	user, err := findUserByUID(h.DB, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		return
+	}
+
+	// The log rows of the caller's accounts decide whether the caller may see the transaction
+	var transactionLogs []models.AccountTransactionLog
+	if err := h.DB.Where("transaction_reff = ? AND user_account_id IN (?)", transactionID, accountIDsOfUser(h.DB, user.ID)).
+		Order("id").Find(&transactionLogs).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve transaction"))
+		return
+	}
+	if len(transactionLogs) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Transaction not found"))
+		return
+	}
+
+	detail, err := transactionDetail(h.DB, user.UID, transactionLogs)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve transaction"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(detail))
+}
+
+// transactionDetail resolves the log rows of one transaction to its top-up, payment
+// or transfer record. Requests that failed before a record was stored, such as a
+// payment without enough balance, are described by their log row alone.
+func transactionDetail(db *gorm.DB, userUID string, transactionLogs []models.AccountTransactionLog) (TransactionDetailResponse, error) {
+	first := transactionLogs[0]
+	detail := TransactionDetailResponse{
+		TransactionID:       first.TransactionReff,
+		TransactionCategory: first.TransactionCategory,
+		Status:              first.Status,
+		Amount:              first.Amount,
+		Currency:            first.Currency,
+		Remarks:             first.Remarks,
+		ErrMessage:          first.ErrMessage,
+		CreatedDate:         first.CreatedAt,
+		UpdatedDate:         first.UpdatedAt,
+	}
+	ownAccounts := map[uint]bool{}
+	for _, log := range transactionLogs {
+		ownAccounts[log.UserAccountID] = true
+		detail.Entries = append(detail.Entries, TransactionResponse{
+			TransferID:      log.TransactionReff,
+			Status:          log.Status,
+			UserID:          userUID,
+			TransactionType: log.TransactionType,
+			Amount:          log.Amount,
+			Currency:        log.Currency,
+			Remarks:         log.Remarks,
+			BalanceBefore:   log.BalanceBefore,
+			BalanceAfter:    log.BalanceAfter,
+			CreatedDate:     log.CreatedAt,
+		})
+	}
+
+	var err error
+	switch first.TransactionCategory {
+	case "TOPUP":
+		var topUp models.TopUpTransaction
+		if err = db.Where("uid = ?", first.TransactionReff).First(&topUp).Error; err == nil {
+			detail.Status = topUp.Status
+			detail.UpdatedDate = topUp.UpdatedAt
+		}
+	case "PAYMENT":
+		var payment models.PaymentTransaction
+		if err = db.Where("uid = ?", first.TransactionReff).First(&payment).Error; err == nil {
+			detail.Status = payment.Status
+			detail.Remarks = payment.Remarks
+			detail.UpdatedDate = payment.UpdatedAt
+
+			// Payments made before merchants have none
+			if payment.MerchantID != nil {
+				var merchant models.Merchant
+				if err = db.First(&merchant, *payment.MerchantID).Error; err == nil {
+					detail.Merchant = &PaidMerchantResponse{MerchantID: merchant.UID, Name: merchant.Name}
+				}
+			}
+		}
+	case "TRANSFER":
+		var transfer models.TransferTransaction
+		if err = db.Where("uid = ?", first.TransactionReff).First(&transfer).Error; err == nil {
+			detail.Status = transfer.Status
+			detail.Remarks = transfer.Remarks
+			detail.ErrMessage = transfer.ErrMessage
+			detail.UpdatedDate = transfer.UpdatedAt
+
+			// A transfer between two accounts of the caller counts as outgoing
+			counterpartyAccountID := transfer.TargetUserID
+			detail.Direction = transferDirectionOutgoing
+			if !ownAccounts[transfer.UserAccountID] {
+				counterpartyAccountID = transfer.UserAccountID
+				detail.Direction = transferDirectionIncoming
+			}
+			var counterparty models.User
+			err = db.Joins("JOIN user_accounts ON user_accounts.user_id = users.id").
+				Where("user_accounts.id = ?", counterpartyAccountID).
+				First(&counterparty).Error
+			if err == nil {
+				detail.Counterparty = &CounterpartyResponse{
+					UserID: counterparty.UID,
+					Name:   strings.TrimSpace(counterparty.FirstName + " " + counterparty.LastName),
+				}
+			}
+		}
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return TransactionDetailResponse{}, err
+	}
+	return detail, nil
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultTransactionPageSize = 20
+	maxTransactionPageSize     = 100
+)
+
+// TransactionListQuery holds the pagination, filters and sort order of GET /transactions.
+type TransactionListQuery struct {
+	Limit               int
+	Order               string // "desc" (newest first, the default) or "asc"
+	AfterID             uint   // Last row of the previous page, zero for the first page
+	From                *time.Time
+	To                  *time.Time // Exclusive
+	TransactionType     string
+	TransactionCategory string
+	Status              string
+	MinAmount           *money.Money
+	MaxAmount           *money.Money
+}
+
+// transactionCursor is the content of the opaque cursor returned as next_cursor.
+type transactionCursor struct {
+	ID    uint   `json:"id"`
+	Order string `json:"order"`
+}
+
+func encodeTransactionCursor(cursor transactionCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeTransactionCursor(value string) (transactionCursor, error) {
+	var cursor transactionCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, err
+	}
+	if cursor.ID == 0 {
+		return cursor, fmt.Errorf("cursor without position")
+	}
+	return cursor, nil
+}
+
+// parseTransactionListQuery reads the query string of GET /transactions. Dates are
+// YYYY-MM-DD, where "to" includes the whole day, or RFC 3339 timestamps.
+func parseTransactionListQuery(values url.Values) (TransactionListQuery, []responses.FieldError) {
+	query := TransactionListQuery{Limit: defaultTransactionPageSize, Order: "desc"}
+	var errs []responses.FieldError
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxTransactionPageSize {
+			errs = append(errs, responses.FieldError{Field: "limit", Code: "OUT_OF_RANGE", Message: fmt.Sprintf("must be between 1 and %d", maxTransactionPageSize)})
+		} else {
+			query.Limit = limit
+		}
+	}
+
+	if value := values.Get("order"); value != "" {
+		if value != "asc" && value != "desc" {
+			errs = append(errs, responses.FieldError{Field: "order", Code: "INVALID_VALUE", Message: `must be "asc" or "desc"`})
+		} else {
+			query.Order = value
+		}
+	}
+
+	if value := values.Get("cursor"); value != "" {
+		cursor, err := decodeTransactionCursor(value)
+		switch {
+		case err != nil:
+			errs = append(errs, responses.FieldError{Field: "cursor", Code: "INVALID_FORMAT", Message: "is not a cursor returned by this endpoint"})
+		case cursor.Order != query.Order:
+			errs = append(errs, responses.FieldError{Field: "cursor", Code: "INVALID_VALUE", Message: "was returned for a different order"})
+		default:
+			query.AfterID = cursor.ID
+		}
+	}
+
+	if value := values.Get("from"); value != "" {
+		from, _, err := parseDateOrTime(value)
+		if err != nil {
+			errs = append(errs, responses.FieldError{Field: "from", Code: "INVALID_FORMAT", Message: "must be a date (YYYY-MM-DD) or an RFC 3339 timestamp"})
+		} else {
+			query.From = &from
+		}
+	}
+	if value := values.Get("to"); value != "" {
+		to, isDate, err := parseDateOrTime(value)
+		if err != nil {
+			errs = append(errs, responses.FieldError{Field: "to", Code: "INVALID_FORMAT", Message: "must be a date (YYYY-MM-DD) or an RFC 3339 timestamp"})
+		} else {
+			if isDate {
+				to = to.AddDate(0, 0, 1) // Include the whole day
+			}
+			query.To = &to
+		}
+	}
+	if query.From != nil && query.To != nil && !query.From.Before(*query.To) {
+		errs = append(errs, responses.FieldError{Field: "to", Code: "INVALID_RANGE", Message: "must be after from"})
+	}
+
+	query.TransactionType = strings.ToUpper(values.Get("transaction_type"))
+	if query.TransactionType != "" && query.TransactionType != "DEBIT" && query.TransactionType != "CREDIT" {
+		errs = append(errs, responses.FieldError{Field: "transaction_type", Code: "INVALID_VALUE", Message: `must be "DEBIT" or "CREDIT"`})
+	}
+	query.TransactionCategory = strings.ToUpper(values.Get("transaction_category"))
+	query.Status = strings.ToUpper(values.Get("status"))
+
+	for _, field := range []string{"min_amount", "max_amount"} {
+		value := values.Get(field)
+		if value == "" {
+			continue
+		}
+		amount, err := money.Parse(value)
+		if err != nil {
+			errs = append(errs, responses.FieldError{Field: field, Code: "INVALID_FORMAT", Message: "must be a decimal number"})
+			continue
+		}
+		if field == "min_amount" {
+			query.MinAmount = &amount
+		} else {
+			query.MaxAmount = &amount
+		}
+	}
+	if query.MinAmount != nil && query.MaxAmount != nil && query.MinAmount.GreaterThan(*query.MaxAmount) {
+		errs = append(errs, responses.FieldError{Field: "max_amount", Code: "INVALID_RANGE", Message: "must not be less than min_amount"})
+	}
+
+	return query, errs
+}
+
+// parseDateOrTime parses a YYYY-MM-DD date in the server's time zone or an RFC 3339 timestamp.
+func parseDateOrTime(value string) (t time.Time, isDate bool, err error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, value)
+	return t, false, err
+}
+
+// Apply adds the filters, the cursor position and the sort order to a query of
+// AccountTransactionLog rows. It fetches one row more than the limit so the
+// caller can tell whether another page follows.
+func (q TransactionListQuery) Apply(db *gorm.DB) *gorm.DB {
+	if q.From != nil {
+		db = db.Where("created_at >= ?", *q.From)
+	}
+	if q.To != nil {
+		db = db.Where("created_at < ?", *q.To)
+	}
+	if q.TransactionType != "" {
+		db = db.Where("transaction_type = ?", q.TransactionType)
+	}
+	if q.TransactionCategory != "" {
+		db = db.Where("transaction_category = ?", q.TransactionCategory)
+	}
+	if q.Status != "" {
+		db = db.Where("status = ?", q.Status)
+	}
+	if q.MinAmount != nil {
+		db = db.Where("amount >= ?", *q.MinAmount)
+	}
+	if q.MaxAmount != nil {
+		db = db.Where("amount <= ?", *q.MaxAmount)
+	}
+
+	// IDs grow with the creation time, so they give a stable order to page through
+	if q.Order == "asc" {
+		if q.AfterID != 0 {
+			db = db.Where("id > ?", q.AfterID)
+		}
+		db = db.Order("id asc")
+	} else {
+		if q.AfterID != 0 {
+			db = db.Where("id < ?", q.AfterID)
+		}
+		db = db.Order("id desc")
+	}
+	return db.Limit(q.Limit + 1)
+}
+
+// nextCursor returns the cursor of the page after the one ending with lastID.
+func (q TransactionListQuery) nextCursor(lastID uint) *string {
+	cursor := encodeTransactionCursor(transactionCursor{ID: lastID, Order: q.Order})
+	return &cursor
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// transferInquiryTTL is how long the sender has to confirm an inquiry with /transfer.
+const transferInquiryTTL = 5 * time.Minute
+
+var (
+	errSelfTransfer           = errors.New("cannot transfer to yourself")
+	errInvalidTransferInquiry = errors.New("invalid transfer inquiry")
+)
+
+// InquiryRateLimit bounds how many recipients a user may look up, so
+// /transfer/inquiry cannot be used to find out which phone numbers are registered.
+type InquiryRateLimit struct {
+	MaxLookups int           // Lookups allowed within Window, zero disables the limit
+	Window     time.Duration // Sliding window the lookups are counted in
+}
+
+// DefaultInquiryRateLimit returns the inquiry limit used when none is configured.
+func DefaultInquiryRateLimit() InquiryRateLimit {
+	return InquiryRateLimit{
+		MaxLookups: 10,
+		Window:     10 * time.Minute,
+	}
+}
+
+// inquiryRateLimitedError reports that a user ran out of lookups.
+type inquiryRateLimitedError struct {
+	RetryAt time.Time // When the oldest lookup in the window leaves it
+}
+
+func (e *inquiryRateLimitedError) Error() string {
+	return "too many transfer inquiries"
+}
+
+// TransferInquiryRequest represents the expected payload of POST /transfer/inquiry.
+type TransferInquiryRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required"` // Phone number of the recipient
+}
+
+// TransferInquiryResponse shows enough of the recipient for the sender to recognise them.
+type TransferInquiryResponse struct {
+	InquiryToken         string    `json:"inquiry_token"` // Pass to /transfer to send money to this recipient
+	RecipientName        string    `json:"recipient_name"`
+	RecipientPhoneNumber string    `json:"recipient_phone_number"`
+	ExpiresAt            time.Time `json:"expires_at"`
+}
+
+// TransferInquiry looks up the recipient of a transfer by phone number and returns
+// their masked name together with a single-use inquiry token.
+func (h *AppHandler) TransferInquiry(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var req TransferInquiryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+	if fieldErrors := validateRequest(req); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	user, err := findUserByUID(h.DB, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		return
+	}
+
+	// Lookups count whether or not the phone number is registered
+	if err := h.InquiryLimit.recordLookup(h.DB, user.ID); err != nil {
+		var limited *inquiryRateLimitedError
+		if errors.As(err, &limited) {
+			writeTooManyInquiries(w, limited.RetryAt)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to create inquiry"))
+		return
+	}
+
+	var targetUser models.User
+	if err := h.DB.Where("phone_number = ?", req.PhoneNumber).First(&targetUser).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Recipient not found"))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to find recipient"))
+		return
+	}
+	if targetUser.ID == user.ID {
+		writeSelfTransfer(w)
+		return
+	}
+
+	inquiryToken, err := newOpaqueToken()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to create inquiry"))
+		return
+	}
+	inquiry := models.TransferInquiry{
+		UID:          uuid.New().String(),
+		UserID:       user.ID,
+		TargetUserID: targetUser.ID,
+		TokenHash:    auth.HashToken(inquiryToken),
+		ExpiresAt:    time.Now().Add(transferInquiryTTL),
+	}
+	if err := h.DB.Create(&inquiry).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to create inquiry"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(TransferInquiryResponse{
+		InquiryToken:         inquiryToken,
+		RecipientName:        maskName(targetUser.FirstName + " " + targetUser.LastName),
+		RecipientPhoneNumber: maskPhoneNumber(targetUser.PhoneNumber),
+		ExpiresAt:            inquiry.ExpiresAt,
+	}))
+}
+
+// recordLookup counts a recipient lookup of the user, or returns an
+// *inquiryRateLimitedError when the user already used up the lookups of the window.
+func (l InquiryRateLimit) recordLookup(db *gorm.DB, userID uint) error {
+	if l.MaxLookups <= 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Serialize lookups of the same user so concurrent ones are all counted
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, userID).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		windowStart := now.Add(-l.Window)
+		if err := tx.Unscoped().Where("user_id = ? AND created_at <= ?", userID, windowStart).
+			Delete(&models.TransferInquiryLookup{}).Error; err != nil {
+			return err
+		}
+
+		var lookups []models.TransferInquiryLookup
+		if err := tx.Where("user_id = ?", userID).Order("created_at DESC").Limit(l.MaxLookups).Find(&lookups).Error; err != nil {
+			return err
+		}
+		if len(lookups) >= l.MaxLookups {
+			return &inquiryRateLimitedError{RetryAt: lookups[len(lookups)-1].CreatedAt.Add(l.Window)}
+		}
+		return tx.Create(&models.TransferInquiryLookup{UserID: userID}).Error
+	})
+}
+
+// writeTooManyInquiries responds that the user may look up recipients again at retryAt.
+func writeTooManyInquiries(w http.ResponseWriter, retryAt time.Time) {
+	retryAfter := int(math.Ceil(time.Until(retryAt).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("TOO_MANY_INQUIRIES", "Too many recipient lookups, please try again later"))
+}
+
+// consumeTransferInquiry locks the sender's unexpired, unused inquiry with the
+// token and marks it used. The mark is undone if the transfer rolls back.
+func consumeTransferInquiry(tx *gorm.DB, userID uint, inquiryToken string) (models.TransferInquiry, error) {
+	var inquiry models.TransferInquiry
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ? AND user_id = ? AND consumed_at IS NULL AND expires_at > ?", auth.HashToken(inquiryToken), userID, time.Now()).
+		First(&inquiry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.TransferInquiry{}, errInvalidTransferInquiry
+	}
+	if err != nil {
+		return models.TransferInquiry{}, err
+	}
+
+	now := time.Now()
+	if err := tx.Model(&inquiry).Update("consumed_at", &now).Error; err != nil {
+		return models.TransferInquiry{}, err
+	}
+	return inquiry, nil
+}
+
+// writeSelfTransfer responds to a transfer whose recipient is the sender.
+func writeSelfTransfer(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("SELF_TRANSFER", "You cannot transfer to yourself"))
+}
+
+// maskName keeps the first and last letter of every word, e.g. "Budi Santoso" becomes "B**i S*****o".
+func maskName(name string) string {
+	words := strings.Fields(name)
+	for i, word := range words {
+		runes := []rune(word)
+		switch {
+		case len(runes) <= 1:
+		case len(runes) == 2:
+			words[i] = string(runes[0]) + "*"
+		default:
+			words[i] = string(runes[0]) + strings.Repeat("*", len(runes)-2) + string(runes[len(runes)-1])
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// maskPhoneNumber keeps the first four and last three digits, e.g. "0811****501".
+func maskPhoneNumber(phoneNumber string) string {
+	if len(phoneNumber) <= 7 {
+		return strings.Repeat("*", len(phoneNumber))
+	}
+	return phoneNumber[:4] + strings.Repeat("*", len(phoneNumber)-7) + phoneNumber[len(phoneNumber)-3:]
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"mnctech-restapi/cmd/rest-api/database/databasetest"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestTransferInquiryRateLimit(t *testing.T) {
+	db := databasetest.Open(t)
+	h := &AppHandler{DB: db, InquiryLimit: InquiryRateLimit{MaxLookups: 2, Window: time.Hour}}
+
+	sender, _ := createTestUser(t, db, money.FromMajor(100))
+	recipient, _ := createTestUser(t, db, money.FromMajor(100))
+	t.Cleanup(func() {
+		db.Unscoped().Where("user_id = ?", sender.ID).Delete(&models.TransferInquiryLookup{})
+		db.Unscoped().Where("user_id = ?", sender.ID).Delete(&models.TransferInquiry{})
+	})
+
+	// Unknown numbers use up lookups too
+	for i := 0; i < 2; i++ {
+		if code := callAsUser(h.TransferInquiry, sender.UID, TransferInquiryRequest{PhoneNumber: uuid.New().String()}); code != http.StatusNotFound {
+			t.Fatalf("lookup %d: got status %d, want %d", i+1, code, http.StatusNotFound)
+		}
+	}
+	if code := callAsUser(h.TransferInquiry, sender.UID, TransferInquiryRequest{PhoneNumber: recipient.PhoneNumber}); code != http.StatusTooManyRequests {
+		t.Errorf("lookup over the limit: got status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// Lookups that left the window no longer count
+	db.Model(&models.TransferInquiryLookup{}).Where("user_id = ?", sender.ID).Update("created_at", time.Now().Add(-2*time.Hour))
+	if code := callAsUser(h.TransferInquiry, sender.UID, TransferInquiryRequest{PhoneNumber: recipient.PhoneNumber}); code != http.StatusOK {
+		t.Errorf("lookup after the window: got status %d, want %d", code, http.StatusOK)
+	}
+}
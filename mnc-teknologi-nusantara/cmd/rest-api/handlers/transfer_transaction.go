@@ -4,51 +4,69 @@ import (
 	"encoding/json"
 	"errors"
 	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"mnctech-restapi/cmd/rest-api/middlewares"
 	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/responses"
 	"net/http"
 	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	"gorm.io/gorm"
 )
 
 type TransferRequest struct {
-	TargetUser string  `json:"target_user"`
-	Amount     float64 `json:"amount"`
-	Remarks    string  `json:"remarks"`
+	InquiryToken string      `json:"inquiry_token" validate:"required"` // From /transfer/inquiry, names the recipient
+	Amount       money.Money `json:"amount"`
+	Remarks      string      `json:"remarks" validate:"max=255"`
+	AccountID    string      `json:"account_id" validate:"omitempty,uuid"` // Account to send from, the default account when omitted
+	Currency     string      `json:"currency" validate:"omitempty,len=3"`  // Must be the currency of the sending account when given
 }
 
 type TransferResult struct {
-	TransferID    string    `json:"transfer_id"`
-	Amount        float64   `json:"amount"`
-	Remarks       string    `json:"remarks"`
-	BalanceBefore float64   `json:"balance_before"`
-	BalanceAfter  float64   `json:"balance_after"`
-	CreatedDate   time.Time `json:"created_date"`
+	TransferID     string      `json:"transfer_id"`
+	AccountID      string      `json:"account_id"` // Account that was debited
+	Status         string      `json:"status"`     // PENDING until the transfer worker credits the target
+	Amount         money.Money `json:"amount"`     // Debited from the account
+	Currency       string      `json:"currency"`
+	TargetAmount   money.Money `json:"target_amount"` // Credited to the recipient
+	TargetCurrency string      `json:"target_currency"`
+	FxRate         *string     `json:"fx_rate,omitempty"`   // Rate applied when the currencies differ
+	FxSpread       *string     `json:"fx_spread,omitempty"` // Spread included in the rate
+	Remarks        string      `json:"remarks"`
+	BalanceBefore  money.Money `json:"balance_before"`
+	BalanceAfter   money.Money `json:"balance_after"`
+	CreatedDate    time.Time   `json:"created_date"`
 }
 
 func (h *AppHandler) HandleTransfer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 	userID, ok := r.Context().Value(auth.UserIDKey).(string)
 
 	if !ok {
 		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(NewFailedResponse("User ID not found in context"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
 		return
 	}
 
 	// Parse request body
 	var req TransferRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if fieldErrors := amountDecodeError(err); fieldErrors != nil {
+			writeValidationErrors(w, fieldErrors)
+			return
+		}
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(NewFailedResponse("Invalid request payload"))
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
 		return
 	}
 
 	// Validate input
-	validate := validator.New()
-	if err := validate.Struct(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if fieldErrors := append(validateRequest(req), h.AmountPolicy.Check("amount", req.Amount)...); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
 		return
 	}
 
@@ -56,6 +74,7 @@ func (h *AppHandler) HandleTransfer(w http.ResponseWriter, r *http.Request) {
 
 	transferResult := TransferResult{
 		TransferID: transferTrxID,
+		Status:     transferStatusPending,
 		Amount:     req.Amount,
 		Remarks:    req.Remarks,
 	}
@@ -71,128 +90,137 @@ func (h *AppHandler) HandleTransfer(w http.ResponseWriter, r *http.Request) {
 
 	// Start a new transaction
 	err := h.DB.Transaction(func(tx *gorm.DB) error {
-		var user models.User
-		if err := tx.Where("uid = ?", userID).First(&user).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return errors.New("user not found") // Return an error if the user does not exist
-			}
-			return err // Return the error to rollback the transaction for other errors
+		user, err := findUserByUID(tx, userID)
+		if err != nil {
+			return err
 		}
 
-		if err := tx.Where("user_id = ?", user.ID).First(&userAccount).Error; err != nil {
+		senderAccountID, err := resolveAccount(tx, user.ID, req.AccountID)
+		if err != nil {
 			return err
 		}
 
-		var targetUser models.User
-		if err := tx.Where("uid = ?", req.TargetUser).First(&targetUser).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return errors.New("target user not found") // Return an error if the user does not exist
-			}
-			return err // Return the error to rollback the transaction for other errors
+		// The inquiry names the recipient the sender confirmed
+		inquiry, err := consumeTransferInquiry(tx, user.ID, req.InquiryToken)
+		if err != nil {
+			return err
 		}
 
-		if userAccount.CurrentBalance < req.Amount {
-			return errors.New("insufficient balance")
+		// Transfers arrive in the target's default account, created on the first incoming transfer
+		if inquiry.TargetUserID == user.ID {
+			return errSelfTransfer
 		}
-
-		// Deduct user balances
-		userAccount.LastBalance = userAccount.CurrentBalance // Save the previous balance
-		userAccount.CurrentBalance -= req.Amount             // Update curr
-
-		// Save the updated account
-		if err := tx.Save(&userAccount).Error; err != nil {
-			return err // Return the error to rollback the transaction
+		targetAccountID, err := ensureAccount(tx, inquiry.TargetUserID)
+		if err != nil {
+			return err
 		}
 
-		// Fetch the user's account
-		var updatedUserAccount models.UserAccount
-		if err := tx.Where("user_id = ?", user.ID).First(&updatedUserAccount).Error; err != nil {
+		// Lock the sender so concurrent transfers cannot both pass the balance check
+		accounts, err := lockAccounts(tx, senderAccountID)
+		if err != nil {
 			return err
 		}
+		userAccount = *accounts[senderAccountID]
+		if req.Currency != "" && req.Currency != userAccount.Currency {
+			return errCurrencyMismatch
+		}
 
-		transactionLog := baseTransactionLog
-		transactionLog.UserAccountID = userAccount.ID
-		transactionLog.TransactionType = "DEBIT"
-		transactionLog.BalanceBefore = updatedUserAccount.LastBalance
-		transactionLog.BalanceAfter = updatedUserAccount.CurrentBalance
+		if availableBalance(&userAccount).LessThan(req.Amount) {
+			return errors.New("insufficient balance")
+		}
 
-		if err := tx.Create(&transactionLog).Error; err != nil {
-			return err // Return the error to rollback the transaction
+		// The recipient's maximum balance is checked by the transfer worker
+		if err := h.checkLimits(r.Context(), tx, user, req.Amount, userAccount.Currency, false); err != nil {
+			return err
 		}
 
+		// The recipient receives the amount in the currency of their account, converted at today's rate
 		var targetAccount models.UserAccount
-		if err := tx.Where("user_id = ?", targetUser.ID).First(&targetAccount).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				// If not found, create a new UserAccount
-				targetAccount = models.UserAccount{
-					UserID:         targetUser.ID, // Use the user's ID from the User model
-					CurrentBalance: 0,             // Set initial balance or any other default values
-					LastBalance:    0,
-				}
-
-				if err := tx.Create(&targetAccount).Error; err != nil {
-					return err // Return the error to rollback the transaction
-				}
-			} else {
-				return err // Return the error to rollback the transaction for other errors
-			}
+		if err := tx.First(&targetAccount, targetAccountID).Error; err != nil {
+			return err
+		}
+		converted, err := h.convert(r.Context(), req.Amount, userAccount.Currency, targetAccount.Currency)
+		if err != nil {
+			return err
 		}
 
-		// Update tager balances
-		targetAccount.LastBalance = targetAccount.CurrentBalance // Save the previous balance
-		targetAccount.CurrentBalance += req.Amount               // Update curr
+		// Deduct user balances
+		userAccount.LastBalance = userAccount.CurrentBalance                    // Save the previous balance
+		userAccount.CurrentBalance = userAccount.CurrentBalance.Sub(req.Amount) // Update curr
 
 		// Save the updated account
-		if err := tx.Save(&targetAccount).Error; err != nil {
+		if err := tx.Save(&userAccount).Error; err != nil {
 			return err // Return the error to rollback the transaction
 		}
 
-		// Fetch the user's account
-		var updatedTargetAccount models.UserAccount
-		if err := tx.Where("user_id = ?", targetAccount.ID).First(&updatedTargetAccount).Error; err != nil {
-			return err
-		}
-
-		transactionLogTarget := baseTransactionLog
-		transactionLogTarget.UserAccountID = updatedTargetAccount.ID
-		transactionLogTarget.TransactionType = "CREDIT"
-		transactionLogTarget.BalanceBefore = updatedTargetAccount.LastBalance
-		transactionLogTarget.BalanceAfter = updatedTargetAccount.CurrentBalance
+		transactionLog := baseTransactionLog
+		transactionLog.UserAccountID = userAccount.ID
+		transactionLog.Currency = userAccount.Currency
+		transactionLog.TransactionType = "DEBIT"
+		transactionLog.BalanceBefore = userAccount.LastBalance
+		transactionLog.BalanceAfter = userAccount.CurrentBalance
 
-		if err := tx.Create(&transactionLogTarget).Error; err != nil {
+		if err := tx.Create(&transactionLog).Error; err != nil {
 			return err // Return the error to rollback the transaction
 		}
 
+		// The target is credited by the transfer worker
 		transferTransactionPayoad := models.TransferTransaction{
-			UID:           transferTrxID,
-			UserAccountID: userAccount.ID, // Reference to the user's account
-			TargetUserID:  targetAccount.ID,
-			Remarks:       req.Remarks,
-			Amount:        req.Amount, // The amount topped up
-			Status:        "SUCCESS",  // Status of the transaction
+			UID:            transferTrxID,
+			UserAccountID:  userAccount.ID, // Reference to the user's account
+			TargetUserID:   targetAccountID,
+			Remarks:        req.Remarks,
+			Amount:         req.Amount, // The amount topped up
+			Currency:       userAccount.Currency,
+			TargetAmount:   converted.Amount,
+			TargetCurrency: targetAccount.Currency,
+			FxRate:         converted.Rate,
+			FxSpread:       converted.Spread,
+			Status:         transferStatusPending, // Status of the transaction
 		}
 
 		if err := tx.Create(&transferTransactionPayoad).Error; err != nil {
 			return err // Return the error to rollback the transaction
 		}
 
+		// Money waits in the clearing account until the target is credited
+		if err := postJournal(tx, transferTrxID, "TRANSFER", req.Remarks,
+			ledger.Transfer(ledger.UserAccountCode(userAccount.ID), ledger.SystemAccount(ledger.AccountTransferClearing, userAccount.Currency), req.Amount),
+			&userAccount); err != nil {
+			return err
+		}
+
 		var transferTransaction models.TransferTransaction
 		if err := tx.Where("uid = ?", transferTrxID).First(&transferTransaction).Error; err != nil {
 			return err
 		}
 
-		transferResult.BalanceBefore = updatedUserAccount.LastBalance
-		transferResult.BalanceAfter = updatedUserAccount.CurrentBalance
+		// Hand the transfer to the worker, the outbox relay publishes it once this commits
+		if err := enqueueTransfer(tx, transferTrxID); err != nil {
+			return err
+		}
+
+		transferResult.AccountID = userAccount.UID
+		transferResult.Currency = userAccount.Currency
+		transferResult.TargetAmount = converted.Amount
+		transferResult.TargetCurrency = targetAccount.Currency
+		transferResult.FxRate = converted.Rate
+		transferResult.FxSpread = converted.Spread
+		transferResult.BalanceBefore = transactionLog.BalanceBefore
+		transferResult.BalanceAfter = transactionLog.BalanceAfter
 		transferResult.CreatedDate = transferTransaction.CreatedAt
 
-		return nil // Commit the transaction if everything is successful
+		// A retry with the Idempotency-Key gets this response once it commits
+		return middlewares.CompleteIdempotencyKey(r.Context(), tx, http.StatusAccepted, responses.NewSuccessResponse(transferResult))
 	})
 
 	if err != nil {
+		var limitErr *LimitExceededError
 		if err.Error() == "insufficient balance" {
 			errMessage := "Balance is not enough"
 			transactionLog := baseTransactionLog
 			transactionLog.UserAccountID = userAccount.ID
+			transactionLog.Currency = userAccount.Currency
 			transactionLog.TransactionType = "DEBIT"
 			transactionLog.BalanceBefore = userAccount.LastBalance
 			transactionLog.BalanceAfter = userAccount.CurrentBalance
@@ -201,22 +229,110 @@ func (h *AppHandler) HandleTransfer(w http.ResponseWriter, r *http.Request) {
 
 			if err := h.DB.Create(&transactionLog).Error; err != nil {
 				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(NewFailedResponse(errMessage))
+				json.NewEncoder(w).Encode(responses.NewFailedResponse(errMessage))
 			}
 
 			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(NewFailedResponse(errMessage))
+			json.NewEncoder(w).Encode(responses.NewFailedResponse(errMessage))
 		} else if err.Error() == "user not found" {
 			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(NewFailedResponse("User not found"))
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		} else if errors.Is(err, errInvalidTransferInquiry) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("INVALID_INQUIRY_TOKEN", "Inquiry token is invalid, expired or already used"))
+		} else if errors.Is(err, errSelfTransfer) {
+			writeSelfTransfer(w)
+		} else if errors.As(err, &limitErr) {
+			writeLimitExceeded(w, limitErr)
+		} else if errors.Is(err, errAccountNotFound) {
+			writeAccountNotFound(w)
+		} else if errors.Is(err, errCurrencyMismatch) {
+			writeCurrencyMismatch(w)
+		} else if errors.Is(err, errFxAmountTooSmall) {
+			writeFxAmountTooSmall(w)
+		} else if errors.Is(err, currency.ErrNoRate) {
+			writeFxUnavailable(w)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(NewFailedResponse("Transaction failed"))
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Transaction failed"))
 		}
 		return
 	}
 
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(transferResult))
+}
+
+// TransferStatusResponse represents the state of a transfer.
+type TransferStatusResponse struct {
+	TransferID     string      `json:"transfer_id"`
+	Status         string      `json:"status"` // PENDING, SUCCESS or FAILED
+	Amount         money.Money `json:"amount"` // Debited from the sender
+	Currency       string      `json:"currency"`
+	TargetAmount   money.Money `json:"target_amount"` // Credited to the recipient
+	TargetCurrency string      `json:"target_currency"`
+	FxRate         *string     `json:"fx_rate,omitempty"`   // Rate applied when the currencies differ
+	FxSpread       *string     `json:"fx_spread,omitempty"` // Spread included in the rate
+	Remarks        string      `json:"remarks"`
+	ErrMessage     string      `json:"err_message,omitempty"` // Why a FAILED transfer was refunded
+	CreatedDate    time.Time   `json:"created_date"`
+	UpdatedDate    time.Time   `json:"updated_date"`
+}
+
+// GetTransferStatus returns the status of a transfer sent or received by the user.
+func (h *AppHandler) GetTransferStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	user, err := findUserByUID(h.DB, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+		return
+	}
+
+	transferID := mux.Vars(r)["transfer_id"]
+	if _, err := uuid.Parse(transferID); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Transfer not found"))
+		return
+	}
+
+	var transfer models.TransferTransaction
+	err = h.DB.
+		Where("uid = ?", transferID).
+		Where("user_account_id IN (?) OR target_user_id IN (?)", accountIDsOfUser(h.DB, user.ID), accountIDsOfUser(h.DB, user.ID)).
+		First(&transfer).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Transfer not found"))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve transfer"))
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(NewSuccessResponse(transferResult))
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(TransferStatusResponse{
+		TransferID:     transfer.UID,
+		Status:         transfer.Status,
+		Amount:         transfer.Amount,
+		Currency:       transfer.Currency,
+		TargetAmount:   transfer.TargetAmount,
+		TargetCurrency: transfer.TargetCurrency,
+		FxRate:         transfer.FxRate,
+		FxSpread:       transfer.FxSpread,
+		Remarks:        transfer.Remarks,
+		ErrMessage:     transfer.ErrMessage,
+		CreatedDate:    transfer.CreatedAt,
+		UpdatedDate:    transfer.UpdatedAt,
+	}))
 }
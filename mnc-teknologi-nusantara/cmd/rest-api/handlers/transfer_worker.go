@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mnctech-restapi/cmd/rest-api/broker"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/events"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/outbox"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TransferQueue is the queue transfers wait in until the transfer worker credits the target.
+const TransferQueue = "transfers"
+
+const (
+	transferStatusPending = "PENDING"
+	transferStatusSuccess = "SUCCESS"
+	transferStatusFailed  = "FAILED"
+)
+
+// TransferMessage is the body of a message on the TransferQueue.
+type TransferMessage struct {
+	TransferID string `json:"transfer_id"`
+}
+
+// enqueueTransfer queues the transfer for the transfer worker through the outbox,
+// within the transaction that debits the sender.
+func enqueueTransfer(tx *gorm.DB, transferID string) error {
+	body, err := json.Marshal(TransferMessage{TransferID: transferID})
+	if err != nil {
+		return err
+	}
+	return outbox.EnqueueMessage(tx, TransferQueue, transferID, body)
+}
+
+// ProcessTransfer credits the target of a PENDING transfer and marks it SUCCESS.
+// When the target can no longer be credited the sender is refunded and the
+// transfer marked FAILED. Transfers that are no longer PENDING are skipped, so a
+// message delivered twice is harmless. Other errors are returned for a retry.
+func (h *AppHandler) ProcessTransfer(ctx context.Context, msg broker.Message) error {
+	var payload TransferMessage
+	if err := json.Unmarshal(msg.Body, &payload); err != nil {
+		return fmt.Errorf("decode transfer message: %w", err)
+	}
+
+	return h.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var transfer models.TransferTransaction
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("uid = ?", payload.TransferID).First(&transfer).Error; err != nil {
+			return err // The request may not have committed yet, retry later
+		}
+		if transfer.Status != transferStatusPending {
+			return nil // Already processed, the message was delivered again
+		}
+
+		// Lock both accounts in a fixed order so opposite transfers cannot deadlock
+		accounts, err := lockAccounts(tx, transfer.UserAccountID, transfer.TargetUserID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return failTransfer(tx, &transfer, "Target account not found")
+		}
+		if err != nil {
+			return err
+		}
+		targetAccount := accounts[transfer.TargetUserID]
+
+		if _, err := targetAccount.CurrentBalance.CheckedAdd(transfer.TargetAmount); err != nil {
+			return failTransfer(tx, &transfer, "Target balance out of range")
+		}
+
+		// Incoming transfers may not take the target above the maximum balance of its KYC tier
+		var target models.User
+		if err := tx.Where("id = ?", targetAccount.UserID).First(&target).Error; err != nil {
+			return err
+		}
+		var limitErr *LimitExceededError
+		if err := h.checkMaxBalance(ctx, tx, target, transfer.TargetAmount, transfer.TargetCurrency); errors.As(err, &limitErr) {
+			return failTransfer(tx, &transfer, "Target balance limit reached")
+		} else if errors.Is(err, currency.ErrNoRate) {
+			return failTransfer(tx, &transfer, "Exchange rate not available")
+		} else if err != nil {
+			return err
+		}
+
+		if err := creditAccount(tx, targetAccount, transfer.TargetAmount, "TRANSFER", transfer.UID, transfer.Remarks); err != nil {
+			return err
+		}
+
+		// Money leaves the clearing account to the target, through the FX position when it changes currency
+		clearing := ledger.SystemAccount(ledger.AccountTransferClearing, transfer.Currency)
+		lines := ledger.Transfer(clearing, ledger.UserAccountCode(targetAccount.ID), transfer.Amount)
+		if transfer.TargetCurrency != transfer.Currency {
+			lines = append(
+				ledger.Transfer(clearing, ledger.SystemAccount(ledger.AccountFxPosition, transfer.Currency), transfer.Amount),
+				ledger.Transfer(ledger.SystemAccount(ledger.AccountFxPosition, transfer.TargetCurrency), ledger.UserAccountCode(targetAccount.ID), transfer.TargetAmount)...)
+		}
+		if err := postJournal(tx, transfer.UID, "TRANSFER", transfer.Remarks, lines, targetAccount); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&transfer).Update("status", transferStatusSuccess).Error; err != nil {
+			return err
+		}
+
+		senderUserID, err := userUIDOfAccount(tx, transfer.UserAccountID)
+		if err != nil {
+			return err
+		}
+		targetUserID, err := userUIDOfAccount(tx, transfer.TargetUserID)
+		if err != nil {
+			return err
+		}
+		return outbox.EnqueueEvent(tx, events.TypeTransferCompleted, events.TransferCompleted{
+			TransferID:     transfer.UID,
+			SenderUserID:   senderUserID,
+			TargetUserID:   targetUserID,
+			Amount:         transfer.Amount,
+			Currency:       transfer.Currency,
+			TargetAmount:   transfer.TargetAmount,
+			TargetCurrency: transfer.TargetCurrency,
+			Remarks:        transfer.Remarks,
+		})
+	})
+}
+
+// FailDeadTransfer consumes the dead-letter queue of the TransferQueue. The
+// transfer of a message that failed every attempt is still PENDING, so its
+// sender is refunded and it is marked FAILED.
+func (h *AppHandler) FailDeadTransfer(ctx context.Context, msg broker.Message) error {
+	var payload TransferMessage
+	if err := json.Unmarshal(msg.Body, &payload); err != nil {
+		return fmt.Errorf("decode transfer message: %w", err)
+	}
+
+	err := h.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var transfer models.TransferTransaction
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("uid = ?", payload.TransferID).First(&transfer).Error; err != nil {
+			return err
+		}
+		if transfer.Status != transferStatusPending {
+			return nil // Processed by a late attempt or an earlier delivery
+		}
+		return failTransfer(tx, &transfer, "Transfer could not be processed")
+	})
+	if err != nil {
+		// Retried until it succeeds, but the sender waits for their money meanwhile
+		log.Printf("Failed to refund dead transfer %s on attempt %d: %v", payload.TransferID, msg.Attempt, err)
+	}
+	return err
+}
+
+// deadTransferMaxRetryDelay is the longest wait between attempts to refund a dead transfer.
+const deadTransferMaxRetryDelay = 10 * time.Minute
+
+// DeadTransferRetryPolicy returns the retry policy of the consumer of the
+// dead-letter queue of the TransferQueue. The sender of a dead transfer has been
+// debited, so the refund is retried forever, waiting longer after every attempt.
+func DeadTransferRetryPolicy(policy broker.RetryPolicy) broker.RetryPolicy {
+	return broker.RetryPolicy{Delay: policy.Delay, MaxDelay: deadTransferMaxRetryDelay}
+}
+
+// failTransfer refunds the sender of a PENDING transfer and marks it FAILED.
+func failTransfer(tx *gorm.DB, transfer *models.TransferTransaction, reason string) error {
+	accounts, err := lockAccounts(tx, transfer.UserAccountID)
+	if err != nil {
+		return err
+	}
+	senderAccount := accounts[transfer.UserAccountID]
+
+	// The reason is stored on the transfer, not on the log row
+	if err := creditAccount(tx, senderAccount, transfer.Amount, "TRANSFER_REVERSAL", transfer.UID, transfer.Remarks); err != nil {
+		return err
+	}
+
+	// Money returns from the clearing account to the sender
+	if err := postJournal(tx, transfer.UID, "TRANSFER_REVERSAL", reason,
+		ledger.Transfer(ledger.SystemAccount(ledger.AccountTransferClearing, transfer.Currency), ledger.UserAccountCode(senderAccount.ID), transfer.Amount),
+		senderAccount); err != nil {
+		return err
+	}
+
+	return tx.Model(transfer).Updates(map[string]interface{}{
+		"status":      transferStatusFailed,
+		"err_message": reason,
+	}).Error
+}
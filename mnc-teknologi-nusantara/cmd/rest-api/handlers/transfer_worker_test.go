@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"mnctech-restapi/cmd/rest-api/broker"
+	"mnctech-restapi/cmd/rest-api/database/databasetest"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"net/http"
+	"testing"
+)
+
+func TestFailDeadTransferRefundsSender(t *testing.T) {
+	db := databasetest.Open(t)
+	h := &AppHandler{DB: db, AmountPolicy: DefaultAmountPolicy()}
+
+	sender, senderAccount := createTestUser(t, db, money.FromMajor(100))
+	target, _ := createTestUser(t, db, 0)
+
+	token := createTestInquiry(t, db, sender, target)
+	if code := callAsUser(h.HandleTransfer, sender.UID, TransferRequest{InquiryToken: token, Amount: money.FromMajor(30)}); code != http.StatusAccepted {
+		t.Fatalf("transfer: got status %d", code)
+	}
+	var transfer models.TransferTransaction
+	db.Where("user_account_id = ?", senderAccount.ID).Order("id DESC").First(&transfer)
+
+	body, _ := json.Marshal(TransferMessage{TransferID: transfer.UID})
+	msg := broker.Message{ID: transfer.UID, Body: body, Attempt: 5}
+	for i := 0; i < 2; i++ { // A second delivery changes nothing
+		if err := h.FailDeadTransfer(context.Background(), msg); err != nil {
+			t.Fatalf("fail dead transfer: %v", err)
+		}
+	}
+
+	db.First(&transfer, transfer.ID)
+	if transfer.Status != transferStatusFailed {
+		t.Errorf("got status %s, want %s", transfer.Status, transferStatusFailed)
+	}
+	var reloaded models.UserAccount
+	db.First(&reloaded, senderAccount.ID)
+	if reloaded.CurrentBalance != money.FromMajor(100) {
+		t.Errorf("got sender balance %s, want 100.00", reloaded.CurrentBalance)
+	}
+}
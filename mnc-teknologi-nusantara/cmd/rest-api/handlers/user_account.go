@@ -0,0 +1,459 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"mnctech-restapi/cmd/rest-api/middlewares"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultAccountName = "Main" // Name of the account created on first use
+	maxAccountsPerUser = 10
+)
+
+var (
+	errAccountNotFound     = errors.New("account not found")
+	errAccountNameTaken    = errors.New("account name is already used")
+	errAccountLimitReached = errors.New("too many accounts")
+	errAccountNotEmpty     = errors.New("account balance is not zero")
+	errAccountIsDefault    = errors.New("default account cannot be closed")
+	errAccountBusy         = errors.New("account has pending transfers")
+	errAccountHasHolds     = errors.New("account has active holds")
+	errSameAccount         = errors.New("source and destination are the same account")
+)
+
+// OpenAccountRequest represents the expected payload of POST /accounts.
+type OpenAccountRequest struct {
+	Name     string `json:"name" validate:"required,max=50"`
+	Currency string `json:"currency"` // ISO 4217 code of the balance, IDR when omitted
+}
+
+// MoveRequest represents the expected payload of POST /accounts/move.
+type MoveRequest struct {
+	SourceAccountID      string      `json:"source_account_id" validate:"required,uuid"`
+	DestinationAccountID string      `json:"destination_account_id" validate:"required,uuid"`
+	Amount               money.Money `json:"amount"`
+	Remarks              string      `json:"remarks" validate:"max=255"`
+}
+
+// AccountResponse represents an account of the caller.
+type AccountResponse struct {
+	AccountID        string      `json:"account_id"`
+	Name             string      `json:"name"`
+	IsDefault        bool        `json:"is_default"`
+	Currency         string      `json:"currency"`
+	Balance          money.Money `json:"balance"`
+	HeldBalance      money.Money `json:"held_balance"`      // Reserved by active holds
+	AvailableBalance money.Money `json:"available_balance"` // Balance less the held balance, what can be spent
+	CreatedDate      time.Time   `json:"created_date"`
+}
+
+// MoveResult represents the structure of the move response result.
+type MoveResult struct {
+	MoveID                  string      `json:"move_id"`
+	Amount                  money.Money `json:"amount"`
+	Currency                string      `json:"currency"`
+	Remarks                 string      `json:"remarks"`
+	SourceBalanceAfter      money.Money `json:"source_balance_after"`
+	DestinationBalanceAfter money.Money `json:"destination_balance_after"`
+	CreatedDate             time.Time   `json:"created_date"`
+}
+
+func newAccountResponse(userAccount models.UserAccount) AccountResponse {
+	return AccountResponse{
+		AccountID:        userAccount.UID,
+		Name:             userAccount.Name,
+		IsDefault:        userAccount.IsDefault,
+		Currency:         userAccount.Currency,
+		Balance:          userAccount.CurrentBalance,
+		HeldBalance:      userAccount.HeldBalance,
+		AvailableBalance: availableBalance(&userAccount),
+		CreatedDate:      userAccount.CreatedAt,
+	}
+}
+
+// writeAccountError responds with the failure of an account request.
+func writeAccountError(w http.ResponseWriter, err error) {
+	switch {
+	case err.Error() == "user not found":
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User not found"))
+	case errors.Is(err, errAccountNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("ACCOUNT_NOT_FOUND", "Account not found"))
+	case errors.Is(err, errAccountNameTaken):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("ACCOUNT_NAME_TAKEN", "You already have an account with this name"))
+	case errors.Is(err, errAccountLimitReached):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("ACCOUNT_LIMIT_REACHED", "You cannot open more accounts"))
+	case errors.Is(err, errAccountNotEmpty):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("ACCOUNT_NOT_EMPTY", "Only accounts with a zero balance can be closed"))
+	case errors.Is(err, errAccountIsDefault):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("DEFAULT_ACCOUNT", "Choose another default account before closing this one"))
+	case errors.Is(err, errAccountBusy):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("ACCOUNT_BUSY", "Account has transfers in progress"))
+	case errors.Is(err, errAccountHasHolds):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("ACCOUNT_HAS_HOLDS", "Account has funds held for merchants"))
+	case errors.Is(err, errSameAccount):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("SAME_ACCOUNT", "Source and destination must be different accounts"))
+	case errors.Is(err, errCurrencyMismatch):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("CURRENCY_MISMATCH", "Accounts hold different currencies, convert between them with an FX quote"))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Account request failed"))
+	}
+}
+
+// writeAccountNotFound responds to a request naming an account the caller does not own.
+func writeAccountNotFound(w http.ResponseWriter) {
+	writeAccountError(w, errAccountNotFound)
+}
+
+// GetAccounts lists the open accounts of the caller, the default account first.
+func (h *AppHandler) GetAccounts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	user, err := findUserByUID(h.DB, userID)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	var userAccounts []models.UserAccount
+	if err := h.DB.Where("user_id = ?", user.ID).Order("is_default DESC, id ASC").Find(&userAccounts).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Failed to retrieve accounts"))
+		return
+	}
+
+	accounts := make([]AccountResponse, 0, len(userAccounts))
+	for _, userAccount := range userAccounts {
+		accounts = append(accounts, newAccountResponse(userAccount))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(accounts))
+}
+
+// OpenAccount opens a named account for the caller. The first account of a user
+// becomes their default.
+func (h *AppHandler) OpenAccount(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var req OpenAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Currency == "" {
+		req.Currency = currency.Base
+	}
+	if fieldErrors := append(validateRequest(req), checkCurrency("currency", req.Currency)...); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	var userAccount models.UserAccount
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := findUserByUID(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		// Lock the user so concurrent requests see each other's accounts
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, user.ID).Error; err != nil {
+			return err
+		}
+
+		var userAccounts []models.UserAccount
+		if err := tx.Where("user_id = ?", user.ID).Find(&userAccounts).Error; err != nil {
+			return err
+		}
+		if len(userAccounts) >= maxAccountsPerUser {
+			return errAccountLimitReached
+		}
+		for _, existing := range userAccounts {
+			if strings.EqualFold(existing.Name, req.Name) {
+				return errAccountNameTaken
+			}
+		}
+
+		userAccount = models.UserAccount{
+			UID:       uuid.New().String(),
+			UserID:    user.ID,
+			Name:      req.Name,
+			IsDefault: len(userAccounts) == 0,
+			Currency:  req.Currency,
+		}
+		return tx.Create(&userAccount).Error
+	})
+
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(newAccountResponse(userAccount)))
+}
+
+// SetDefaultAccount makes an account of the caller their default account.
+func (h *AppHandler) SetDefaultAccount(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	accountUID := mux.Vars(r)["account_id"]
+	if _, err := uuid.Parse(accountUID); err != nil {
+		writeAccountNotFound(w)
+		return
+	}
+
+	var userAccount models.UserAccount
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := findUserByUID(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		accountID, err := resolveAccount(tx, user.ID, accountUID)
+		if err != nil {
+			return err
+		}
+
+		// Lock the user's accounts in ID order so concurrent requests cannot leave two defaults
+		var userAccounts []models.UserAccount
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", user.ID).Order("id ASC").Find(&userAccounts).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.UserAccount{}).Where("user_id = ? AND is_default", user.ID).Update("is_default", false).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.UserAccount{}).Where("id = ?", accountID).Update("is_default", true).Error; err != nil {
+			return err
+		}
+		return tx.First(&userAccount, accountID).Error
+	})
+
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(newAccountResponse(userAccount)))
+}
+
+// CloseAccount closes an account of the caller. Only an empty account that is not
+// the default and has no transfers in progress can be closed.
+func (h *AppHandler) CloseAccount(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	accountUID := mux.Vars(r)["account_id"]
+	if _, err := uuid.Parse(accountUID); err != nil {
+		writeAccountNotFound(w)
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := findUserByUID(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		accountID, err := resolveAccount(tx, user.ID, accountUID)
+		if err != nil {
+			return err
+		}
+
+		// Lock the account so no balance change slips in before it is closed
+		accounts, err := lockAccounts(tx, accountID)
+		if err != nil {
+			return err
+		}
+		userAccount := accounts[accountID]
+
+		if userAccount.IsDefault {
+			return errAccountIsDefault
+		}
+		// Held funds are captured or released by the merchant, or expire
+		if userAccount.HeldBalance.IsPositive() {
+			return errAccountHasHolds
+		}
+		if !userAccount.CurrentBalance.IsZero() {
+			return errAccountNotEmpty
+		}
+
+		// A failed transfer is refunded to the account it was sent from
+		var pending int64
+		if err := tx.Model(&models.TransferTransaction{}).Where("user_account_id = ? AND status = ?", accountID, transferStatusPending).Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending > 0 {
+			return errAccountBusy
+		}
+
+		return tx.Delete(userAccount).Error
+	})
+
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(nil))
+}
+
+// HandleMove moves money between two accounts of the caller in the same currency.
+// Moves do not count towards the limits of the user's KYC tier.
+func (h *AppHandler) HandleMove(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("User ID not found in context"))
+		return
+	}
+
+	var req MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if fieldErrors := amountDecodeError(err); fieldErrors != nil {
+			writeValidationErrors(w, fieldErrors)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responses.NewFailedResponse("Invalid request payload"))
+		return
+	}
+
+	// Validate input
+	if fieldErrors := append(validateRequest(req), h.AmountPolicy.Check("amount", req.Amount)...); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+	if req.SourceAccountID == req.DestinationAccountID {
+		writeAccountError(w, errSameAccount)
+		return
+	}
+
+	moveID := uuid.New().String()
+	result := MoveResult{
+		MoveID:  moveID,
+		Amount:  req.Amount,
+		Remarks: req.Remarks,
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := findUserByUID(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		sourceID, err := resolveAccount(tx, user.ID, req.SourceAccountID)
+		if err != nil {
+			return err
+		}
+		destinationID, err := resolveAccount(tx, user.ID, req.DestinationAccountID)
+		if err != nil {
+			return err
+		}
+
+		// Lock both accounts in a fixed order so opposite moves cannot deadlock
+		accounts, err := lockAccounts(tx, sourceID, destinationID)
+		if err != nil {
+			return err
+		}
+		sourceAccount := accounts[sourceID]
+		destinationAccount := accounts[destinationID]
+		if sourceAccount.Currency != destinationAccount.Currency {
+			return errCurrencyMismatch
+		}
+
+		if availableBalance(sourceAccount).LessThan(req.Amount) {
+			return errors.New("insufficient balance")
+		}
+
+		if err := debitAccount(tx, sourceAccount, req.Amount, "ACCOUNT_MOVE", moveID, req.Remarks); err != nil {
+			return err
+		}
+		if err := creditAccount(tx, destinationAccount, req.Amount, "ACCOUNT_MOVE", moveID, req.Remarks); err != nil {
+			return err
+		}
+
+		if err := postJournal(tx, moveID, "ACCOUNT_MOVE", req.Remarks,
+			ledger.Transfer(ledger.UserAccountCode(sourceAccount.ID), ledger.UserAccountCode(destinationAccount.ID), req.Amount),
+			sourceAccount, destinationAccount); err != nil {
+			return err
+		}
+
+		result.Currency = sourceAccount.Currency
+		result.SourceBalanceAfter = sourceAccount.CurrentBalance
+		result.DestinationBalanceAfter = destinationAccount.CurrentBalance
+		result.CreatedDate = time.Now()
+		return middlewares.CompleteIdempotencyKey(r.Context(), tx, http.StatusOK, responses.NewSuccessResponse(result))
+	})
+
+	if err != nil {
+		if err.Error() == "insufficient balance" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(responses.NewFailedResponse("Balance is not enough"))
+			return
+		}
+		writeAccountError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses.NewSuccessResponse(result))
+}
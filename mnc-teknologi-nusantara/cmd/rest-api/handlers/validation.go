@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// AmountPolicy bounds the amounts accepted by the money endpoints.
+type AmountPolicy struct {
+	MaxAmount        money.Money // Largest amount of a single transaction
+	MaxDecimalPlaces int         // At most money.Scale
+}
+
+// DefaultAmountPolicy returns the amount policy used when none is configured.
+func DefaultAmountPolicy() AmountPolicy {
+	return AmountPolicy{
+		MaxAmount:        money.FromMajor(100_000_000),
+		MaxDecimalPlaces: money.Scale,
+	}
+}
+
+// Check returns the problems of an amount entered in the given field.
+func (p AmountPolicy) Check(field string, amount money.Money) []responses.FieldError {
+	var errs []responses.FieldError
+	if !amount.IsPositive() {
+		errs = append(errs, responses.FieldError{Field: field, Code: "MUST_BE_POSITIVE", Message: "must be greater than 0"})
+	}
+	if amount.GreaterThan(p.MaxAmount) {
+		errs = append(errs, responses.FieldError{Field: field, Code: "EXCEEDS_MAXIMUM", Message: fmt.Sprintf("must not exceed %s", p.MaxAmount)})
+	}
+	if amount.DecimalPlaces() > p.MaxDecimalPlaces {
+		errs = append(errs, responses.FieldError{Field: field, Code: "TOO_MANY_DECIMALS", Message: fmt.Sprintf("must have at most %d decimal places", p.MaxDecimalPlaces)})
+	}
+	return errs
+}
+
+// checkCurrency returns the problem of a currency code entered in the given field.
+func checkCurrency(field, code string) []responses.FieldError {
+	if !currency.Valid(code) {
+		return []responses.FieldError{{Field: field, Code: "UNSUPPORTED_CURRENCY", Message: "must be one of " + strings.Join(currency.Supported(), ", ")}}
+	}
+	return nil
+}
+
+var requestValidator = newRequestValidator()
+
+// pinRule is the rule every new PIN must pass, available to requests as the "pin" tag.
+const pinRule = "numeric,len=6"
+
+// newRequestValidator returns a validator that reports fields by their JSON name.
+func newRequestValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterAlias("pin", pinRule)
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return validate
+}
+
+// validateRequest runs the validate tags of the request and returns one FieldError per failed field.
+func validateRequest(req interface{}) []responses.FieldError {
+	err := requestValidator.Struct(req)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return []responses.FieldError{{Code: "INVALID", Message: err.Error()}}
+	}
+
+	errs := make([]responses.FieldError, 0, len(validationErrors))
+	for _, fieldErr := range validationErrors {
+		fieldError := responses.FieldError{Field: fieldErr.Field()}
+		// Aliases such as "pin" report the rule that failed
+		switch fieldErr.ActualTag() {
+		case "required":
+			fieldError.Code, fieldError.Message = "REQUIRED", "is required"
+		case "uuid", "uuid4":
+			fieldError.Code, fieldError.Message = "INVALID_FORMAT", "must be a valid UUID"
+		case "numeric":
+			fieldError.Code, fieldError.Message = "INVALID_FORMAT", "must contain digits only"
+		case "len":
+			fieldError.Code, fieldError.Message = "INVALID_LENGTH", fmt.Sprintf("must be exactly %s characters", fieldErr.Param())
+		case "max":
+			fieldError.Code, fieldError.Message = "TOO_LONG", fmt.Sprintf("must be at most %s characters", fieldErr.Param())
+		default:
+			fieldError.Code, fieldError.Message = "INVALID", fmt.Sprintf("failed the %q rule", fieldErr.ActualTag())
+		}
+		errs = append(errs, fieldError)
+	}
+	return errs
+}
+
+// amountDecodeError converts an amount that could not be decoded into a FieldError.
+// The money endpoints only carry a single Money field, named "amount".
+func amountDecodeError(err error) []responses.FieldError {
+	switch {
+	case errors.Is(err, money.ErrTooManyDecimals):
+		return []responses.FieldError{{Field: "amount", Code: "TOO_MANY_DECIMALS", Message: err.Error()}}
+	case errors.Is(err, money.ErrOutOfRange):
+		return []responses.FieldError{{Field: "amount", Code: "EXCEEDS_MAXIMUM", Message: err.Error()}}
+	case errors.Is(err, money.ErrInvalidAmount):
+		return []responses.FieldError{{Field: "amount", Code: "INVALID_FORMAT", Message: "must be a decimal number"}}
+	}
+	return nil
+}
+
+// writeValidationErrors responds with the field errors of an invalid request.
+func writeValidationErrors(w http.ResponseWriter, errs []responses.FieldError) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(responses.FailedResponse{
+		Message: "Invalid request payload",
+		Code:    "VALIDATION_ERROR",
+		Errors:  errs,
+	})
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import "testing"
+
+func TestPINRule(t *testing.T) {
+	tests := []struct {
+		pin  string
+		code string
+	}{
+		{pin: "123456"},
+		{pin: "12345", code: "INVALID_LENGTH"},
+		{pin: "1234567", code: "INVALID_LENGTH"},
+		{pin: "12345a", code: "INVALID_FORMAT"},
+		{pin: "", code: "REQUIRED"},
+	}
+	for _, tt := range tests {
+		for _, req := range []interface{}{
+			RegisterRequest{FirstName: "A", LastName: "B", PhoneNumber: "0811", Address: "C", PIN: tt.pin},
+			ChangePINRequest{OldPIN: "000000", NewPIN: tt.pin},
+			ResetPINRequest{ResetToken: "token", NewPIN: tt.pin},
+		} {
+			errs := validateRequest(req)
+			if tt.code == "" {
+				if len(errs) != 0 {
+					t.Errorf("%T with PIN %q: got %v, want no errors", req, tt.pin, errs)
+				}
+				continue
+			}
+			if len(errs) != 1 || errs[0].Code != tt.code {
+				t.Errorf("%T with PIN %q: got %v, want %s", req, tt.pin, errs, tt.code)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package ledger
+
+import (
+	"fmt"
+	"mnctech-restapi/cmd/rest-api/money"
+
+	"gorm.io/gorm"
+)
+
+// Violation describes one broken ledger invariant.
+type Violation struct {
+	Check   string
+	Message string
+}
+
+func (v Violation) String() string {
+	return v.Check + ": " + v.Message
+}
+
+// CheckInvariants verifies that money is conserved: the postings of every journal
+// entry add up to zero, so do all postings together, and the stored balance of
+// every UserAccount and the running balance of its ledger account equal the sum of
+// the postings of that ledger account. It reads the whole ledger, so it runs as a
+// periodic job rather than per transaction.
+func CheckInvariants(db *gorm.DB) ([]Violation, error) {
+	var violations []Violation
+
+	var total money.Money
+	if err := db.Table("postings").Where("deleted_at IS NULL").
+		Select("COALESCE(SUM(amount), 0)").Scan(&total).Error; err != nil {
+		return nil, err
+	}
+	if !total.IsZero() {
+		violations = append(violations, Violation{
+			Check:   "ZERO_SUM",
+			Message: fmt.Sprintf("all postings add up to %s", total),
+		})
+	}
+
+	var unbalanced []struct {
+		UID   string
+		Total money.Money
+	}
+	if err := db.Table("journal_entries").
+		Select("journal_entries.uid, SUM(postings.amount) AS total").
+		Joins("JOIN postings ON postings.journal_entry_id = journal_entries.id AND postings.deleted_at IS NULL").
+		Where("journal_entries.deleted_at IS NULL").
+		Group("journal_entries.id, journal_entries.uid").
+		Having("SUM(postings.amount) <> 0").
+		Scan(&unbalanced).Error; err != nil {
+		return nil, err
+	}
+	for _, entry := range unbalanced {
+		violations = append(violations, Violation{
+			Check:   "BALANCED_ENTRY",
+			Message: fmt.Sprintf("journal entry %s adds up to %s", entry.UID, entry.Total),
+		})
+	}
+
+	var mismatched []struct {
+		ID             uint
+		CurrentBalance money.Money
+		LedgerBalance  money.Money
+	}
+	if err := db.Table("user_accounts").
+		Select("user_accounts.id, user_accounts.current_balance, COALESCE(SUM(postings.amount), 0) AS ledger_balance").
+		Joins("LEFT JOIN ledger_accounts ON ledger_accounts.user_account_id = user_accounts.id").
+		Joins("LEFT JOIN postings ON postings.ledger_account_id = ledger_accounts.id AND postings.deleted_at IS NULL").
+		Where("user_accounts.deleted_at IS NULL").
+		Group("user_accounts.id, user_accounts.current_balance").
+		Having("user_accounts.current_balance <> COALESCE(SUM(postings.amount), 0)").
+		Scan(&mismatched).Error; err != nil {
+		return nil, err
+	}
+	for _, account := range mismatched {
+		violations = append(violations, Violation{
+			Check:   "ACCOUNT_BALANCE",
+			Message: fmt.Sprintf("account %d has %s, ledger has %s", account.ID, account.CurrentBalance, account.LedgerBalance),
+		})
+	}
+
+	var drifted []struct {
+		Code          string
+		Balance       money.Money
+		PostingsTotal money.Money
+	}
+	if err := db.Table("ledger_accounts").
+		Select("ledger_accounts.code, ledger_accounts.balance, COALESCE(SUM(postings.amount), 0) AS postings_total").
+		Joins("LEFT JOIN postings ON postings.ledger_account_id = ledger_accounts.id AND postings.deleted_at IS NULL").
+		Where("ledger_accounts.type = ? AND ledger_accounts.deleted_at IS NULL", accountTypeUser).
+		Group("ledger_accounts.id, ledger_accounts.code, ledger_accounts.balance").
+		Having("ledger_accounts.balance <> COALESCE(SUM(postings.amount), 0)").
+		Scan(&drifted).Error; err != nil {
+		return nil, err
+	}
+	for _, account := range drifted {
+		violations = append(violations, Violation{
+			Check:   "LEDGER_BALANCE",
+			Message: fmt.Sprintf("ledger account %s has a running balance of %s, postings add up to %s", account.Code, account.Balance, account.PostingsTotal),
+		})
+	}
+
+	return violations, nil
+}
@@ -0,0 +1,179 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// System accounts stand for money entering or leaving the wallets.
+const (
+	AccountTopUpSource        = "SYSTEM:TOPUP_SOURCE"        // Money topped up into wallets
+	AccountMerchantSettlement = "SYSTEM:MERCHANT_SETTLEMENT" // Money paid out of wallets to merchants
+	AccountOpeningBalance     = "SYSTEM:OPENING_BALANCE"     // Balances that existed before the ledger
+	AccountTransferClearing   = "SYSTEM:TRANSFER_CLEARING"   // Transfers debited from the sender but not yet credited
+	AccountFxPosition         = "SYSTEM:FX_POSITION"         // Money bought and sold converting between currencies
+	AccountMerchantPayout     = "SYSTEM:MERCHANT_PAYOUT"     // Money settled out to the payout accounts of merchants
+)
+
+// baseCurrency is the currency of the system accounts without a currency suffix.
+const baseCurrency = "IDR"
+
+// SystemAccount returns the code of the system account for money in the given
+// currency. Each currency has its own system accounts so amounts in different
+// currencies never meet in one balance; rupiah keeps the plain codes.
+func SystemAccount(code, currency string) string {
+	if currency == "" || currency == baseCurrency {
+		return code
+	}
+	return code + ":" + currency
+}
+
+const (
+	accountTypeUser       = "USER"
+	accountTypeMerchant   = "MERCHANT"
+	accountTypeSystem     = "SYSTEM"
+	userAccountPrefix     = "USER:"
+	merchantAccountPrefix = "MERCHANT:"
+)
+
+var (
+	ErrUnbalanced      = errors.New("journal entry is not balanced")
+	ErrBalanceMismatch = errors.New("account balance does not match the ledger")
+)
+
+// Line is one leg of a journal entry: a positive amount moves money into the
+// account, a negative amount moves money out of it.
+type Line struct {
+	AccountCode string
+	Amount      money.Money
+}
+
+// UserAccountCode returns the code of the ledger account of a UserAccount.
+func UserAccountCode(userAccountID uint) string {
+	return userAccountPrefix + strconv.FormatUint(uint64(userAccountID), 10)
+}
+
+// MerchantAccountCode returns the code of the settlement account of a Merchant,
+// where its payments collect until they are settled.
+func MerchantAccountCode(merchantID uint) string {
+	return merchantAccountPrefix + strconv.FormatUint(uint64(merchantID), 10)
+}
+
+// Transfer returns the two lines moving amount from one account to another.
+func Transfer(from, to string, amount money.Money) []Line {
+	return []Line{
+		{AccountCode: from, Amount: amount.Neg()},
+		{AccountCode: to, Amount: amount},
+	}
+}
+
+// Post records a journal entry for the transaction. The lines must add up to zero.
+// The running balances of USER accounts move with their postings; callers lock the
+// UserAccount first, so its ledger account is not contended. System accounts take
+// part in almost every entry and are summed when needed instead.
+func Post(tx *gorm.DB, transactionReff, category, description string, lines ...Line) (models.JournalEntry, error) {
+	if len(lines) < 2 {
+		return models.JournalEntry{}, fmt.Errorf("%w: needs at least two lines", ErrUnbalanced)
+	}
+	var total money.Money
+	for _, line := range lines {
+		if line.Amount.IsZero() {
+			return models.JournalEntry{}, fmt.Errorf("%w: zero amount for %s", ErrUnbalanced, line.AccountCode)
+		}
+		total = total.Add(line.Amount)
+	}
+	if !total.IsZero() {
+		return models.JournalEntry{}, fmt.Errorf("%w: lines add up to %s", ErrUnbalanced, total)
+	}
+
+	entry := models.JournalEntry{
+		UID:                 uuid.New().String(),
+		TransactionReff:     transactionReff,
+		TransactionCategory: category,
+		Description:         description,
+	}
+	for _, line := range lines {
+		account, err := ensureAccount(tx, line.AccountCode)
+		if err != nil {
+			return models.JournalEntry{}, err
+		}
+		entry.Postings = append(entry.Postings, models.Posting{
+			LedgerAccountID: account.ID,
+			Amount:          line.Amount,
+		})
+	}
+
+	if err := tx.Create(&entry).Error; err != nil {
+		return models.JournalEntry{}, err
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line.AccountCode, userAccountPrefix) {
+			continue
+		}
+		if err := tx.Model(&models.LedgerAccount{}).Where("id = ?", entry.Postings[i].LedgerAccountID).
+			Update("balance", gorm.Expr("balance + ?", line.Amount)).Error; err != nil {
+			return models.JournalEntry{}, err
+		}
+	}
+	return entry, nil
+}
+
+// ensureAccount returns the ledger account with the code, opening it on first use.
+func ensureAccount(tx *gorm.DB, code string) (models.LedgerAccount, error) {
+	account := models.LedgerAccount{Code: code, Type: accountTypeSystem}
+	if idText, ok := strings.CutPrefix(code, userAccountPrefix); ok {
+		id, err := strconv.ParseUint(idText, 10, 64)
+		if err != nil {
+			return models.LedgerAccount{}, fmt.Errorf("invalid ledger account code %q", code)
+		}
+		userAccountID := uint(id)
+		account.Type = accountTypeUser
+		account.UserAccountID = &userAccountID
+	} else if strings.HasPrefix(code, merchantAccountPrefix) {
+		account.Type = accountTypeMerchant
+	}
+
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error; err != nil {
+		return models.LedgerAccount{}, err
+	}
+	if account.ID == 0 {
+		if err := tx.Where("code = ?", code).First(&account).Error; err != nil {
+			return models.LedgerAccount{}, err
+		}
+	}
+	return account, nil
+}
+
+// Balance returns the sum of the postings of the ledger account with the code.
+func Balance(tx *gorm.DB, code string) (money.Money, error) {
+	var balance money.Money
+	err := tx.Model(&models.Posting{}).
+		Joins("JOIN ledger_accounts ON ledger_accounts.id = postings.ledger_account_id").
+		Where("ledger_accounts.code = ? AND postings.deleted_at IS NULL", code).
+		Select("COALESCE(SUM(postings.amount), 0)").
+		Scan(&balance).Error
+	return balance, err
+}
+
+// VerifyUserAccount checks that the stored balance of the account equals the running
+// balance of its ledger account. CheckInvariants checks that running balances match
+// the postings.
+func VerifyUserAccount(tx *gorm.DB, userAccount models.UserAccount) error {
+	var balance money.Money
+	if err := tx.Model(&models.LedgerAccount{}).Where("code = ?", UserAccountCode(userAccount.ID)).
+		Select("balance").Scan(&balance).Error; err != nil {
+		return err
+	}
+	if balance != userAccount.CurrentBalance {
+		return fmt.Errorf("%w: account %d has %s, ledger has %s", ErrBalanceMismatch, userAccount.ID, userAccount.CurrentBalance, balance)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package ledger_test
+
+import (
+	"errors"
+	"mnctech-restapi/cmd/rest-api/database/databasetest"
+	"mnctech-restapi/cmd/rest-api/ledger"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPostKeepsRunningBalance(t *testing.T) {
+	db := databasetest.Open(t)
+
+	// Roll everything back so the shared system accounts keep adding up to zero
+	tx := db.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	user := models.User{UID: uuid.New().String(), PhoneNumber: uuid.New().String()}
+	if err := tx.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	userAccount := models.UserAccount{UID: uuid.New().String(), UserID: user.ID, Name: "Main"}
+	if err := tx.Create(&userAccount).Error; err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+	code := ledger.UserAccountCode(userAccount.ID)
+
+	if _, err := ledger.Post(tx, uuid.New().String(), "TOPUP", "Top up", ledger.Transfer(ledger.AccountTopUpSource, code, money.FromMajor(100))...); err != nil {
+		t.Fatalf("post top-up: %v", err)
+	}
+	if _, err := ledger.Post(tx, uuid.New().String(), "PAYMENT", "Payment", ledger.Transfer(code, ledger.AccountMerchantSettlement, money.FromMajor(30))...); err != nil {
+		t.Fatalf("post payment: %v", err)
+	}
+
+	var account models.LedgerAccount
+	if err := tx.Where("code = ?", code).First(&account).Error; err != nil {
+		t.Fatalf("load ledger account: %v", err)
+	}
+	if account.Balance != money.FromMajor(70) {
+		t.Errorf("got running balance %s, want 70.00", account.Balance)
+	}
+
+	userAccount.CurrentBalance = money.FromMajor(70)
+	if err := ledger.VerifyUserAccount(tx, userAccount); err != nil {
+		t.Errorf("verify matching balance: %v", err)
+	}
+	userAccount.CurrentBalance = money.FromMajor(71)
+	if err := ledger.VerifyUserAccount(tx, userAccount); !errors.Is(err, ledger.ErrBalanceMismatch) {
+		t.Errorf("got error %v for a mismatched balance, want %v", err, ledger.ErrBalanceMismatch)
+	}
+
+	// A running balance that drifted from the postings is reported
+	if err := tx.Model(&account).Update("balance", money.FromMajor(69)).Error; err != nil {
+		t.Fatalf("corrupt running balance: %v", err)
+	}
+	violations, err := ledger.CheckInvariants(tx)
+	if err != nil {
+		t.Fatalf("check invariants: %v", err)
+	}
+	found := false
+	for _, violation := range violations {
+		found = found || violation.Check == "LEDGER_BALANCE"
+	}
+	if !found {
+		t.Errorf("got violations %v, want LEDGER_BALANCE", violations)
+	}
+}
@@ -1,36 +1,61 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/broker"
+	"mnctech-restapi/cmd/rest-api/config"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/database"
 	"mnctech-restapi/cmd/rest-api/handlers"
 	"mnctech-restapi/cmd/rest-api/middlewares"
-	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"mnctech-restapi/cmd/rest-api/notify"
+	"mnctech-restapi/cmd/rest-api/otp"
+	"mnctech-restapi/cmd/rest-api/outbox"
+	"mnctech-restapi/cmd/rest-api/storage"
 	"net/http"
 	"os"
 	"time"
 
-	"github.com/go-gormigrate/gormigrate/v2"
-
 	"github.com/gorilla/mux"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
 func main() {
+	// Refuse to start without usable token keys
+	appConfig, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
 	// Connect to the database and perform migrations
-	db := ConnectDB()
-	defer CloseDBConnection(db)                               // Ensure database connection is closed on exit
-	accessTokenKey := []byte(os.Getenv("ACCESS_TOKEN_KEY"))   // or replace with your actual key
-	refreshTokenKey := []byte(os.Getenv("REFRESH_TOKEN_KEY")) // or replace with your actual key
+	db := database.ConnectDB()
+	defer database.CloseDBConnection(db) // Ensure database connection is closed on exit
 
 	// Call the migration function
-	if err := MigrateDatabase(db); err != nil {
+	if err := database.MigrateDatabase(db); err != nil {
 		log.Fatalf("could not migrate: %v", err)
 	}
 
+	// Without RabbitMQ there is no separate outbox relay or transfer worker, so run both in this process
+	if os.Getenv("BROKER") == "memory" {
+		messageBroker := broker.NewMemory()
+		go outbox.DefaultRelay(db, messageBroker).Run(context.Background())
+		worker := &handlers.AppHandler{DB: db, Limits: appConfig.Limits, FxRates: appConfig.FxRates}
+		go messageBroker.Consume(context.Background(), handlers.TransferQueue, broker.DefaultRetryPolicy(), worker.ProcessTransfer)
+		go messageBroker.Consume(context.Background(), broker.DeadLetterQueue(handlers.TransferQueue), handlers.DeadTransferRetryPolicy(broker.DefaultRetryPolicy()), worker.FailDeadTransfer)
+	}
+
+	// Expire holds that were neither captured nor released in time
+	sweeper := &handlers.AppHandler{DB: db}
+	go sweeper.RunHoldSweeper(context.Background(), appConfig.HoldSweepInterval)
+
 	// Set up the router using the NewRouter function
-	r := NewRouter(db, accessTokenKey, refreshTokenKey)
+	r := NewRouter(db, appConfig)
 
 	// Start the server
 	log.Println("Starting server on :8080")
@@ -41,101 +66,187 @@ func main() {
 
 var DB *gorm.DB
 
-// ConnectDB establishes a connection to the PostgreSQL database.
-func ConnectDB() *gorm.DB {
-	var err error
+// Config holds the settings the REST API is started with.
+type Config struct {
+	AccessTokenKeys   *auth.KeyManager
+	RefreshTokenKey   []byte
+	LoginThrottle     handlers.LoginThrottleConfig
+	OTPSender         otp.Sender
+	AmountPolicy      handlers.AmountPolicy
+	IdempotencyTTL    time.Duration
+	IdempotencyLease  time.Duration
+	Limits            handlers.LimitPolicy
+	Store             storage.Store
+	Notifier          notify.Notifier
+	FxRates           currency.FxRateProvider
+	HoldTTL           time.Duration
+	HoldSweepInterval time.Duration
+	InquiryLimit      handlers.InquiryRateLimit
+}
 
-	dsn := "host=db user=mnctech password=mnctechpass dbname=mnctechdb port=5432 sslmode=disable"
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
-	})
+// LoadConfig reads the REST API settings from the environment.
+func LoadConfig() (Config, error) {
+	accessTokenKeys, err := auth.LoadKeyManager(config.GetEnv("JWT_KEYS_DIR", "keys"), os.Getenv("JWT_SIGNING_KID"))
 	if err != nil {
-		log.Fatal(err)
+		return Config{}, fmt.Errorf("access token keys: %w", err)
 	}
 
-	// Retrieve the underlying sql.DB from GORM and configure connection pool
-	sqlDB, err := db.DB()
-	if err != nil {
-		log.Fatal(err)
+	refreshTokenKey := []byte(os.Getenv("REFRESH_TOKEN_KEY"))
+	if len(refreshTokenKey) == 0 {
+		return Config{}, errors.New("REFRESH_TOKEN_KEY is not set")
 	}
 
-	// Set up connection pool settings
-	sqlDB.SetMaxIdleConns(10)           // Set maximum number of idle connections
-	sqlDB.SetMaxOpenConns(100)          // Set maximum number of open connections
-	sqlDB.SetConnMaxLifetime(time.Hour) // Set maximum connection lifetime
+	loginThrottle := handlers.DefaultLoginThrottleConfig()
+	loginThrottle.MaxAttempts = config.GetEnvInt("LOGIN_MAX_ATTEMPTS", loginThrottle.MaxAttempts)
+	loginThrottle.Cooldown = config.GetEnvDuration("LOGIN_LOCK_COOLDOWN", loginThrottle.Cooldown)
+	loginThrottle.MaxCooldown = config.GetEnvDuration("LOGIN_LOCK_MAX_COOLDOWN", loginThrottle.MaxCooldown)
+	if loginThrottle.MaxAttempts < 1 {
+		return Config{}, errors.New("LOGIN_MAX_ATTEMPTS must be at least 1")
+	}
 
-	return db
-}
+	amountPolicy := handlers.DefaultAmountPolicy()
+	if value := os.Getenv("AMOUNT_MAX"); value != "" {
+		maxAmount, err := money.Parse(value)
+		if err != nil {
+			return Config{}, fmt.Errorf("AMOUNT_MAX: %w", err)
+		}
+		amountPolicy.MaxAmount = maxAmount
+	}
+	amountPolicy.MaxDecimalPlaces = config.GetEnvInt("AMOUNT_MAX_DECIMAL_PLACES", amountPolicy.MaxDecimalPlaces)
+	if amountPolicy.MaxDecimalPlaces < 0 || amountPolicy.MaxDecimalPlaces > money.Scale {
+		return Config{}, fmt.Errorf("AMOUNT_MAX_DECIMAL_PLACES must be between 0 and %d, amounts keep %d decimal places", money.Scale, money.Scale)
+	}
 
-// CloseDBConnection closes the database connection pool.
-func CloseDBConnection(db *gorm.DB) {
-	sqlDB, err := db.DB()
-	if err != nil {
-		log.Printf("Error getting DB from GORM: %v", err)
-		return
+	limits := handlers.DefaultLimitPolicy()
+	if path := os.Getenv("LIMITS_FILE"); path != "" {
+		if limits, err = handlers.LoadLimitPolicy(path); err != nil {
+			return Config{}, fmt.Errorf("LIMITS_FILE: %w", err)
+		}
 	}
-	if err := sqlDB.Close(); err != nil {
-		log.Printf("Error closing DB connection: %v", err)
-	} else {
-		log.Println("Database connection closed successfully.")
+
+	// Without rates every account keeps to its own currency
+	var fxRates currency.FxRateProvider
+	if path := os.Getenv("FX_RATES_FILE"); path != "" {
+		rates, err := currency.LoadStaticRates(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("FX_RATES_FILE: %w", err)
+		}
+		fxRates = rates
 	}
-}
 
-// MigrateDatabase initializes the database migrations
-func MigrateDatabase(db *gorm.DB) error {
-	m := gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
-		{
-			ID: "20241020_09",
-			Migrate: func(tx *gorm.DB) error {
-				if err := tx.AutoMigrate(
-					&models.User{},
-					&models.UserAccount{},
-					&models.AccountTransactionLog{},
-					&models.TopUpTransaction{},
-					&models.PaymentTransaction{},
-					&models.TransferTransaction{},
-				); err != nil {
-					log.Printf("Error during migration: %v", err)
-					return err
-				}
-				return nil
-			},
-			Rollback: func(tx *gorm.DB) error {
-				// Define rollback logic here
-				return tx.Migrator().DropTable("users")
-			},
-		},
-	})
-
-	// Execute migrations
-	if err := m.Migrate(); err != nil {
-		log.Printf("Error running migrations: %v", err) // Log any errors
-		return err
+	inquiryLimit := handlers.DefaultInquiryRateLimit()
+	inquiryLimit.MaxLookups = config.GetEnvInt("TRANSFER_INQUIRY_MAX_LOOKUPS", inquiryLimit.MaxLookups)
+	inquiryLimit.Window = config.GetEnvDuration("TRANSFER_INQUIRY_WINDOW", inquiryLimit.Window)
+
+	var otpNotifier notify.Notifier = notify.LogNotifier{}
+	if os.Getenv("OTP_SENDER") == "file" {
+		otpNotifier = &notify.FileNotifier{Path: config.GetEnv("OTP_FILE_PATH", "tmp/otp.log")}
 	}
-	log.Println("Migrations ran successfully!")
-	return nil
+	otpSender := otp.NotifierSender{Notifier: otpNotifier}
+
+	var notifier notify.Notifier = notify.LogNotifier{}
+	if os.Getenv("NOTIFIER") == "file" {
+		notifier = &notify.FileNotifier{Path: config.GetEnv("NOTIFY_FILE_PATH", "tmp/notifications.log")}
+	}
+
+	return Config{
+		AccessTokenKeys:   accessTokenKeys,
+		RefreshTokenKey:   refreshTokenKey,
+		LoginThrottle:     loginThrottle,
+		OTPSender:         otpSender,
+		AmountPolicy:      amountPolicy,
+		IdempotencyTTL:    config.GetEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		IdempotencyLease:  config.GetEnvDuration("IDEMPOTENCY_LOCK_TIMEOUT", time.Minute),
+		Limits:            limits,
+		Store:             &storage.Local{Dir: config.GetEnv("STORAGE_DIR", "tmp/uploads")},
+		Notifier:          notifier,
+		FxRates:           fxRates,
+		HoldTTL:           config.GetEnvDuration("HOLD_TTL", handlers.DefaultHoldTTL),
+		HoldSweepInterval: config.GetEnvDuration("HOLD_SWEEP_INTERVAL", time.Minute),
+		InquiryLimit:      inquiryLimit,
+	}, nil
 }
 
 // NewRouter initializes and returns a new mux.Router with the defined routes.
-func NewRouter(db *gorm.DB, accessTokenKey, refreshTokenKey []byte) *mux.Router {
+func NewRouter(db *gorm.DB, appConfig Config) *mux.Router {
 	appHandler := &handlers.AppHandler{
-		DB: db,
+		DB:           db,
+		AmountPolicy: appConfig.AmountPolicy,
+		Limits:       appConfig.Limits,
+		Store:        appConfig.Store,
+		Notifier:     appConfig.Notifier,
+		FxRates:      appConfig.FxRates,
+		HoldTTL:      appConfig.HoldTTL,
+		InquiryLimit: appConfig.InquiryLimit,
 	}
 	authHandler := &handlers.AuthHandler{
 		AppHandler:      appHandler,
-		AccessTokenKey:  accessTokenKey,
-		RefreshTokenKey: refreshTokenKey,
+		AccessTokenKeys: appConfig.AccessTokenKeys,
+		RefreshTokenKey: appConfig.RefreshTokenKey,
+		LoginThrottle:   appConfig.LoginThrottle,
+		OTPSender:       appConfig.OTPSender,
 	}
 
 	r := mux.NewRouter()
+	jwtMiddleware := middlewares.JWTMiddleware(appConfig.AccessTokenKeys, db)
+	idempotencyMiddleware := middlewares.IdempotencyMiddleware(db, appConfig.IdempotencyTTL, appConfig.IdempotencyLease)
+	adminMiddleware := middlewares.RequireRole(db, auth.RoleAdmin)
+	merchantMiddleware := middlewares.MerchantAPIKey(db)
 
 	// Define routes
 	r.HandleFunc("/register", authHandler.Register).Methods("POST")
 	r.HandleFunc("/login", authHandler.Login).Methods("POST")
-	r.Handle("/topup", middlewares.JWTMiddleware(accessTokenKey)(http.HandlerFunc(appHandler.HandleTopUp))).Methods("POST")
-	r.Handle("/pay", middlewares.JWTMiddleware(accessTokenKey)(http.HandlerFunc(appHandler.HandlePayment))).Methods("POST")
-	r.Handle("/transfer", middlewares.JWTMiddleware(accessTokenKey)(http.HandlerFunc(appHandler.HandleTransfer))).Methods("POST")
-	r.Handle("/transactions", middlewares.JWTMiddleware(accessTokenKey)(http.HandlerFunc(appHandler.GetTransactionList))).Methods("GET")
+	r.HandleFunc("/token/refresh", authHandler.RefreshToken).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", authHandler.JWKS).Methods("GET")
+	r.Handle("/logout", jwtMiddleware(http.HandlerFunc(appHandler.Logout))).Methods("POST")
+	r.Handle("/logout-all", jwtMiddleware(http.HandlerFunc(appHandler.LogoutAll))).Methods("POST")
+	r.Handle("/sessions", jwtMiddleware(http.HandlerFunc(appHandler.GetSessions))).Methods("GET")
+	r.Handle("/pin", jwtMiddleware(http.HandlerFunc(authHandler.ChangePIN))).Methods("PUT")
+	r.HandleFunc("/pin/reset/request", authHandler.RequestPINReset).Methods("POST")
+	r.HandleFunc("/pin/reset/verify", authHandler.VerifyPINReset).Methods("POST")
+	r.HandleFunc("/pin/reset", authHandler.ResetPIN).Methods("POST")
+	r.Handle("/profile", jwtMiddleware(http.HandlerFunc(authHandler.GetProfile))).Methods("GET")
+	r.Handle("/profile", jwtMiddleware(http.HandlerFunc(authHandler.UpdateProfile))).Methods("PUT")
+	r.Handle("/profile/phone", jwtMiddleware(http.HandlerFunc(authHandler.RequestPhoneChange))).Methods("POST")
+	r.Handle("/profile/phone/verify", jwtMiddleware(http.HandlerFunc(authHandler.VerifyPhoneChange))).Methods("POST")
+	r.Handle("/topup", jwtMiddleware(idempotencyMiddleware(http.HandlerFunc(appHandler.HandleTopUp)))).Methods("POST")
+	r.Handle("/pay", jwtMiddleware(idempotencyMiddleware(http.HandlerFunc(appHandler.HandlePayment)))).Methods("POST")
+	r.Handle("/pay/qr", jwtMiddleware(idempotencyMiddleware(http.HandlerFunc(appHandler.HandleQRPayment)))).Methods("POST")
+	r.Handle("/transfer", jwtMiddleware(idempotencyMiddleware(http.HandlerFunc(appHandler.HandleTransfer)))).Methods("POST")
+	r.Handle("/transfer/inquiry", jwtMiddleware(http.HandlerFunc(appHandler.TransferInquiry))).Methods("POST")
+	r.Handle("/transfer/{transfer_id}", jwtMiddleware(http.HandlerFunc(appHandler.GetTransferStatus))).Methods("GET")
+	r.Handle("/transactions", jwtMiddleware(http.HandlerFunc(appHandler.GetTransactionList))).Methods("GET")
+	r.Handle("/admin/refunds", jwtMiddleware(adminMiddleware(idempotencyMiddleware(http.HandlerFunc(appHandler.HandleAdminRefund))))).Methods("POST")
+	r.Handle("/statements", jwtMiddleware(http.HandlerFunc(appHandler.GetStatement))).Methods("GET")
+	r.Handle("/transactions/{transaction_id}", jwtMiddleware(http.HandlerFunc(appHandler.GetTransactionDetail))).Methods("GET")
+	r.Handle("/limits", jwtMiddleware(http.HandlerFunc(appHandler.GetLimits))).Methods("GET")
+	r.Handle("/accounts", jwtMiddleware(http.HandlerFunc(appHandler.GetAccounts))).Methods("GET")
+	r.Handle("/accounts", jwtMiddleware(http.HandlerFunc(appHandler.OpenAccount))).Methods("POST")
+	r.Handle("/accounts/move", jwtMiddleware(idempotencyMiddleware(http.HandlerFunc(appHandler.HandleMove)))).Methods("POST")
+	r.Handle("/accounts/{account_id}/default", jwtMiddleware(http.HandlerFunc(appHandler.SetDefaultAccount))).Methods("PUT")
+	r.Handle("/accounts/{account_id}", jwtMiddleware(http.HandlerFunc(appHandler.CloseAccount))).Methods("DELETE")
+	r.Handle("/fx/quotes", jwtMiddleware(http.HandlerFunc(appHandler.CreateFxQuote))).Methods("POST")
+	r.Handle("/fx/conversions", jwtMiddleware(idempotencyMiddleware(http.HandlerFunc(appHandler.HandleFxConversion)))).Methods("POST")
+	r.Handle("/holds", jwtMiddleware(idempotencyMiddleware(http.HandlerFunc(appHandler.HandlePlaceHold)))).Methods("POST")
+	r.Handle("/holds", jwtMiddleware(http.HandlerFunc(appHandler.GetHolds))).Methods("GET")
+	r.Handle("/kyc", jwtMiddleware(http.HandlerFunc(appHandler.SubmitKYC))).Methods("POST")
+	r.Handle("/kyc", jwtMiddleware(http.HandlerFunc(appHandler.GetKYC))).Methods("GET")
+	r.Handle("/admin/kyc", jwtMiddleware(adminMiddleware(http.HandlerFunc(appHandler.GetKYCQueue)))).Methods("GET")
+	r.Handle("/admin/kyc/{submission_id}/documents/{document}", jwtMiddleware(adminMiddleware(http.HandlerFunc(appHandler.GetKYCDocument)))).Methods("GET")
+	r.Handle("/admin/kyc/{submission_id}/approve", jwtMiddleware(adminMiddleware(http.HandlerFunc(appHandler.ApproveKYC)))).Methods("POST")
+	r.Handle("/admin/kyc/{submission_id}/reject", jwtMiddleware(adminMiddleware(http.HandlerFunc(appHandler.RejectKYC)))).Methods("POST")
+	r.Handle("/admin/merchants", jwtMiddleware(adminMiddleware(http.HandlerFunc(appHandler.CreateMerchant)))).Methods("POST")
+	r.Handle("/admin/merchants", jwtMiddleware(adminMiddleware(http.HandlerFunc(appHandler.GetMerchants)))).Methods("GET")
+	r.Handle("/admin/merchants/{merchant_id}/api-key", jwtMiddleware(adminMiddleware(http.HandlerFunc(appHandler.RotateMerchantAPIKey)))).Methods("POST")
+	r.Handle("/admin/merchants/{merchant_id}/status", jwtMiddleware(adminMiddleware(http.HandlerFunc(appHandler.SetMerchantStatus)))).Methods("PUT")
+	r.Handle("/merchant/payments", merchantMiddleware(http.HandlerFunc(appHandler.GetMerchantPayments))).Methods("GET")
+	r.Handle("/merchant/settlements", merchantMiddleware(http.HandlerFunc(appHandler.GetMerchantSettlements))).Methods("GET")
+	r.Handle("/merchant/refunds", merchantMiddleware(idempotencyMiddleware(http.HandlerFunc(appHandler.HandleMerchantRefund)))).Methods("POST")
+	r.Handle("/merchant/qr", merchantMiddleware(http.HandlerFunc(appHandler.GenerateMerchantQR))).Methods("POST")
+	r.Handle("/merchant/qr/{reference}", merchantMiddleware(http.HandlerFunc(appHandler.GetMerchantQR))).Methods("GET")
+	r.Handle("/merchant/holds/{hold_id}", merchantMiddleware(http.HandlerFunc(appHandler.GetMerchantHold))).Methods("GET")
+	r.Handle("/merchant/holds/{hold_id}/capture", merchantMiddleware(idempotencyMiddleware(http.HandlerFunc(appHandler.HandleCaptureHold)))).Methods("POST")
+	r.Handle("/merchant/holds/{hold_id}/release", merchantMiddleware(http.HandlerFunc(appHandler.HandleReleaseHold))).Methods("POST")
 
 	return r
 }
@@ -0,0 +1,268 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	idempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	maxIdempotentRequestBytes = 1 << 20
+)
+
+// errIdempotencyKeyTakenOver means a retry took over the key after the lease lapsed.
+var errIdempotencyKeyTakenOver = errors.New("idempotency key was taken over by a retry")
+
+type idempotencyClaimKey struct{}
+
+// idempotencyClaim identifies the key a request holds, as long as its lease is unchanged.
+type idempotencyClaim struct {
+	id          uint
+	lockedUntil time.Time
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// IdempotencyMiddleware makes requests carrying an Idempotency-Key header safe to retry.
+// The first request with a key is executed and its response stored; a retry with the
+// same body gets the stored response, a retry with a different body is rejected with
+// 422 and a retry while the first request is still running is rejected with 409.
+// Handlers store their response with CompleteIdempotencyKey in the transaction
+// that changes balances, so a key still IN_PROGRESS means nothing was committed.
+// A request holds its key for lease; a retry after that executes again, and the
+// first request can no longer commit. It must run after JWTMiddleware or
+// MerchantAPIKey, since keys are scoped per user or merchant.
+func IdempotencyMiddleware(db *gorm.DB, ttl, lease time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if len(key) > maxIdempotencyKeyLength {
+				writeFailed(w, http.StatusBadRequest, "INVALID_IDEMPOTENCY_KEY", "Idempotency-Key is too long")
+				return
+			}
+
+			userID, ok := r.Context().Value(auth.UserIDKey).(string)
+			if !ok {
+				// Merchants and users both have UUIDs, so their keys cannot collide
+				userID, ok = r.Context().Value(auth.MerchantIDKey).(string)
+			}
+			if !ok {
+				writeFailed(w, http.StatusUnauthorized, "", "User ID not found in context")
+				return
+			}
+
+			// Read the body so it can be hashed, then hand it on to the handler
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentRequestBytes))
+			if err != nil {
+				writeFailed(w, http.StatusBadRequest, "", "Invalid request payload")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			hash := sha256.New()
+			hash.Write([]byte(r.Method + "\n" + r.URL.Path + "\n"))
+			hash.Write(body)
+			requestHash := hex.EncodeToString(hash.Sum(nil))
+
+			// Stored timestamps keep microseconds, so the lease is compared as stored
+			now := time.Now()
+			lockedUntil := now.Add(lease).Truncate(time.Microsecond)
+			record, created, err := claimIdempotencyKey(db, models.IdempotencyKey{
+				UserUID:     userID,
+				Key:         key,
+				Method:      r.Method,
+				Path:        r.URL.Path,
+				RequestHash: requestHash,
+				Status:      "IN_PROGRESS",
+				ExpiresAt:   now.Add(ttl),
+				LockedUntil: &lockedUntil,
+			})
+			if err != nil {
+				log.Println("Error claiming idempotency key:", err)
+				writeFailed(w, http.StatusInternalServerError, "", "Failed to check Idempotency-Key")
+				return
+			}
+
+			if !created {
+				switch {
+				case record.RequestHash != requestHash:
+					writeFailed(w, http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED", "Idempotency-Key was already used for a different request")
+				case record.Status != "COMPLETED":
+					writeFailed(w, http.StatusConflict, "IDEMPOTENCY_REQUEST_IN_PROGRESS", "A request with this Idempotency-Key is still being processed")
+				default:
+					if record.ContentType != "" {
+						w.Header().Set("Content-Type", record.ContentType)
+					}
+					w.Header().Set(idempotentReplayedHeader, "true")
+					w.WriteHeader(record.ResponseCode)
+					w.Write(record.ResponseBody)
+				}
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w}
+			claim := idempotencyClaim{id: record.ID, lockedUntil: lockedUntil}
+			next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), idempotencyClaimKey{}, claim)))
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+
+			// Leave the key alone when the handler completed it or a retry took it over
+			owned := db.Model(&models.IdempotencyKey{}).Where("id = ? AND status = ? AND locked_until = ?", record.ID, "IN_PROGRESS", lockedUntil)
+
+			// Server errors are not stored so the client can retry with the same key
+			if recorder.status >= http.StatusInternalServerError {
+				if err := owned.Unscoped().Delete(&models.IdempotencyKey{}).Error; err != nil {
+					log.Println("Error releasing idempotency key:", err)
+				}
+				return
+			}
+
+			if err := owned.Updates(map[string]interface{}{
+				"status":        "COMPLETED",
+				"response_code": recorder.status,
+				"response_body": recorder.body.Bytes(),
+				"content_type":  w.Header().Get("Content-Type"),
+			}).Error; err != nil {
+				log.Println("Error storing idempotent response:", err)
+			}
+		})
+	}
+}
+
+// claimIdempotencyKey inserts the key, or returns the existing record of the key when
+// another request claimed it first. Expired records are replaced, and a request whose
+// lease lapsed before it completed is taken over by a retry with the same body.
+func claimIdempotencyKey(db *gorm.DB, record models.IdempotencyKey) (models.IdempotencyKey, bool, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		claim := record
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&claim)
+		if result.Error != nil {
+			return models.IdempotencyKey{}, false, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return claim, true, nil
+		}
+
+		var existing models.IdempotencyKey
+		if err := db.Where("user_uid = ? AND key = ?", record.UserUID, record.Key).First(&existing).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue // Released in the meantime, try to claim it again
+			}
+			return models.IdempotencyKey{}, false, err
+		}
+		if existing.ExpiresAt.After(time.Now()) {
+			if existing.Status != "IN_PROGRESS" || existing.RequestHash != record.RequestHash {
+				return existing, false, nil
+			}
+			return takeOverIdempotencyKey(db, existing, *record.LockedUntil)
+		}
+
+		if err := db.Unscoped().Where("id = ? AND expires_at <= ?", existing.ID, time.Now()).Delete(&models.IdempotencyKey{}).Error; err != nil {
+			return models.IdempotencyKey{}, false, err
+		}
+	}
+	return models.IdempotencyKey{}, false, errors.New("could not claim idempotency key")
+}
+
+// takeOverIdempotencyKey gives an IN_PROGRESS record to a new request when its lease
+// lapsed. Of several retries only one takes it over; the others get the record as it is.
+func takeOverIdempotencyKey(db *gorm.DB, existing models.IdempotencyKey, lockedUntil time.Time) (models.IdempotencyKey, bool, error) {
+	result := db.Model(&models.IdempotencyKey{}).
+		Where("id = ? AND status = ? AND (locked_until IS NULL OR locked_until <= ?)", existing.ID, "IN_PROGRESS", time.Now()).
+		Update("locked_until", lockedUntil)
+	if result.Error != nil {
+		return models.IdempotencyKey{}, false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Completed or taken over by another retry in the meantime
+		var current models.IdempotencyKey
+		if err := db.First(&current, existing.ID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return existing, false, nil // Released, the client may retry
+			}
+			return models.IdempotencyKey{}, false, err
+		}
+		return current, false, nil
+	}
+	existing.LockedUntil = &lockedUntil
+	return existing, true, nil
+}
+
+// CompleteIdempotencyKey stores the response to a request sent with an Idempotency-Key
+// in tx, so it commits together with the changes the request made. Handlers behind
+// IdempotencyMiddleware call it before committing; without a key it does nothing.
+// When a retry took the key over it fails, so only one of the two requests commits.
+func CompleteIdempotencyKey(ctx context.Context, tx *gorm.DB, status int, response interface{}) error {
+	claim, ok := ctx.Value(idempotencyClaimKey{}).(idempotencyClaim)
+	if !ok {
+		return nil
+	}
+
+	// Encoded as the handler writes it
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(response); err != nil {
+		return err
+	}
+
+	result := tx.Model(&models.IdempotencyKey{}).
+		Where("id = ? AND status = ? AND locked_until = ?", claim.id, "IN_PROGRESS", claim.lockedUntil).
+		Updates(map[string]interface{}{
+			"status":        "COMPLETED",
+			"response_code": status,
+			"response_body": body.Bytes(),
+			"content_type":  "application/json",
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errIdempotencyKeyTakenOver
+	}
+	return nil
+}
+
+func writeFailed(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode(code, message))
+}
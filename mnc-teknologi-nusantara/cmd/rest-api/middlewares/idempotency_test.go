@@ -0,0 +1,121 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/database/databasetest"
+	"mnctech-restapi/cmd/rest-api/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestIdempotencyLeaseTakeover(t *testing.T) {
+	db := databasetest.Open(t)
+	userUID := uuid.New().String()
+	t.Cleanup(func() {
+		db.Unscoped().Where("user_uid = ?", userUID).Delete(&models.IdempotencyKey{})
+	})
+
+	calls := 0
+	handler := IdempotencyMiddleware(db, time.Hour, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	send := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/topup", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userUID))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A request that died while holding the key
+	if code := send(`{"amount":"10"}`); code != http.StatusCreated {
+		t.Fatalf("first request: got status %d", code)
+	}
+	db.Model(&models.IdempotencyKey{}).Where("user_uid = ?", userUID).Update("status", "IN_PROGRESS")
+
+	if code := send(`{"amount":"10"}`); code != http.StatusConflict {
+		t.Errorf("retry within the lease: got status %d, want %d", code, http.StatusConflict)
+	}
+
+	db.Model(&models.IdempotencyKey{}).Where("user_uid = ?", userUID).Update("locked_until", time.Now().Add(-time.Second))
+	if code := send(`{"amount":"20"}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("different body after the lease: got status %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+	if code := send(`{"amount":"10"}`); code != http.StatusCreated {
+		t.Errorf("retry after the lease: got status %d, want %d", code, http.StatusCreated)
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+
+	// The retry completed the key, so the next one is replayed
+	if code := send(`{"amount":"10"}`); code != http.StatusCreated || calls != 2 {
+		t.Errorf("replay: got status %d after %d calls, want %d after 2", code, calls, http.StatusCreated)
+	}
+}
+
+func TestIdempotencyKeyCompletedInTransaction(t *testing.T) {
+	db := databasetest.Open(t)
+	userUID := uuid.New().String()
+	t.Cleanup(func() {
+		db.Unscoped().Where("user_uid = ?", userUID).Delete(&models.IdempotencyKey{})
+	})
+
+	calls := 0
+	var completeErr error
+	handler := IdempotencyMiddleware(db, time.Hour, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			// The change commits, then writing the response fails
+			completeErr = CompleteIdempotencyKey(r.Context(), db, http.StatusCreated, map[string]string{"status": "SUCCESS"})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// A retry took the key over while this request was still running
+		db.Model(&models.IdempotencyKey{}).Where("user_uid = ?", userUID).Update("locked_until", time.Now().Add(time.Hour))
+		completeErr = CompleteIdempotencyKey(r.Context(), db, http.StatusCreated, map[string]string{"status": "SUCCESS"})
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	send := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/topup", strings.NewReader(`{"amount":"10"}`))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userUID))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	send("key-1")
+	if completeErr != nil {
+		t.Fatalf("complete: %v", completeErr)
+	}
+	rec := send("key-1")
+	if rec.Code != http.StatusCreated || calls != 1 {
+		t.Errorf("retry after a committed request: got status %d after %d calls, want %d after 1", rec.Code, calls, http.StatusCreated)
+	}
+	if want := "{\"status\":\"SUCCESS\"}\n"; rec.Body.String() != want {
+		t.Errorf("got replayed body %q, want %q", rec.Body.String(), want)
+	}
+
+	send("key-2")
+	if !errors.Is(completeErr, errIdempotencyKeyTakenOver) {
+		t.Errorf("complete after a takeover: got error %v, want %v", completeErr, errIdempotencyKeyTakenOver)
+	}
+	var record models.IdempotencyKey
+	if err := db.Where("user_uid = ? AND key = ?", userUID, "key-2").First(&record).Error; err != nil {
+		t.Fatalf("load key: %v", err)
+	}
+	if record.Status != "IN_PROGRESS" {
+		t.Errorf("got status %s, want the key left to the retry", record.Status)
+	}
+}
@@ -2,17 +2,20 @@ package middlewares
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/models"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5" // Adjust based on your JWT library
+	"gorm.io/gorm"
 )
 
-// JWTMiddleware checks for a valid JWT token and extracts the user ID.
-func JWTMiddleware(accessTokenKey []byte) func(http.Handler) http.Handler {
+// JWTMiddleware checks for a valid JWT token whose session has not been revoked
+// and extracts the user ID and session ID.
+func JWTMiddleware(keyManager *auth.KeyManager, db *gorm.DB) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Get the JWT token from the Authorization header
@@ -31,9 +34,7 @@ func JWTMiddleware(accessTokenKey []byte) func(http.Handler) http.Handler {
 			tokenString := parts[1]
 
 			// Parse the token
-			token, err := jwt.ParseWithClaims(tokenString, &auth.CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-				return accessTokenKey, nil
-			})
+			token, err := jwt.ParseWithClaims(tokenString, &auth.CustomClaims{}, keyManager.Keyfunc)
 
 			if err != nil {
 				log.Println("Error parsing token:", err)
@@ -43,11 +44,28 @@ func JWTMiddleware(accessTokenKey []byte) func(http.Handler) http.Handler {
 
 			// Check if the token is valid and extract claims
 			if claims, ok := token.Claims.(*auth.CustomClaims); ok && token.Valid {
-				// Store UID in context
+				// Reject tokens whose session has been revoked or has expired
+				if claims.SessionID == "" {
+					http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+					return
+				}
+				var sessionCount int64
+				if err := db.Model(&models.Session{}).
+					Where("uid = ? AND revoked_at IS NULL AND expires_at > ?", claims.SessionID, time.Now()).
+					Count(&sessionCount).Error; err != nil {
+					log.Println("Error checking session:", err)
+					http.Error(w, "Failed to check session", http.StatusInternalServerError)
+					return
+				}
+				if sessionCount == 0 {
+					http.Error(w, "Session has been revoked", http.StatusUnauthorized)
+					return
+				}
+
+				// Store UID and session ID in context
 				ctx := context.WithValue(r.Context(), auth.UserIDKey, claims.UID)
+				ctx = context.WithValue(ctx, auth.SessionIDKey, claims.SessionID)
 				r = r.WithContext(ctx) // Update the request with the new context
-
-				fmt.Printf("Claims: %+v\n", claims.UID) // Print claims for debugging
 			} else {
 				http.Error(w, "Invalid token claims", http.StatusUnauthorized)
 				return
@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// MerchantAPIKeyHeader carries the API key of a merchant.
+const MerchantAPIKeyHeader = "X-API-Key"
+
+// MerchantAPIKey authenticates merchants by the API key in the X-API-Key header
+// and stores the UID of the merchant in the context. Suspended merchants are
+// refused with 403, so they can neither see their payments nor refund, capture
+// or release until an admin makes them ACTIVE again.
+func MerchantAPIKey(db *gorm.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			merchant, err := auth.MerchantByAPIKey(db, r.Header.Get(MerchantAPIKeyHeader))
+			if errors.Is(err, auth.ErrInvalidAPIKey) {
+				w.Header().Set("Content-Type", "application/json")
+				writeFailed(w, http.StatusUnauthorized, "INVALID_API_KEY", "API key is missing or invalid")
+				return
+			}
+			if err != nil {
+				log.Println("Error checking API key:", err)
+				w.Header().Set("Content-Type", "application/json")
+				writeFailed(w, http.StatusInternalServerError, "", "Failed to check API key")
+				return
+			}
+			if merchant.Status != "ACTIVE" {
+				w.Header().Set("Content-Type", "application/json")
+				writeFailed(w, http.StatusForbidden, "MERCHANT_NOT_ACTIVE", "Merchant is suspended")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), auth.MerchantIDKey, merchant.UID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
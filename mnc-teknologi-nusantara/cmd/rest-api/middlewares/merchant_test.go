@@ -0,0 +1,51 @@
+package middlewares
+
+import (
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/database/databasetest"
+	"mnctech-restapi/cmd/rest-api/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMerchantAPIKeyRefusesSuspendedMerchant(t *testing.T) {
+	db := databasetest.Open(t)
+	keyID, secret := uuid.New().String(), uuid.New().String()
+	merchant := models.Merchant{
+		UID:                 uuid.New().String(),
+		Name:                "Test merchant",
+		Currency:            "IDR",
+		Status:              "SUSPENDED",
+		APIKeyID:            keyID,
+		APIKeyHash:          auth.HashToken(secret),
+		PayoutBankCode:      "014",
+		PayoutAccountNumber: "1234567890",
+		PayoutAccountName:   "Test merchant",
+	}
+	if err := db.Create(&merchant).Error; err != nil {
+		t.Fatalf("create merchant: %v", err)
+	}
+	t.Cleanup(func() { db.Unscoped().Delete(&merchant) })
+
+	handler := MerchantAPIKey(db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/merchant/payments", nil)
+		req.Header.Set(MerchantAPIKeyHeader, keyID+"."+secret)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send(); code != http.StatusForbidden {
+		t.Errorf("suspended merchant: got status %d, want %d", code, http.StatusForbidden)
+	}
+	db.Model(&merchant).Update("status", "ACTIVE")
+	if code := send(); code != http.StatusOK {
+		t.Errorf("active merchant: got status %d, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,35 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"mnctech-restapi/cmd/rest-api/auth"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/responses"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// RequireRole only lets users with one of the roles through. It must run after
+// JWTMiddleware; the role is read from the database so a change applies at once.
+func RequireRole(db *gorm.DB, roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, _ := r.Context().Value(auth.UserIDKey).(string)
+
+			var user models.User
+			if err := db.Select("role").Where("uid = ?", userID).First(&user).Error; err == nil {
+				for _, role := range roles {
+					if user.Role == role {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(responses.NewFailedResponseWithCode("FORBIDDEN", "You are not allowed to do this"))
+		})
+	}
+}
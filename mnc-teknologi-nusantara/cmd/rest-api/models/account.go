@@ -1,14 +1,23 @@
 package models
 
 import (
+	"mnctech-restapi/cmd/rest-api/money"
+
 	"gorm.io/gorm"
 )
 
+// UserAccount is a wallet of a user. A user may open several named wallets, one
+// of which is the default. Closing a wallet soft deletes it.
 type UserAccount struct {
 	gorm.Model
-	UserID         uint    `gorm:"not null;foreignKey:UserID;references:ID"`
-	CurrentBalance float64 `json:"current_balance" gorm:"not null;default:0"`
-	LastBalance    float64 `json:"last_balance" gorm:"not null;default:0"`
+	UID            string      `gorm:"type:uuid;uniqueIndex"`
+	UserID         uint        `gorm:"not null;foreignKey:UserID;references:ID"`
+	Name           string      `json:"name" gorm:"not null;default:'Main'"`
+	IsDefault      bool        `json:"is_default" gorm:"not null;default:false"`            // Receives transfers and is used when no account is chosen
+	Currency       string      `json:"currency" gorm:"type:char(3);not null;default:'IDR'"` // ISO 4217 code of the balance
+	CurrentBalance money.Money `json:"current_balance" gorm:"not null;default:0"`           // In minor units
+	LastBalance    money.Money `json:"last_balance" gorm:"not null;default:0"`              // In minor units
+	HeldBalance    money.Money `json:"held_balance" gorm:"not null;default:0"`              // Part of the current balance reserved by active holds
 
 	User User `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;foreignKey:UserID"`
 }
@@ -16,16 +25,17 @@ type UserAccount struct {
 // AccountTransaction represents a transaction for a user's account.
 type AccountTransactionLog struct {
 	gorm.Model
-	UserAccountID       uint    `json:"user_account_id" gorm:"not null"` // Foreign key referencing UserAccount
-	Amount              float64 `json:"amount" gorm:"not null"`          // The amount of the transaction
-	TransactionType     string  `json:"transaction_type"`                // Type: "DEBIT", "CREDIT" etc.
-	TransactionCategory string  `json:"transaction_category"`            // Type: "TOPUP", "PAYMENT", "TRANSFER" etc.
-	TransactionReff     string  `json:"transaction_reff"`
-	BalanceBefore       float64 `json:"balance_before"` // Balance before the transaction
-	BalanceAfter        float64 `json:"balance_after"`  // Balance after the transaction
-	Status              string  `json:"status" gorm:"default:'SUCCESS'"`
-	Remarks             string  `json:"remarks"`
-	ErrMessage          string  `json:"err_message"`
+	UserAccountID       uint        `json:"user_account_id" gorm:"not null"` // Foreign key referencing UserAccount
+	Amount              money.Money `json:"amount" gorm:"not null"`          // The amount of the transaction
+	Currency            string      `json:"currency" gorm:"type:char(3);not null;default:'IDR'"`
+	TransactionType     string      `json:"transaction_type"`     // Type: "DEBIT", "CREDIT" etc.
+	TransactionCategory string      `json:"transaction_category"` // Type: "TOPUP", "PAYMENT", "TRANSFER" etc.
+	TransactionReff     string      `json:"transaction_reff" gorm:"index"`
+	BalanceBefore       money.Money `json:"balance_before"` // Balance before the transaction
+	BalanceAfter        money.Money `json:"balance_after"`  // Balance after the transaction
+	Status              string      `json:"status" gorm:"default:'SUCCESS'"`
+	Remarks             string      `json:"remarks"`
+	ErrMessage          string      `json:"err_message"`
 
 	UserAccount UserAccount `gorm:"foreignKey:UserAccountID"`
 }
@@ -2,6 +2,8 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -14,6 +16,73 @@ type User struct {
 	LastName    string `json:"last_name" gorm:"not null;default:''"`
 	Address     string `json:"address" gorm:"not null;default:''"`
 	Pin         string `json:"pin" gorm:"not null;default:''"`
+	Role        string `json:"role" gorm:"not null;default:'USER'"`      // Type: "USER", "ADMIN"
+	KYCTier     string `json:"kyc_tier" gorm:"not null;default:'BASIC'"` // Type: "BASIC", "VERIFIED"
 
 	Accounts []UserAccount `gorm:"foreignKey:UserID"`
 }
+
+// RefreshToken tracks an issued refresh token so it can be rotated and revoked.
+type RefreshToken struct {
+	gorm.Model
+	UID       string     `gorm:"type:uuid;uniqueIndex"`    // jti claim of the signed token
+	FamilyID  string     `gorm:"type:uuid;index;not null"` // UID of the Session the token was rotated within
+	UserID    uint       `gorm:"not null;index"`           // Foreign key referencing User
+	ExpiresAt time.Time  `gorm:"not null"`                 // Expiry copied from the token claims
+	UsedAt    *time.Time `json:"used_at"`                  // Set once the token has been exchanged
+	RevokedAt *time.Time `json:"revoked_at"`               // Set when the token family is revoked
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+// Session represents a login of a user on one device.
+type Session struct {
+	gorm.Model
+	UID        string     `gorm:"type:uuid;uniqueIndex"` // sid claim of the tokens issued for the session
+	UserID     uint       `gorm:"not null;index"`        // Foreign key referencing User
+	UserAgent  string     `json:"user_agent" gorm:"not null;default:''"`
+	IPAddress  string     `json:"ip_address" gorm:"not null;default:''"`
+	LastSeenAt time.Time  `json:"last_seen_at"` // Last login or token refresh
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	RevokedAt  *time.Time `json:"revoked_at"` // Set on logout or when the session is revoked
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+// LoginThrottle counts failed login attempts for a phone number or a client IP.
+type LoginThrottle struct {
+	gorm.Model
+	Scope        string     `json:"scope" gorm:"not null;uniqueIndex:idx_login_throttles_scope_key"` // Type: "PHONE", "IP"
+	Key          string     `json:"key" gorm:"not null;uniqueIndex:idx_login_throttles_scope_key"`   // Phone number or IP address
+	FailedCount  int        `json:"failed_count" gorm:"not null;default:0"`                          // Failed and running attempts since the last lock or success
+	LockCount    int        `json:"lock_count" gorm:"not null;default:0"`                            // Consecutive locks, drives the backoff
+	LockedUntil  *time.Time `json:"locked_until"`
+	LastFailedAt *time.Time `json:"last_failed_at"`
+}
+
+// LoginLockEvent records a lock applied after too many failed login attempts.
+type LoginLockEvent struct {
+	gorm.Model
+	Scope       string    `json:"scope" gorm:"not null;index:idx_login_lock_events_scope_key"`
+	Key         string    `json:"key" gorm:"not null;index:idx_login_lock_events_scope_key"`
+	LockCount   int       `json:"lock_count" gorm:"not null"`
+	IPAddress   string    `json:"ip_address" gorm:"not null;default:''"` // Client that triggered the lock
+	LockedUntil time.Time `json:"locked_until" gorm:"not null"`
+}
+
+// OTPCode is a one-time password sent to a phone number to confirm a sensitive operation.
+type OTPCode struct {
+	gorm.Model
+	UID         string     `gorm:"type:uuid;uniqueIndex"`
+	UserID      uint       `gorm:"not null;index:idx_otp_codes_user_purpose"`                // Foreign key referencing User
+	Purpose     string     `json:"purpose" gorm:"not null;index:idx_otp_codes_user_purpose"` // Type: "PIN_RESET" etc.
+	Destination string     `json:"destination" gorm:"not null"`                              // Phone number the code was sent to
+	CodeHash    string     `gorm:"not null"`
+	Attempts    int        `json:"attempts" gorm:"not null;default:0"` // Wrong codes entered so far
+	ExpiresAt   time.Time  `json:"expires_at" gorm:"not null"`
+	VerifiedAt  *time.Time `json:"verified_at"`
+	TokenHash   string     `gorm:"index"` // Hash of the token handed out after verification, if any
+	ConsumedAt  *time.Time `json:"consumed_at"`
+
+	User User `gorm:"foreignKey:UserID"`
+}
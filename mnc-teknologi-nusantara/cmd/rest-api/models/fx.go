@@ -0,0 +1,44 @@
+package models
+
+import (
+	"mnctech-restapi/cmd/rest-api/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FxQuote is a conversion between two accounts of a user at a rate held until
+// ExpiresAt. Executing it creates an FxConversion.
+type FxQuote struct {
+	gorm.Model
+	UID                  string      `gorm:"type:uuid;uniqueIndex"`
+	UserID               uint        `json:"user_id" gorm:"not null;index"`
+	SourceAccountID      uint        `json:"source_account_id" gorm:"not null"`
+	DestinationAccountID uint        `json:"destination_account_id" gorm:"not null"`
+	SourceCurrency       string      `json:"source_currency" gorm:"type:char(3);not null"`
+	DestinationCurrency  string      `json:"destination_currency" gorm:"type:char(3);not null"`
+	SourceAmount         money.Money `json:"source_amount" gorm:"not null"`
+	DestinationAmount    money.Money `json:"destination_amount" gorm:"not null"`
+	MidRate              string      `json:"mid_rate" gorm:"type:numeric(30,10);not null"` // Mid-market rate from the provider
+	Spread               string      `json:"spread" gorm:"type:numeric(12,10);not null"`   // Fraction taken off the mid rate
+	Rate                 string      `json:"rate" gorm:"type:numeric(30,10);not null"`     // Rate applied to the source amount
+	ExpiresAt            time.Time   `json:"expires_at" gorm:"not null"`
+	ExecutedAt           *time.Time  `json:"executed_at"` // Set by the conversion that used the quote
+}
+
+// FxConversion moved money between two accounts of a user in different currencies
+// at the rate and spread of its quote.
+type FxConversion struct {
+	gorm.Model
+	UID                  string      `gorm:"type:uuid;uniqueIndex"`
+	QuoteID              uint        `json:"quote_id" gorm:"not null;uniqueIndex"`
+	SourceAccountID      uint        `json:"source_account_id" gorm:"not null;index"`
+	DestinationAccountID uint        `json:"destination_account_id" gorm:"not null;index"`
+	SourceCurrency       string      `json:"source_currency" gorm:"type:char(3);not null"`
+	DestinationCurrency  string      `json:"destination_currency" gorm:"type:char(3);not null"`
+	SourceAmount         money.Money `json:"source_amount" gorm:"not null"`
+	DestinationAmount    money.Money `json:"destination_amount" gorm:"not null"`
+	MidRate              string      `json:"mid_rate" gorm:"type:numeric(30,10);not null"`
+	Spread               string      `json:"spread" gorm:"type:numeric(12,10);not null"`
+	Rate                 string      `json:"rate" gorm:"type:numeric(30,10);not null"`
+}
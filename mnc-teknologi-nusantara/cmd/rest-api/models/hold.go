@@ -0,0 +1,30 @@
+package models
+
+import (
+	"mnctech-restapi/cmd/rest-api/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Hold reserves part of the balance of a user account for a merchant until the
+// final amount is known. While active its amount counts in the held balance of
+// the account; capturing it pays the merchant, releasing or expiring frees it.
+type Hold struct {
+	gorm.Model
+	UID                  string      `gorm:"type:uuid;uniqueIndex"`
+	UserAccountID        uint        `json:"user_account_id" gorm:"not null;index"`
+	MerchantID           uint        `json:"merchant_id" gorm:"not null;index"`
+	Amount               money.Money `json:"amount" gorm:"not null"`                    // Reserved
+	CapturedAmount       money.Money `json:"captured_amount" gorm:"not null;default:0"` // Paid to the merchant, the rest was released
+	Currency             string      `json:"currency" gorm:"type:char(3);not null"`
+	Status               string      `json:"status" gorm:"not null;default:'ACTIVE';index"` // Type: "ACTIVE", "CAPTURED", "RELEASED", "EXPIRED"
+	Remarks              string      `json:"remarks"`
+	ExpiresAt            time.Time   `json:"expires_at" gorm:"not null;index"`
+	PaymentTransactionID *uint       `json:"payment_transaction_id"` // Set once captured
+	ClosedAt             *time.Time  `json:"closed_at"`
+
+	UserAccount        UserAccount         `gorm:"foreignKey:UserAccountID"`
+	Merchant           Merchant            `gorm:"foreignKey:MerchantID"`
+	PaymentTransaction *PaymentTransaction `gorm:"foreignKey:PaymentTransactionID"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// IdempotencyKey stores the outcome of a request sent with an Idempotency-Key header,
+// so a retry of the same request is answered without executing it again.
+type IdempotencyKey struct {
+	gorm.Model
+	UserUID      string     `json:"user_uid" gorm:"type:uuid;not null;uniqueIndex:idx_idempotency_keys_user_key"`
+	Key          string     `json:"key" gorm:"not null;uniqueIndex:idx_idempotency_keys_user_key"`
+	Method       string     `json:"method" gorm:"not null"`
+	Path         string     `json:"path" gorm:"not null"`
+	RequestHash  string     `json:"request_hash" gorm:"not null"`                 // SHA-256 of method, path and body
+	Status       string     `json:"status" gorm:"not null;default:'IN_PROGRESS'"` // Status of the request (e.g., IN_PROGRESS, COMPLETED)
+	ResponseCode int        `json:"response_code"`                                // Stored once COMPLETED
+	ResponseBody []byte     `json:"response_body"`                                // Stored once COMPLETED
+	ContentType  string     `json:"content_type" gorm:"not null;default:''"`      // Stored once COMPLETED
+	ExpiresAt    time.Time  `json:"expires_at" gorm:"not null"`                   // The key may be reused after this time
+	LockedUntil  *time.Time `json:"locked_until"`                                 // A retry may take over an IN_PROGRESS request after this time
+}
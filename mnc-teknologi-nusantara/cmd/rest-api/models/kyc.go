@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// KYCSubmission is the identity a user submitted to be verified.
+type KYCSubmission struct {
+	gorm.Model
+	UID          string     `gorm:"type:uuid;uniqueIndex"`
+	UserID       uint       `json:"user_id" gorm:"not null;index"`
+	NIK          string     `json:"nik" gorm:"not null;index"`                      // Indonesian identity number, 16 digits, unique among APPROVED submissions
+	IDCardKey    string     `json:"id_card_key" gorm:"not null"`                    // Storage key of the identity card photo
+	SelfieKey    string     `json:"selfie_key" gorm:"not null"`                     // Storage key of the selfie holding the card
+	Status       string     `json:"status" gorm:"not null;default:'PENDING';index"` // Type: "PENDING", "APPROVED", "REJECTED"
+	ReviewerID   *uint      `json:"reviewer_id"`                                    // Admin user that reviewed the submission
+	ReviewReason string     `json:"review_reason" gorm:"not null;default:''"`
+	ReviewedAt   *time.Time `json:"reviewed_at"`
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+// KYCTierChange records a change of the KYC tier of a user.
+type KYCTierChange struct {
+	gorm.Model
+	UserID       uint   `json:"user_id" gorm:"not null;index"`
+	SubmissionID *uint  `json:"submission_id"` // KYCSubmission that caused the change, if any
+	FromTier     string `json:"from_tier" gorm:"not null"`
+	ToTier       string `json:"to_tier" gorm:"not null"`
+	ReviewerID   uint   `json:"reviewer_id" gorm:"not null"` // Admin user that made the change
+	Reason       string `json:"reason" gorm:"not null;default:''"`
+}
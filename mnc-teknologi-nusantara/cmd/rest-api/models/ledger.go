@@ -0,0 +1,41 @@
+package models
+
+import (
+	"mnctech-restapi/cmd/rest-api/money"
+
+	"gorm.io/gorm"
+)
+
+// LedgerAccount is an account of the double-entry ledger. Every UserAccount and
+// Merchant has one, and system accounts stand for money outside of the wallets
+// (top-up sources, payouts).
+type LedgerAccount struct {
+	gorm.Model
+	Code          string      `json:"code" gorm:"not null;uniqueIndex"`   // e.g. "USER:42", "MERCHANT:7", "SYSTEM:TOPUP_SOURCE"
+	Type          string      `json:"type" gorm:"not null"`               // Type: "USER", "MERCHANT", "SYSTEM"
+	UserAccountID *uint       `json:"user_account_id" gorm:"uniqueIndex"` // Set for USER accounts
+	Balance       money.Money `json:"balance" gorm:"not null;default:0"`  // Sum of the postings, kept for USER accounts only
+}
+
+// JournalEntry groups the postings of one business transaction. The amounts of its
+// postings always add up to zero.
+type JournalEntry struct {
+	gorm.Model
+	UID                 string `gorm:"type:uuid;uniqueIndex"`
+	TransactionReff     string `json:"transaction_reff" gorm:"not null;index"` // UID of the top-up, payment or transfer
+	TransactionCategory string `json:"transaction_category" gorm:"not null"`   // Type: "TOPUP", "PAYMENT", "TRANSFER" etc.
+	Description         string `json:"description" gorm:"not null;default:''"`
+
+	Postings []Posting `gorm:"foreignKey:JournalEntryID"`
+}
+
+// Posting moves an amount into (positive) or out of (negative) a ledger account.
+// The balance of a ledger account is the sum of its postings.
+type Posting struct {
+	gorm.Model
+	JournalEntryID  uint        `json:"journal_entry_id" gorm:"not null;index"`
+	LedgerAccountID uint        `json:"ledger_account_id" gorm:"not null;index"`
+	Amount          money.Money `json:"amount" gorm:"not null"`
+
+	LedgerAccount LedgerAccount `gorm:"foreignKey:LedgerAccountID"`
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"mnctech-restapi/cmd/rest-api/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Merchant receives payments made with /pay. Payments collect in its settlement
+// account in the ledger until a daily settlement pays them out to its payout account.
+type Merchant struct {
+	gorm.Model
+	UID                 string `gorm:"type:uuid;uniqueIndex"`
+	Name                string `json:"name" gorm:"not null"`
+	Currency            string `json:"currency" gorm:"type:char(3);not null;default:'IDR'"` // Currency of the payments it accepts
+	Status              string `json:"status" gorm:"not null;default:'ACTIVE'"`             // Type: "ACTIVE", "SUSPENDED"
+	APIKeyID            string `gorm:"not null;uniqueIndex"`                                // Public part of the API key
+	APIKeyHash          string `gorm:"not null"`                                            // SHA-256 of the secret part of the API key
+	PayoutBankCode      string `json:"payout_bank_code" gorm:"not null"`
+	PayoutAccountNumber string `json:"payout_account_number" gorm:"not null"`
+	PayoutAccountName   string `json:"payout_account_name" gorm:"not null"`
+	City                string `json:"city" gorm:"not null;default:'JAKARTA'"`       // Shown in its QR codes
+	CategoryCode        string `json:"category_code" gorm:"not null;default:'5999'"` // ISO 18245 merchant category code
+	PostalCode          string `json:"postal_code" gorm:"not null;default:''"`
+}
+
+// MerchantSettlement pays out the payments of a merchant, less their refunds,
+// that were not settled before the end of its business day.
+type MerchantSettlement struct {
+	gorm.Model
+	UID                 string      `gorm:"type:uuid;uniqueIndex"`
+	MerchantID          uint        `json:"merchant_id" gorm:"not null;uniqueIndex:idx_merchant_settlements_day"`
+	BusinessDate        time.Time   `json:"business_date" gorm:"type:date;not null;uniqueIndex:idx_merchant_settlements_day"`
+	Currency            string      `json:"currency" gorm:"type:char(3);not null"`
+	GrossAmount         money.Money `json:"gross_amount" gorm:"not null"`  // Payments
+	RefundAmount        money.Money `json:"refund_amount" gorm:"not null"` // Refunds of payments deducted from them
+	NetAmount           money.Money `json:"net_amount" gorm:"not null"`    // Paid out
+	PaymentCount        int         `json:"payment_count" gorm:"not null"`
+	RefundCount         int         `json:"refund_count" gorm:"not null"`
+	PayoutBankCode      string      `json:"payout_bank_code" gorm:"not null"` // Payout account at the time of the settlement
+	PayoutAccountNumber string      `json:"payout_account_number" gorm:"not null"`
+	PayoutAccountName   string      `json:"payout_account_name" gorm:"not null"`
+
+	Merchant Merchant `gorm:"foreignKey:MerchantID"`
+}
+
+// MerchantQRCode is a dynamic QR code of a merchant, bound to an amount. It can
+// be paid once before it expires.
+type MerchantQRCode struct {
+	gorm.Model
+	Reference            string      `json:"reference" gorm:"not null;uniqueIndex"` // Reference label in the payload
+	MerchantID           uint        `json:"merchant_id" gorm:"not null;index"`
+	Amount               money.Money `json:"amount" gorm:"not null"`
+	Currency             string      `json:"currency" gorm:"type:char(3);not null"`
+	BillNumber           string      `json:"bill_number" gorm:"not null;default:''"` // Invoice number given by the merchant
+	ExpiresAt            time.Time   `json:"expires_at" gorm:"not null"`
+	PaymentTransactionID *uint       `json:"payment_transaction_id"` // Set once paid
+	PaidAt               *time.Time  `json:"paid_at"`
+
+	Merchant           Merchant            `gorm:"foreignKey:MerchantID"`
+	PaymentTransaction *PaymentTransaction `gorm:"foreignKey:PaymentTransactionID"`
+}
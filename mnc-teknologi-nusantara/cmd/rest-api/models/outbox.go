@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutboxMessage is a message written in the same database transaction as the
+// change it announces, and published to the broker by the outbox relay.
+type OutboxMessage struct {
+	gorm.Model
+	UID           string     `gorm:"type:uuid;uniqueIndex"`                 // Message ID seen by consumers
+	Kind          string     `json:"kind" gorm:"not null"`                  // Type: "QUEUE", "EVENT"
+	Destination   string     `json:"destination" gorm:"not null"`           // Queue name, or routing key of an event
+	Payload       []byte     `json:"payload" gorm:"not null"`               // Message body
+	Attempts      int        `json:"attempts" gorm:"not null;default:0"`    // Failed publish attempts
+	LastError     string     `json:"last_error" gorm:"not null;default:''"` // Error of the last failed attempt
+	PublishedAt   *time.Time `json:"published_at" gorm:"index"`             // Nil until the broker confirmed the message
+	NextAttemptAt *time.Time `json:"next_attempt_at"`                       // Not retried before this time after a failed attempt
+	FailedAt      *time.Time `json:"failed_at"`                             // Set when the relay gave up, clear it to retry
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"mnctech-restapi/cmd/rest-api/money"
+
+	"gorm.io/gorm"
+)
+
+// Refund reverses all or part of a successful payment or transfer.
+type Refund struct {
+	gorm.Model
+	UID                 string      `gorm:"type:uuid;uniqueIndex"`
+	TransactionReff     string      `json:"transaction_reff" gorm:"not null;index"` // UID of the refunded payment or transfer
+	TransactionCategory string      `json:"transaction_category" gorm:"not null"`   // Type: "PAYMENT", "TRANSFER"
+	Amount              money.Money `json:"amount" gorm:"not null"`
+	Currency            string      `json:"currency" gorm:"type:char(3);not null;default:'IDR'"`
+	Reason              string      `json:"reason" gorm:"not null"`
+	InitiatorType       string      `json:"initiator_type" gorm:"not null"` // Type: "ADMIN", "MERCHANT"
+	InitiatorID         string      `json:"initiator_id" gorm:"not null"`   // UID of the admin user or merchant
+	MerchantID          *uint       `json:"merchant_id" gorm:"index"`       // Merchant of a refunded payment
+	SettlementID        *uint       `json:"settlement_id" gorm:"index"`     // MerchantSettlement it was deducted from
+}
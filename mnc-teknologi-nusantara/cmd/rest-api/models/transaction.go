@@ -1,16 +1,20 @@
 package models
 
 import (
+	"mnctech-restapi/cmd/rest-api/money"
+	"time"
+
 	"gorm.io/gorm"
 )
 
 // TopUpTransaction represents a transaction for a top-up.
 type TopUpTransaction struct {
 	gorm.Model
-	UID           string  `gorm:"type:uuid;uniqueIndex"`
-	UserAccountID uint    `json:"user_account_id" gorm:"not null"`          // Foreign key referencing UserAccount
-	Amount        float64 `json:"amount" gorm:"not null"`                   // The amount of the top-up
-	Status        string  `json:"status" gorm:"not null;default:'PENDING'"` // Status of the transaction (e.g., SUCCESS, FAILED)
+	UID           string      `gorm:"type:uuid;uniqueIndex"`
+	UserAccountID uint        `json:"user_account_id" gorm:"not null"` // Foreign key referencing UserAccount
+	Amount        money.Money `json:"amount" gorm:"not null"`          // The amount of the top-up
+	Currency      string      `json:"currency" gorm:"type:char(3);not null;default:'IDR'"`
+	Status        string      `json:"status" gorm:"not null;default:'PENDING'"` // Status of the transaction (e.g., SUCCESS, FAILED)
 
 	UserAccount UserAccount `gorm:"foreignKey:UserAccountID"`
 }
@@ -20,7 +24,10 @@ type PaymentTransaction struct {
 	gorm.Model
 	UID           string      `gorm:"type:uuid;uniqueIndex"`
 	UserAccountID uint        `json:"user_account_id" gorm:"not null"` // Foreign key referencing UserAccount
-	Amount        float64     `json:"amount" gorm:"not null"`          // The amount of the top-up
+	Amount        money.Money `json:"amount" gorm:"not null"`          // The amount of the top-up
+	Currency      string      `json:"currency" gorm:"type:char(3);not null;default:'IDR'"`
+	MerchantID    *uint       `json:"merchant_id" gorm:"index"`   // Payee, empty for payments made before merchants
+	SettlementID  *uint       `json:"settlement_id" gorm:"index"` // MerchantSettlement that paid it out
 	Remarks       string      `json:"remarks" gorm:"not null"`
 	Status        string      `json:"status" gorm:"not null;default:'PENDING'"` // Status of the transaction (e.g., SUCCESS, FAILED)
 	UserAccount   UserAccount `gorm:"foreignKey:UserAccountID"`
@@ -29,12 +36,37 @@ type PaymentTransaction struct {
 // PaymentTransaction represents a transaction for a payment.
 type TransferTransaction struct {
 	gorm.Model
-	UID           string      `gorm:"type:uuid;uniqueIndex"`
-	UserAccountID uint        `json:"user_account_id" gorm:"not null"` // Foreign key referencing UserAccount
-	TargetUserID  uint        `json:"target_account_id" gorm:"not null"`
-	Amount        float64     `json:"amount" gorm:"not null"` // The amount of the top-up
-	Remarks       string      `json:"remarks" gorm:"not null"`
-	Status        string      `json:"status" gorm:"not null;default:'PENDING'"` // Status of the transaction (e.g., SUCCESS, FAILED)
-	UserAccount   UserAccount `gorm:"foreignKey:UserAccountID"`
-	TargetAccount UserAccount `gorm:"foreignKey:UserAccountID"`
+	UID            string      `gorm:"type:uuid;uniqueIndex"`
+	UserAccountID  uint        `json:"user_account_id" gorm:"not null"` // Foreign key referencing UserAccount
+	TargetUserID   uint        `json:"target_account_id" gorm:"not null"`
+	Amount         money.Money `json:"amount" gorm:"not null"` // The amount of the top-up
+	Currency       string      `json:"currency" gorm:"type:char(3);not null;default:'IDR'"`
+	TargetAmount   money.Money `json:"target_amount" gorm:"not null;default:0"` // Amount credited to the target, in its currency
+	TargetCurrency string      `json:"target_currency" gorm:"type:char(3);not null;default:'IDR'"`
+	FxRate         *string     `json:"fx_rate" gorm:"type:numeric(30,10)"`   // Rate applied when the currencies differ
+	FxSpread       *string     `json:"fx_spread" gorm:"type:numeric(12,10)"` // Spread taken off the mid rate when the currencies differ
+	Remarks        string      `json:"remarks" gorm:"not null"`
+	Status         string      `json:"status" gorm:"not null;default:'PENDING'"` // Status of the transaction (e.g., SUCCESS, FAILED)
+	ErrMessage     string      `json:"err_message"`                              // Why a FAILED transfer was refunded
+	UserAccount    UserAccount `gorm:"foreignKey:UserAccountID"`
+	TargetAccount  UserAccount `gorm:"foreignKey:UserAccountID"`
+}
+
+// TransferInquiry is a recipient the sender has confirmed before transferring.
+// Its token must accompany the transfer and can be used once.
+type TransferInquiry struct {
+	gorm.Model
+	UID          string     `gorm:"type:uuid;uniqueIndex"`
+	UserID       uint       `json:"user_id" gorm:"not null;index"`  // Sender
+	TargetUserID uint       `json:"target_user_id" gorm:"not null"` // Recipient
+	TokenHash    string     `gorm:"not null;uniqueIndex"`           // SHA-256 of the inquiry token
+	ExpiresAt    time.Time  `json:"expires_at" gorm:"not null"`
+	ConsumedAt   *time.Time `json:"consumed_at"` // Set by the transfer that used the token
+}
+
+// TransferInquiryLookup records one recipient lookup of POST /transfer/inquiry,
+// found or not, so the lookups of a user can be rate limited.
+type TransferInquiryLookup struct {
+	gorm.Model
+	UserID uint `json:"user_id" gorm:"not null;index"` // Sender
 }
@@ -0,0 +1,215 @@
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Scale is the number of decimal places kept for every amount.
+const Scale = 2
+
+// unit is the number of minor units in one major unit (10^Scale).
+const unit = 100
+
+var (
+	ErrInvalidAmount   = errors.New("invalid amount")
+	ErrTooManyDecimals = fmt.Errorf("amount has more than %d decimal places", Scale)
+	ErrOutOfRange      = errors.New("amount out of range")
+)
+
+// Money is an exact amount stored as an integer number of minor units
+// (1/100 of a rupiah). It is encoded in JSON as a decimal string such as
+// "10000.50" and stored in the database as a bigint.
+type Money int64
+
+// FromMinor returns the amount for the given number of minor units.
+func FromMinor(minor int64) Money {
+	return Money(minor)
+}
+
+// FromMajor returns the amount for a whole number of major units.
+func FromMajor(major int64) Money {
+	return Money(major * unit)
+}
+
+// Parse reads an exact decimal string such as "10000", "-5.5" or "0.01".
+func Parse(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "-") {
+		negative = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	whole, fraction, hasPoint := strings.Cut(s, ".")
+	if whole == "" && fraction == "" || hasPoint && fraction == "" || !isDigits(whole) || !isDigits(fraction) {
+		return 0, ErrInvalidAmount
+	}
+
+	// Trailing zeros beyond the scale do not change the amount
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > Scale {
+		return 0, ErrTooManyDecimals
+	}
+	fraction += strings.Repeat("0", Scale-len(fraction))
+
+	whole = strings.TrimLeft(whole, "0")
+	if whole == "" {
+		whole = "0"
+	}
+	minor, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, ErrOutOfRange
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money(minor), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Minor returns the amount as a number of minor units.
+func (m Money) Minor() int64 {
+	return int64(m)
+}
+
+// String formats the amount as a decimal string with Scale decimal places.
+func (m Money) String() string {
+	sign := ""
+	minor := uint64(m)
+	if m < 0 {
+		sign = "-"
+		minor = uint64(-m)
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/unit, Scale, minor%unit)
+}
+
+// DecimalPlaces returns the number of significant decimal places of the amount.
+func (m Money) DecimalPlaces() int {
+	places := Scale
+	for minor := int64(m); places > 0 && minor%10 == 0; minor /= 10 {
+		places--
+	}
+	return places
+}
+
+func (m Money) Add(other Money) Money {
+	return m + other
+}
+
+func (m Money) Sub(other Money) Money {
+	return m - other
+}
+
+func (m Money) Neg() Money {
+	return -m
+}
+
+// CheckedAdd adds the amounts and reports ErrOutOfRange instead of overflowing.
+func (m Money) CheckedAdd(other Money) (Money, error) {
+	if other > 0 && m > math.MaxInt64-other || other < 0 && m < math.MinInt64-other {
+		return 0, ErrOutOfRange
+	}
+	return m + other, nil
+}
+
+func (m Money) IsZero() bool {
+	return m == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m > 0
+}
+
+func (m Money) IsNegative() bool {
+	return m < 0
+}
+
+func (m Money) LessThan(other Money) bool {
+	return m < other
+}
+
+func (m Money) GreaterThan(other Money) bool {
+	return m > other
+}
+
+// Sum adds up the amounts.
+func Sum(amounts ...Money) Money {
+	var total Money
+	for _, amount := range amounts {
+		total += amount
+	}
+	return total
+}
+
+// MarshalJSON encodes the amount as a decimal string.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + m.String() + `"`), nil
+}
+
+// UnmarshalJSON accepts a decimal string or a JSON number. Numbers are read
+// from their literal text, so 10000.10 is not rounded through a float.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	s := string(data)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	} else if strings.ContainsAny(s, "eE") {
+		return ErrInvalidAmount
+	}
+
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Value stores the amount as a bigint number of minor units.
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
+
+// Scan reads a bigint number of minor units.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(v)
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
+}
+
+func (m *Money) scanString(s string) error {
+	minor, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*m = Money(minor)
+	return nil
+}
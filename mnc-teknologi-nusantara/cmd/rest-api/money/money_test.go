@@ -0,0 +1,204 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr error
+	}{
+		{in: "10000", want: FromMajor(10000)},
+		{in: "10000.5", want: FromMinor(1000050)},
+		{in: "0.01", want: FromMinor(1)},
+		{in: ".5", want: FromMinor(50)},
+		{in: "+3", want: FromMajor(3)},
+		{in: " 7 ", want: FromMajor(7)},
+		{in: "007.10", want: FromMinor(710)},
+		{in: "1.0500", want: FromMinor(105)},
+		{in: "-5.5", want: FromMinor(-550)},
+		{in: "-0.01", want: FromMinor(-1)},
+		{in: "-0", want: 0},
+		{in: "92233720368547758.07", want: math.MaxInt64},
+		{in: "-92233720368547758.07", want: -math.MaxInt64},
+		{in: "92233720368547758.08", wantErr: ErrOutOfRange},
+		{in: "100000000000000000000", wantErr: ErrOutOfRange},
+		{in: "1.005", wantErr: ErrTooManyDecimals},
+		{in: "0.001", wantErr: ErrTooManyDecimals},
+		{in: "", wantErr: ErrInvalidAmount},
+		{in: ".", wantErr: ErrInvalidAmount},
+		{in: "5.", wantErr: ErrInvalidAmount},
+		{in: "-", wantErr: ErrInvalidAmount},
+		{in: "--1", wantErr: ErrInvalidAmount},
+		{in: "1,5", wantErr: ErrInvalidAmount},
+		{in: "1.2.3", wantErr: ErrInvalidAmount},
+		{in: "1e3", wantErr: ErrInvalidAmount},
+		{in: "abc", wantErr: ErrInvalidAmount},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Parse(%q) error = %v, want %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{in: 0, want: "0.00"},
+		{in: FromMinor(1), want: "0.01"},
+		{in: FromMinor(1000050), want: "10000.50"},
+		{in: FromMinor(-5), want: "-0.05"},
+		{in: FromMajor(-12), want: "-12.00"},
+		{in: math.MaxInt64, want: "92233720368547758.07"},
+		{in: math.MinInt64, want: "-92233720368547758.08"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestDecimalPlaces(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want int
+	}{
+		{in: 0, want: 0},
+		{in: FromMajor(10), want: 0},
+		{in: FromMinor(150), want: 1},
+		{in: FromMinor(-155), want: 2},
+	}
+	for _, tt := range tests {
+		if got := tt.in.DecimalPlaces(); got != tt.want {
+			t.Errorf("%s.DecimalPlaces() = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCheckedAdd(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    Money
+		want    Money
+		wantErr error
+	}{
+		{name: "positive", a: FromMajor(1), b: FromMinor(50), want: FromMinor(150)},
+		{name: "negative", a: FromMajor(1), b: FromMajor(-3), want: FromMajor(-2)},
+		{name: "up to the maximum", a: math.MaxInt64 - 1, b: 1, want: math.MaxInt64},
+		{name: "over the maximum", a: math.MaxInt64, b: 1, wantErr: ErrOutOfRange},
+		{name: "under the minimum", a: math.MinInt64, b: -1, wantErr: ErrOutOfRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.a.CheckedAdd(tt.b)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Amount Money `json:"amount"`
+	}{FromMinor(-1000010)})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if want := `{"amount":"-10000.10"}`; string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    Money
+		wantErr error
+	}{
+		{name: "string", in: `"10000.10"`, want: FromMinor(1000010)},
+		{name: "number", in: `10000.10`, want: FromMinor(1000010)},
+		{name: "integer", in: `25`, want: FromMajor(25)},
+		{name: "negative string", in: `"-1.5"`, want: FromMinor(-150)},
+		{name: "negative number", in: `-1.5`, want: FromMinor(-150)},
+		{name: "null keeps zero", in: `null`, want: 0},
+		{name: "string with excess decimals", in: `"1.001"`, wantErr: ErrTooManyDecimals},
+		{name: "number with excess decimals", in: `1.001`, wantErr: ErrTooManyDecimals},
+		{name: "exponent", in: `1e3`, wantErr: ErrInvalidAmount},
+		{name: "exponent in a string", in: `"1e3"`, wantErr: ErrInvalidAmount},
+		{name: "empty string", in: `""`, wantErr: ErrInvalidAmount},
+		{name: "boolean", in: `true`, wantErr: ErrInvalidAmount},
+		{name: "too large", in: `"92233720368547758.08"`, wantErr: ErrOutOfRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got struct {
+				Amount Money `json:"amount"`
+			}
+			err := json.Unmarshal([]byte(`{"amount":`+tt.in+`}`), &got)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got.Amount != tt.want {
+				t.Errorf("got %s, want %s", got.Amount, tt.want)
+			}
+		})
+	}
+}
+
+func TestValue(t *testing.T) {
+	value, err := FromMinor(-1000050).Value()
+	if err != nil {
+		t.Fatalf("value: %v", err)
+	}
+	if got, ok := value.(int64); !ok || got != -1000050 {
+		t.Errorf("got %#v, want int64(-1000050)", value)
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     interface{}
+		want    Money
+		wantErr bool
+	}{
+		{name: "int64", src: int64(1000050), want: FromMinor(1000050)},
+		{name: "negative int64", src: int64(-5), want: FromMinor(-5)},
+		{name: "bytes", src: []byte("1000050"), want: FromMinor(1000050)},
+		{name: "string", src: "-250", want: FromMinor(-250)},
+		{name: "nil", src: nil, want: 0},
+		{name: "decimal bytes", src: []byte("10000.50"), wantErr: true},
+		{name: "float64", src: float64(1.5), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FromMajor(99)
+			err := got.Scan(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
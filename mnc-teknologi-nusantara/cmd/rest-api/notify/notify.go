@@ -0,0 +1,62 @@
+// Package notify tells users about things that happened to their account.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Notifier delivers a message to the phone number of a user.
+type Notifier interface {
+	Notify(ctx context.Context, phoneNumber, subject, message string) error
+}
+
+// LogNotifier writes notifications to the application log. Meant for local development only.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, phoneNumber, subject, message string) error {
+	log.Printf("Notification for %s (%s): %s", phoneNumber, subject, message)
+	return nil
+}
+
+// FileNotifier appends notifications as JSON lines to a file so tests and
+// local tooling can read them back.
+type FileNotifier struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// FileMessage is a single line written by FileNotifier.
+type FileMessage struct {
+	PhoneNumber string    `json:"phone_number"`
+	Subject     string    `json:"subject"`
+	Message     string    `json:"message"`
+	SentAt      time.Time `json:"sent_at"`
+}
+
+func (n *FileNotifier) Notify(ctx context.Context, phoneNumber, subject, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(n.Path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(FileMessage{
+		PhoneNumber: phoneNumber,
+		Subject:     subject,
+		Message:     message,
+		SentAt:      time.Now(),
+	})
+}
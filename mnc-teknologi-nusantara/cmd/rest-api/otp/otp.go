@@ -0,0 +1,37 @@
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"mnctech-restapi/cmd/rest-api/notify"
+)
+
+// Sender delivers one-time passwords to a phone number.
+type Sender interface {
+	Send(ctx context.Context, phoneNumber, code, purpose string) error
+}
+
+// Generate returns a random numeric code with the given number of digits.
+func Generate(digits int) (string, error) {
+	code := make([]byte, digits)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code), nil
+}
+
+// NotifierSender delivers one-time passwords through a notify.Notifier, with the
+// purpose as subject and the code alone as message so tooling reading the
+// notifications back can take it as is.
+type NotifierSender struct {
+	Notifier notify.Notifier
+}
+
+func (s NotifierSender) Send(ctx context.Context, phoneNumber, code, purpose string) error {
+	return s.Notifier.Notify(ctx, phoneNumber, purpose, code)
+}
@@ -0,0 +1,186 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"mnctech-restapi/cmd/rest-api/broker"
+	"mnctech-restapi/cmd/rest-api/events"
+	"mnctech-restapi/cmd/rest-api/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of outbox messages.
+const (
+	KindQueue = "QUEUE" // Delivered to one consumer of the queue named by Destination
+	KindEvent = "EVENT" // Broadcast with Destination as routing key
+)
+
+// EnqueueMessage stores a message for the queue. tx must be the transaction of
+// the change the message is about, so the message exists exactly when it commits.
+func EnqueueMessage(tx *gorm.DB, queue, id string, body []byte) error {
+	return tx.Create(&models.OutboxMessage{
+		UID:         id,
+		Kind:        KindQueue,
+		Destination: queue,
+		Payload:     body,
+	}).Error
+}
+
+// EnqueueEvent stores the event in the outbox within tx.
+func EnqueueEvent(tx *gorm.DB, eventType string, data interface{}) error {
+	event, err := events.New(eventType, data)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&models.OutboxMessage{
+		UID:         event.ID,
+		Kind:        KindEvent,
+		Destination: event.Type,
+		Payload:     body,
+	}).Error
+}
+
+// Relay publishes outbox messages to the broker. A message is marked published
+// only after the broker confirmed it, so delivery is at least once: a crash in
+// between publishes it again. Several relays may run side by side.
+//
+// A message the broker rejects is retried after a delay that doubles with every
+// attempt, while the messages behind it are published. After MaxAttempts the
+// relay gives up on an event and sets its failed_at. Queue messages are commands,
+// such as crediting a transfer whose sender was already debited, so they are
+// retried until the broker takes them.
+type Relay struct {
+	DB            *gorm.DB
+	Broker        broker.Broker
+	BatchSize     int           // Messages published per database transaction
+	Interval      time.Duration // Wait between polls when the outbox is empty
+	Retention     time.Duration // How long published messages are kept, zero keeps them forever
+	MaxAttempts   int           // Failed attempts before an event is given up on, zero retries forever
+	RetryDelay    time.Duration // Wait after the first failed attempt
+	MaxRetryDelay time.Duration // Longest wait between attempts
+}
+
+// DefaultRelay returns a relay with the default settings.
+func DefaultRelay(db *gorm.DB, messageBroker broker.Broker) *Relay {
+	return &Relay{
+		DB:            db,
+		Broker:        messageBroker,
+		BatchSize:     100,
+		Interval:      time.Second,
+		Retention:     7 * 24 * time.Hour,
+		MaxAttempts:   20,
+		RetryDelay:    time.Second,
+		MaxRetryDelay: 10 * time.Minute,
+	}
+}
+
+// Run publishes outbox messages until ctx is done.
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		published, err := r.PublishPending(ctx)
+		if err != nil {
+			log.Printf("Failed to publish outbox messages: %v", err)
+		}
+		if r.Retention > 0 {
+			if err := r.DeleteOlderThan(ctx, r.Retention); err != nil {
+				log.Printf("Failed to delete published outbox messages: %v", err)
+			}
+		}
+
+		// Keep going while there is a backlog
+		if err == nil && published == r.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.Interval):
+		}
+	}
+}
+
+// PublishPending publishes one batch of unpublished messages that are due, in the
+// order they were written, and returns how many were published. A message the
+// broker rejects is scheduled for a later attempt without holding up the rest.
+func (r *Relay) PublishPending(ctx context.Context) (int, error) {
+	published := 0
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Skip messages another relay is publishing
+		now := time.Now()
+		var messages []models.OutboxMessage
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("published_at IS NULL AND failed_at IS NULL").
+			Where("next_attempt_at IS NULL OR next_attempt_at <= ?", now).
+			Order("id").
+			Limit(r.BatchSize).
+			Find(&messages).Error; err != nil {
+			return err
+		}
+
+		for i := range messages {
+			message := &messages[i]
+			if err := r.publish(ctx, message); err != nil {
+				if err := tx.Model(message).Updates(r.failure(message.Kind, message.Attempts+1, err, time.Now())).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tx.Model(message).Update("published_at", time.Now()).Error; err != nil {
+				return err
+			}
+			published++
+		}
+		return nil
+	})
+	return published, err
+}
+
+// failure returns the columns to update after the failed attempt of a message of
+// the kind, attempts counting this one.
+func (r *Relay) failure(kind string, attempts int, err error, now time.Time) map[string]interface{} {
+	columns := map[string]interface{}{
+		"attempts":        attempts,
+		"last_error":      err.Error(),
+		"next_attempt_at": now.Add(r.retryDelay(attempts)),
+	}
+	if kind == KindEvent && r.MaxAttempts > 0 && attempts >= r.MaxAttempts {
+		columns["failed_at"] = now
+	}
+	return columns
+}
+
+// retryDelay is the wait after the given number of failed attempts: RetryDelay,
+// doubled for every further attempt, up to MaxRetryDelay.
+func (r *Relay) retryDelay(attempts int) time.Duration {
+	delay := r.RetryDelay
+	for i := 1; i < attempts && (r.MaxRetryDelay <= 0 || delay < r.MaxRetryDelay); i++ {
+		delay *= 2
+	}
+	if r.MaxRetryDelay > 0 && delay > r.MaxRetryDelay {
+		delay = r.MaxRetryDelay
+	}
+	return delay
+}
+
+func (r *Relay) publish(ctx context.Context, message *models.OutboxMessage) error {
+	msg := broker.Message{ID: message.UID, Body: message.Payload}
+	if message.Kind == KindEvent {
+		return r.Broker.PublishEvent(ctx, message.Destination, msg)
+	}
+	return r.Broker.Publish(ctx, message.Destination, msg)
+}
+
+// DeleteOlderThan removes messages published longer than age ago.
+func (r *Relay) DeleteOlderThan(ctx context.Context, age time.Duration) error {
+	return r.DB.WithContext(ctx).Unscoped().
+		Where("published_at < ?", time.Now().Add(-age)).
+		Delete(&models.OutboxMessage{}).Error
+}
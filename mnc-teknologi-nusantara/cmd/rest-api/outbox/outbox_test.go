@@ -0,0 +1,70 @@
+package outbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	relay := &Relay{RetryDelay: time.Second, MaxRetryDelay: 10 * time.Second}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Second},
+		{attempts: 2, want: 2 * time.Second},
+		{attempts: 3, want: 4 * time.Second},
+		{attempts: 4, want: 8 * time.Second},
+		{attempts: 5, want: 10 * time.Second},
+		{attempts: 100, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := relay.retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestFailureGivesUpAfterMaxAttempts(t *testing.T) {
+	now := time.Date(2024, 11, 11, 8, 0, 0, 0, time.UTC)
+	relay := &Relay{MaxAttempts: 3, RetryDelay: time.Second, MaxRetryDelay: time.Minute}
+	publishErr := errors.New("broker unavailable")
+
+	columns := relay.failure(KindEvent, 2, publishErr, now)
+	if _, ok := columns["failed_at"]; ok {
+		t.Errorf("failed_at set after 2 of 3 attempts")
+	}
+	if got, want := columns["next_attempt_at"], now.Add(2*time.Second); got != want {
+		t.Errorf("got next_attempt_at %v, want %v", got, want)
+	}
+	if got := columns["last_error"]; got != publishErr.Error() {
+		t.Errorf("got last_error %v, want %q", got, publishErr.Error())
+	}
+
+	columns = relay.failure(KindEvent, 3, publishErr, now)
+	if got := columns["failed_at"]; got != now {
+		t.Errorf("got failed_at %v after 3 of 3 attempts, want %v", got, now)
+	}
+
+	relay.MaxAttempts = 0
+	if _, ok := relay.failure(KindEvent, 1000, publishErr, now)["failed_at"]; ok {
+		t.Errorf("failed_at set with MaxAttempts 0")
+	}
+}
+
+func TestFailureKeepsRetryingQueueMessages(t *testing.T) {
+	now := time.Date(2024, 11, 11, 8, 0, 0, 0, time.UTC)
+	relay := &Relay{MaxAttempts: 3, RetryDelay: time.Second, MaxRetryDelay: time.Minute}
+	publishErr := errors.New("broker unavailable")
+
+	// A transfer whose sender was debited must reach the transfer worker
+	columns := relay.failure(KindQueue, 1000, publishErr, now)
+	if _, ok := columns["failed_at"]; ok {
+		t.Errorf("failed_at set for a queue message")
+	}
+	if got, want := columns["next_attempt_at"], now.Add(time.Minute); got != want {
+		t.Errorf("got next_attempt_at %v, want %v", got, want)
+	}
+}
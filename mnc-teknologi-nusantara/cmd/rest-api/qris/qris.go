@@ -0,0 +1,321 @@
+// Package qris encodes and decodes EMVCo merchant-presented QR payloads, the
+// format QRIS codes are built on. A payload is a sequence of tag, two digit
+// length and value fields, closed by a CRC-16/CCITT-FALSE checksum in tag 63.
+package qris
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Points of initiation: static codes are reused and the payer enters the amount,
+// dynamic codes are made for one payment and carry its amount.
+const (
+	Static  = "11"
+	Dynamic = "12"
+)
+
+// CountryIndonesia is the ISO 3166-1 alpha-2 code of Indonesia.
+const CountryIndonesia = "ID"
+
+// Largest lengths of the fields the format limits below the 99 characters of any value.
+const (
+	MaxMerchantName   = 25
+	MaxMerchantCity   = 15
+	MaxPostalCode     = 10
+	MaxBillNumber     = 25
+	MaxReferenceLabel = 25
+)
+
+// Tags of the root fields.
+const (
+	tagPayloadFormat        = "00"
+	tagPointOfInitiation    = "01"
+	tagMerchantAccountFirst = 26 // Merchant account information templates are 26 to 51
+	tagMerchantAccountLast  = 51
+	tagMerchantCategory     = "52"
+	tagCurrency             = "53"
+	tagAmount               = "54"
+	tagCountry              = "58"
+	tagMerchantName         = "59"
+	tagMerchantCity         = "60"
+	tagPostalCode           = "61"
+	tagAdditionalData       = "62"
+	tagCRC                  = "63"
+)
+
+// Tags inside templates.
+const (
+	subTagGloballyUniqueID = "00"
+	subTagMerchantID       = "01"
+	subTagBillNumber       = "01"
+	subTagReferenceLabel   = "05"
+)
+
+const payloadFormatVersion = "01"
+
+var (
+	ErrMalformed  = errors.New("malformed QR payload")
+	ErrInvalidCRC = errors.New("QR payload checksum does not match")
+)
+
+// MerchantAccount is a merchant account information template. A code may carry
+// one per payment network that can pay the merchant.
+type MerchantAccount struct {
+	Tag              string // 26 to 51
+	GloballyUniqueID string // Identifies the network, e.g. "ID.CO.QRIS.WWW"
+	MerchantID       string // Merchant within that network
+}
+
+// Payload is the content of a merchant-presented QR code.
+type Payload struct {
+	PointOfInitiation    string // Static or Dynamic
+	MerchantAccounts     []MerchantAccount
+	MerchantCategoryCode string // ISO 18245, e.g. "5999"
+	Currency             string // ISO 4217 numeric, e.g. "360"
+	Amount               string // Decimal with a "." separator, empty for the payer to enter
+	CountryCode          string
+	MerchantName         string
+	MerchantCity         string
+	PostalCode           string
+	BillNumber           string // Invoice number given by the merchant
+	ReferenceLabel       string // Identifies the transaction
+}
+
+// Account returns the merchant account of the network with the globally unique identifier.
+func (p Payload) Account(globallyUniqueID string) (MerchantAccount, bool) {
+	for _, account := range p.MerchantAccounts {
+		if account.GloballyUniqueID == globallyUniqueID {
+			return account, true
+		}
+	}
+	return MerchantAccount{}, false
+}
+
+// Encode returns the payload as the text of a QR code, checksum included.
+func Encode(p Payload) (string, error) {
+	if len(p.MerchantAccounts) == 0 {
+		return "", fmt.Errorf("%w: no merchant account", ErrMalformed)
+	}
+	var b strings.Builder
+	fields := []struct{ tag, value string }{
+		{tagPayloadFormat, payloadFormatVersion},
+		{tagPointOfInitiation, p.PointOfInitiation},
+	}
+	for _, account := range p.MerchantAccounts {
+		template, err := encodeFields(
+			subTagGloballyUniqueID, account.GloballyUniqueID,
+			subTagMerchantID, account.MerchantID,
+		)
+		if err != nil {
+			return "", err
+		}
+		fields = append(fields, struct{ tag, value string }{account.Tag, template})
+	}
+	additional, err := encodeFields(
+		subTagBillNumber, p.BillNumber,
+		subTagReferenceLabel, p.ReferenceLabel,
+	)
+	if err != nil {
+		return "", err
+	}
+	fields = append(fields, []struct{ tag, value string }{
+		{tagMerchantCategory, p.MerchantCategoryCode},
+		{tagCurrency, p.Currency},
+		{tagAmount, p.Amount},
+		{tagCountry, p.CountryCode},
+		{tagMerchantName, p.MerchantName},
+		{tagMerchantCity, p.MerchantCity},
+		{tagPostalCode, p.PostalCode},
+		{tagAdditionalData, additional},
+	}...)
+
+	for _, field := range fields {
+		if err := writeField(&b, field.tag, field.value); err != nil {
+			return "", err
+		}
+	}
+
+	// The checksum covers everything before it, including its own tag and length
+	b.WriteString(tagCRC + "04")
+	return b.String() + fmt.Sprintf("%04X", CRC16([]byte(b.String()))), nil
+}
+
+// Decode checks the checksum of a scanned payload and returns its content.
+// Fields it does not know are skipped.
+func Decode(text string) (Payload, error) {
+	var p Payload
+	if len(text) < 8 || text[len(text)-8:len(text)-4] != tagCRC+"04" {
+		return p, fmt.Errorf("%w: no checksum at the end", ErrMalformed)
+	}
+	want, err := strconv.ParseUint(text[len(text)-4:], 16, 16)
+	if err != nil {
+		return p, fmt.Errorf("%w: checksum is not hexadecimal", ErrMalformed)
+	}
+	if CRC16([]byte(text[:len(text)-4])) != uint16(want) {
+		return p, ErrInvalidCRC
+	}
+
+	fields, err := decodeFields(text[:len(text)-8])
+	if err != nil {
+		return p, err
+	}
+	if len(fields) == 0 || fields[0].tag != tagPayloadFormat || fields[0].value != payloadFormatVersion {
+		return p, fmt.Errorf("%w: payload format indicator must come first", ErrMalformed)
+	}
+
+	for _, field := range fields[1:] {
+		switch field.tag {
+		case tagPointOfInitiation:
+			p.PointOfInitiation = field.value
+		case tagMerchantCategory:
+			p.MerchantCategoryCode = field.value
+		case tagCurrency:
+			p.Currency = field.value
+		case tagAmount:
+			p.Amount = field.value
+		case tagCountry:
+			p.CountryCode = field.value
+		case tagMerchantName:
+			p.MerchantName = field.value
+		case tagMerchantCity:
+			p.MerchantCity = field.value
+		case tagPostalCode:
+			p.PostalCode = field.value
+		case tagAdditionalData:
+			subFields, err := decodeFields(field.value)
+			if err != nil {
+				return p, err
+			}
+			for _, subField := range subFields {
+				switch subField.tag {
+				case subTagBillNumber:
+					p.BillNumber = subField.value
+				case subTagReferenceLabel:
+					p.ReferenceLabel = subField.value
+				}
+			}
+		default:
+			if number, _ := strconv.Atoi(field.tag); number >= tagMerchantAccountFirst && number <= tagMerchantAccountLast {
+				subFields, err := decodeFields(field.value)
+				if err != nil {
+					return p, err
+				}
+				account := MerchantAccount{Tag: field.tag}
+				for _, subField := range subFields {
+					switch subField.tag {
+					case subTagGloballyUniqueID:
+						account.GloballyUniqueID = subField.value
+					case subTagMerchantID:
+						account.MerchantID = subField.value
+					}
+				}
+				p.MerchantAccounts = append(p.MerchantAccounts, account)
+			}
+		}
+	}
+
+	if p.PointOfInitiation != Static && p.PointOfInitiation != Dynamic {
+		return p, fmt.Errorf("%w: unknown point of initiation %q", ErrMalformed, p.PointOfInitiation)
+	}
+	for name, value := range map[string]string{
+		"merchant category code": p.MerchantCategoryCode,
+		"currency":               p.Currency,
+		"country code":           p.CountryCode,
+		"merchant name":          p.MerchantName,
+		"merchant city":          p.MerchantCity,
+	} {
+		if value == "" {
+			return p, fmt.Errorf("%w: missing %s", ErrMalformed, name)
+		}
+	}
+	return p, nil
+}
+
+// CRC16 returns the CRC-16/CCITT-FALSE checksum of data: polynomial 0x1021,
+// initial value 0xFFFF, no reflection.
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Clean makes text fit a field: characters outside printable ASCII are dropped,
+// as payment apps may not show them, and the rest is cut to limit characters.
+func Clean(text string, limit int) string {
+	var b strings.Builder
+	for _, r := range text {
+		if r >= 0x20 && r <= 0x7E && b.Len() < limit {
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+type field struct {
+	tag   string
+	value string
+}
+
+// writeField appends a field, leaving out empty values.
+func writeField(b *strings.Builder, tag, value string) error {
+	if value == "" {
+		return nil
+	}
+	if len(value) > 99 {
+		return fmt.Errorf("%w: value of tag %s is longer than 99 characters", ErrMalformed, tag)
+	}
+	for _, r := range value {
+		if r < 0x20 || r > 0x7E {
+			return fmt.Errorf("%w: value of tag %s is not printable ASCII", ErrMalformed, tag)
+		}
+	}
+	fmt.Fprintf(b, "%s%02d%s", tag, len(value), value)
+	return nil
+}
+
+// encodeFields encodes the content of a template from tag and value pairs.
+func encodeFields(tagsAndValues ...string) (string, error) {
+	var b strings.Builder
+	for i := 0; i+1 < len(tagsAndValues); i += 2 {
+		if err := writeField(&b, tagsAndValues[i], tagsAndValues[i+1]); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// decodeFields splits the content of a payload or template into its fields.
+// A tag may appear only once.
+func decodeFields(data string) ([]field, error) {
+	var fields []field
+	seen := make(map[string]bool)
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("%w: truncated field", ErrMalformed)
+		}
+		tag := data[:2]
+		length, err := strconv.Atoi(data[2:4])
+		if err != nil || length < 1 || len(data) < 4+length {
+			return nil, fmt.Errorf("%w: bad length of tag %s", ErrMalformed, tag)
+		}
+		if seen[tag] {
+			return nil, fmt.Errorf("%w: tag %s appears twice", ErrMalformed, tag)
+		}
+		seen[tag] = true
+		fields = append(fields, field{tag: tag, value: data[4 : 4+length]})
+		data = data[4+length:]
+	}
+	return fields, nil
+}
@@ -0,0 +1,202 @@
+package qris
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// reference is a dynamic payload whose checksum was computed outside this package.
+const reference = "00020101021226370014ID.CO.QRIS.WWW0115ID1020000000001520458125303360540815000.005802ID5912Warung Sayur6007Jakarta61051219062180105INV-10505REF-163048570"
+
+var referencePayload = Payload{
+	PointOfInitiation: Dynamic,
+	MerchantAccounts: []MerchantAccount{
+		{Tag: "26", GloballyUniqueID: "ID.CO.QRIS.WWW", MerchantID: "ID1020000000001"},
+	},
+	MerchantCategoryCode: "5812",
+	Currency:             "360",
+	Amount:               "15000.00",
+	CountryCode:          CountryIndonesia,
+	MerchantName:         "Warung Sayur",
+	MerchantCity:         "Jakarta",
+	PostalCode:           "12190",
+	BillNumber:           "INV-1",
+	ReferenceLabel:       "REF-1",
+}
+
+// withCRC closes a payload body with its checksum.
+func withCRC(body string) string {
+	body += tagCRC + "04"
+	return body + fmt.Sprintf("%04X", CRC16([]byte(body)))
+}
+
+func TestCRC16(t *testing.T) {
+	// Check value of CRC-16/CCITT-FALSE
+	if got := CRC16([]byte("123456789")); got != 0x29B1 {
+		t.Errorf("got %04X, want 29B1", got)
+	}
+}
+
+func TestEncodeReference(t *testing.T) {
+	got, err := Encode(referencePayload)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if got != reference {
+		t.Errorf("got %s, want %s", got, reference)
+	}
+}
+
+func TestDecodeReference(t *testing.T) {
+	got, err := Decode(reference)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !reflect.DeepEqual(got, referencePayload) {
+		t.Errorf("got %+v, want %+v", got, referencePayload)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	static := Payload{
+		PointOfInitiation:    Static,
+		MerchantAccounts:     []MerchantAccount{{Tag: "26", GloballyUniqueID: "ID.CO.QRIS.WWW", MerchantID: "ID1020000000002"}},
+		MerchantCategoryCode: "5999",
+		Currency:             "360",
+		CountryCode:          CountryIndonesia,
+		MerchantName:         "Toko Maju",
+		MerchantCity:         "Bandung",
+	}
+	networks := referencePayload
+	networks.MerchantAccounts = []MerchantAccount{
+		{Tag: "26", GloballyUniqueID: "ID.CO.QRIS.WWW", MerchantID: "ID1020000000001"},
+		{Tag: "51", GloballyUniqueID: "ID.CO.EXAMPLE.WWW", MerchantID: "936000000001"},
+	}
+
+	tests := []struct {
+		name    string
+		payload Payload
+	}{
+		{name: "static without amount", payload: static},
+		{name: "dynamic with amount", payload: referencePayload},
+		{name: "several networks", payload: networks},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := Encode(tt.payload)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			got, err := Decode(text)
+			if err != nil {
+				t.Fatalf("decode %s: %v", text, err)
+			}
+			if !reflect.DeepEqual(got, tt.payload) {
+				t.Errorf("got %+v, want %+v", got, tt.payload)
+			}
+		})
+	}
+}
+
+func TestPointOfInitiation(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr error
+	}{
+		{name: "static", value: "11"},
+		{name: "dynamic", value: "12"},
+		{name: "unknown", value: "13", wantErr: ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Replace(reference[:len(reference)-8], "010212", "0102"+tt.value, 1)
+			got, err := Decode(withCRC(body))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.PointOfInitiation != tt.value {
+				t.Errorf("got point of initiation %q, want %q", got.PointOfInitiation, tt.value)
+			}
+		})
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	body := reference[:len(reference)-8]
+
+	tests := []struct {
+		name    string
+		text    string
+		wantErr error
+	}{
+		{name: "tampered checksum", text: reference[:len(reference)-4] + "8571", wantErr: ErrInvalidCRC},
+		{name: "tampered amount", text: strings.Replace(reference, "15000.00", "95000.00", 1), wantErr: ErrInvalidCRC},
+		{name: "zero checksum", text: body + "63040000", wantErr: ErrInvalidCRC},
+		{name: "no checksum", text: body, wantErr: ErrMalformed},
+		{name: "short", text: "6304", wantErr: ErrMalformed},
+		{name: "checksum not hexadecimal", text: body + "6304ZZZZ", wantErr: ErrMalformed},
+		{name: "empty", text: "", wantErr: ErrMalformed},
+		{name: "truncated field", text: withCRC(body + "99"), wantErr: ErrMalformed},
+		{name: "length beyond the payload", text: withCRC(body + "9905AB"), wantErr: ErrMalformed},
+		{name: "zero length", text: withCRC(body + "9900"), wantErr: ErrMalformed},
+		{name: "length not a number", text: withCRC(body + "99XYAB"), wantErr: ErrMalformed},
+		{name: "bad length inside a template", text: withCRC(strings.Replace(body, "0115ID10", "0199ID10", 1)), wantErr: ErrMalformed},
+		{name: "tag twice", text: withCRC(body + "5802ID"), wantErr: ErrMalformed},
+		{name: "format indicator not first", text: withCRC("010212" + strings.TrimPrefix(body, "000201010212") + "000201"), wantErr: ErrMalformed},
+		{name: "missing merchant name", text: withCRC(strings.Replace(body, "5912Warung Sayur", "", 1)), wantErr: ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.text); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncodeRejects(t *testing.T) {
+	noAccount := referencePayload
+	noAccount.MerchantAccounts = nil
+	longName := referencePayload
+	longName.MerchantName = strings.Repeat("A", 100)
+	notASCII := referencePayload
+	notASCII.MerchantCity = "Jakartaé"
+
+	tests := []struct {
+		name    string
+		payload Payload
+	}{
+		{name: "no merchant account", payload: noAccount},
+		{name: "value over 99 characters", payload: longName},
+		{name: "not printable ASCII", payload: notASCII},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Encode(tt.payload); !errors.Is(err, ErrMalformed) {
+				t.Errorf("got error %v, want %v", err, ErrMalformed)
+			}
+		})
+	}
+}
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		text  string
+		limit int
+		want  string
+	}{
+		{text: "Warung Sayur", limit: MaxMerchantName, want: "Warung Sayur"},
+		{text: "Café Kopi", limit: MaxMerchantName, want: "Caf Kopi"},
+		{text: "Jakarta Selatan Raya", limit: MaxMerchantCity, want: "Jakarta Selatan"},
+		{text: "  Toko\n", limit: MaxMerchantName, want: "Toko"},
+	}
+	for _, tt := range tests {
+		if got := Clean(tt.text, tt.limit); got != tt.want {
+			t.Errorf("Clean(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Package responses holds the JSON bodies every endpoint answers with, so
+// handlers and middlewares can write them without depending on each other.
+package responses
+
+type SuccessResponse struct {
+	Status string      `json:"status"`
+	Result interface{} `json:"result"` // Result can hold any type of data
+}
+
+// PaginatedSuccessResponse is a SuccessResponse whose result is one page of a list.
+type PaginatedSuccessResponse struct {
+	SuccessResponse
+	NextCursor *string `json:"next_cursor"` // Pass as cursor to get the next page, null on the last page
+}
+
+type FailedResponse struct {
+	Message string       `json:"message"`
+	Code    string       `json:"code,omitempty"`   // Machine readable error code for errors the client handles specially
+	Errors  []FieldError `json:"errors,omitempty"` // Per-field details of a validation failure
+}
+
+// FieldError describes why a single request field is invalid.
+type FieldError struct {
+	Field   string `json:"field"`   // JSON name of the field
+	Code    string `json:"code"`    // Machine readable reason, e.g. "REQUIRED"
+	Message string `json:"message"` // Human readable reason
+}
+
+func NewSuccessResponse(result interface{}) SuccessResponse {
+	return SuccessResponse{
+		Status: "SUCCESS", // Default status
+		Result: result,    // Dynamic result data
+	}
+}
+
+func NewFailedResponse(failedMessage string) FailedResponse {
+	return FailedResponse{
+		Message: failedMessage,
+	}
+}
+
+func NewFailedResponseWithCode(code, failedMessage string) FailedResponse {
+	return FailedResponse{
+		Message: failedMessage,
+		Code:    code,
+	}
+}
@@ -0,0 +1,66 @@
+package statement
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteCSV writes the statement as CSV: a header block, one row per entry, then
+// the closing balance and the totals per category. Text that users or merchants
+// chose goes through cell, so spreadsheets do not run it as a formula.
+func WriteCSV(w io.Writer, st Statement) error {
+	writer := csv.NewWriter(w)
+
+	rows := [][]string{
+		{"Account Statement"},
+		{"Account ID", strconv.FormatUint(uint64(st.AccountID), 10)},
+		{"Account Name", cell(st.AccountName)},
+		{"Currency", st.Currency},
+		{"Account Holder", cell(st.HolderName)},
+		{"Phone Number", cell(st.PhoneNumber)},
+		{"Period", st.Period()},
+		{"Opening Balance", st.OpeningBalance.String()},
+		{},
+		{"Date", "Transaction ID", "Category", "Type", "Status", "Remarks", "Debit", "Credit", "Balance"},
+	}
+	for _, entry := range st.Entries {
+		rows = append(rows, []string{
+			entry.Date.Format(time.RFC3339),
+			cell(entry.TransactionID),
+			cell(entry.Category),
+			cell(entry.TransactionType),
+			cell(entry.Status),
+			cell(entry.Remarks),
+			entry.Debit.String(),
+			entry.Credit.String(),
+			entry.Balance.String(),
+		})
+	}
+	rows = append(rows,
+		[]string{},
+		[]string{"Closing Balance", st.ClosingBalance.String()},
+		[]string{},
+		[]string{"Category", "Debit", "Credit"},
+	)
+	for _, total := range st.Totals {
+		rows = append(rows, []string{cell(total.Category), total.Debit.String(), total.Credit.String()})
+	}
+	rows = append(rows, []string{"TOTAL", st.TotalDebit().String(), st.TotalCredit().String()})
+
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// cell quotes text a spreadsheet would read as a formula with a leading "'".
+// Amounts are written by WriteCSV itself and stay numbers.
+func cell(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
@@ -0,0 +1,93 @@
+package statement
+
+import (
+	"bytes"
+	"encoding/csv"
+	"mnctech-restapi/cmd/rest-api/money"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCell(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: ""},
+		{in: "Lunch", want: "Lunch"},
+		{in: "Lunch =1+1", want: "Lunch =1+1"},
+		{in: "=HYPERLINK(\"http://example.com\")", want: "'=HYPERLINK(\"http://example.com\")"},
+		{in: "+62812", want: "'+62812"},
+		{in: "-1+1", want: "'-1+1"},
+		{in: "@SUM(A1)", want: "'@SUM(A1)"},
+		{in: "\t=1", want: "'\t=1"},
+		{in: "\r=1", want: "'\r=1"},
+	}
+	for _, tt := range tests {
+		if got := cell(tt.in); got != tt.want {
+			t.Errorf("cell(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	st := Statement{
+		AccountID:      7,
+		AccountName:    "=cmd|' /C calc'!A0",
+		Currency:       "IDR",
+		HolderName:     "Budi Santoso",
+		PhoneNumber:    "+6281234567890",
+		Month:          time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+		OpeningBalance: money.FromMajor(100),
+		ClosingBalance: money.FromMajor(-20),
+		Entries: []Entry{{
+			Date:            time.Date(2024, 10, 3, 9, 0, 0, 0, time.UTC),
+			TransactionID:   "trx-1",
+			Category:        "PAYMENT",
+			TransactionType: "DEBIT",
+			Status:          "SUCCESS",
+			Remarks:         "@SUM(1+1)",
+			Debit:           money.FromMajor(120),
+			Balance:         money.FromMajor(-20),
+		}},
+		Totals: []CategoryTotal{{Category: "PAYMENT", Debit: money.FromMajor(120)}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, st); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	reader := csv.NewReader(&buf)
+	reader.FieldsPerRecord = -1 // Rows differ in length
+	rows, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("read back: %v", err)
+	}
+
+	// Blank lines are skipped when reading back
+	want := [][]string{
+		{"Account Statement"},
+		{"Account ID", "7"},
+		{"Account Name", "'=cmd|' /C calc'!A0"},
+		{"Currency", "IDR"},
+		{"Account Holder", "Budi Santoso"},
+		{"Phone Number", "'+6281234567890"},
+		{"Period", "2024-10"},
+		{"Opening Balance", "100.00"},
+		{"Date", "Transaction ID", "Category", "Type", "Status", "Remarks", "Debit", "Credit", "Balance"},
+		{"2024-10-03T09:00:00Z", "trx-1", "PAYMENT", "DEBIT", "SUCCESS", "'@SUM(1+1)", "120.00", "0.00", "-20.00"},
+		{"Closing Balance", "-20.00"},
+		{"Category", "Debit", "Credit"},
+		{"PAYMENT", "120.00", "0.00"},
+		{"TOTAL", "120.00", "0.00"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d: %q", len(rows), len(want), rows)
+	}
+	for i := range want {
+		if !slices.Equal(rows[i], want[i]) {
+			t.Errorf("row %d: got %q, want %q", i, rows[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package statement
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// entryColumns are the columns of the entry table: header, width in mm and alignment.
+var entryColumns = []struct {
+	Header string
+	Width  float64
+	Align  string
+}{
+	{"Date", 28, "L"},
+	{"Category", 30, "L"},
+	{"Remarks", 46, "L"},
+	{"Status", 18, "L"},
+	{"Debit", 22, "R"},
+	{"Credit", 22, "R"},
+	{"Balance", 24, "R"},
+}
+
+// WritePDF renders the statement as an A4 PDF. It only uses the core PDF fonts,
+// so no font files are needed.
+func WritePDF(w io.Writer, st Statement) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("Account Statement "+st.Period(), false)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AliasNbPages("")
+	translate := pdf.UnicodeTranslatorFromDescriptor("") // Core fonts use cp1252
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Generated %s - Page %d/{nb}", st.GeneratedAt.Format("2006-01-02 15:04"), pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+
+	tableHeader := func() {
+		pdf.SetFont("Helvetica", "B", 8)
+		pdf.SetFillColor(230, 230, 230)
+		for _, column := range entryColumns {
+			pdf.CellFormat(column.Width, 6, column.Header, "1", 0, column.Align, true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", 8)
+	}
+
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 8, "Account Statement", "", 1, "L", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 9)
+	for _, line := range [][2]string{
+		{"Account ID", strconv.FormatUint(uint64(st.AccountID), 10)},
+		{"Account Name", translate(st.AccountName)},
+		{"Currency", st.Currency},
+		{"Account Holder", translate(st.HolderName)},
+		{"Phone Number", st.PhoneNumber},
+		{"Period", st.Period()},
+		{"Opening Balance", st.OpeningBalance.String()},
+	} {
+		pdf.CellFormat(35, 5, line[0], "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 5, line[1], "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	tableHeader()
+	_, pageHeight := pdf.GetPageSize()
+	for _, entry := range st.Entries {
+		// Repeat the table header on every page
+		if pdf.GetY()+6 > pageHeight-15 {
+			pdf.AddPage()
+			tableHeader()
+		}
+		values := []string{
+			entry.Date.Format("2006-01-02 15:04"),
+			entry.Category,
+			translate(truncate(entry.Remarks, 30)),
+			entry.Status,
+			blankIfZero(entry.Debit.String(), entry.Debit.IsZero()),
+			blankIfZero(entry.Credit.String(), entry.Credit.IsZero()),
+			entry.Balance.String(),
+		}
+		for i, column := range entryColumns {
+			pdf.CellFormat(column.Width, 6, values[i], "1", 0, column.Align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	if len(st.Entries) == 0 {
+		pdf.CellFormat(0, 6, "No transactions in this period", "1", 1, "C", false, 0, "")
+	}
+
+	pdf.Ln(4)
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.CellFormat(35, 6, "Closing Balance", "", 0, "L", false, 0, "")
+	pdf.CellFormat(0, 6, st.ClosingBalance.String(), "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+
+	pdf.SetFillColor(230, 230, 230)
+	pdf.CellFormat(50, 6, "Category", "1", 0, "L", true, 0, "")
+	pdf.CellFormat(30, 6, "Debit", "1", 0, "R", true, 0, "")
+	pdf.CellFormat(30, 6, "Credit", "1", 1, "R", true, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	for _, total := range st.Totals {
+		pdf.CellFormat(50, 6, total.Category, "1", 0, "L", false, 0, "")
+		pdf.CellFormat(30, 6, total.Debit.String(), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(30, 6, total.Credit.String(), "1", 1, "R", false, 0, "")
+	}
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.CellFormat(50, 6, "TOTAL", "1", 0, "L", false, 0, "")
+	pdf.CellFormat(30, 6, st.TotalDebit().String(), "1", 0, "R", false, 0, "")
+	pdf.CellFormat(30, 6, st.TotalCredit().String(), "1", 1, "R", false, 0, "")
+
+	return pdf.Output(w)
+}
+
+// truncate shortens s to at most n runes, ending with "..." when cut.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+func blankIfZero(s string, zero bool) string {
+	if zero {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,142 @@
+package statement
+
+import (
+	"errors"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Statement is the monthly statement of one account.
+type Statement struct {
+	AccountID      uint
+	AccountName    string
+	Currency       string // Currency of every amount on the statement
+	HolderName     string
+	PhoneNumber    string
+	Month          time.Time // First instant of the month
+	OpeningBalance money.Money
+	ClosingBalance money.Money
+	Entries        []Entry
+	Totals         []CategoryTotal // Sorted by category
+	GeneratedAt    time.Time
+}
+
+// Entry is one AccountTransactionLog row of the statement.
+type Entry struct {
+	Date            time.Time
+	TransactionID   string
+	Category        string
+	TransactionType string // DEBIT or CREDIT
+	Status          string
+	Remarks         string
+	Debit           money.Money
+	Credit          money.Money
+	Balance         money.Money // Running balance after the entry
+}
+
+// CategoryTotal adds up the successful entries of one category.
+type CategoryTotal struct {
+	Category string
+	Debit    money.Money
+	Credit   money.Money
+}
+
+// Build collects the statement of the account for the month starting at month.
+// Only SUCCESS entries move the running balance; failed ones are listed for reference.
+func Build(db *gorm.DB, userAccount models.UserAccount, holder models.User, month time.Time) (Statement, error) {
+	start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, month.Location())
+	end := start.AddDate(0, 1, 0)
+
+	st := Statement{
+		AccountID:   userAccount.ID,
+		AccountName: userAccount.Name,
+		Currency:    userAccount.Currency,
+		HolderName:  strings.TrimSpace(holder.FirstName + " " + holder.LastName),
+		PhoneNumber: holder.PhoneNumber,
+		Month:       start,
+		GeneratedAt: time.Now(),
+	}
+
+	// The opening balance is where the last successful entry before the month left the account
+	var previous models.AccountTransactionLog
+	err := db.Where("user_account_id = ? AND status = ? AND created_at < ?", userAccount.ID, "SUCCESS", start).
+		Order("id desc").First(&previous).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return Statement{}, err
+	}
+	st.OpeningBalance = previous.BalanceAfter
+
+	var transactionLogs []models.AccountTransactionLog
+	if err := db.Where("user_account_id = ? AND created_at >= ? AND created_at < ?", userAccount.ID, start, end).
+		Order("id").Find(&transactionLogs).Error; err != nil {
+		return Statement{}, err
+	}
+
+	balance := st.OpeningBalance
+	totals := map[string]*CategoryTotal{}
+	for _, log := range transactionLogs {
+		entry := Entry{
+			Date:            log.CreatedAt,
+			TransactionID:   log.TransactionReff,
+			Category:        log.TransactionCategory,
+			TransactionType: log.TransactionType,
+			Status:          log.Status,
+			Remarks:         log.Remarks,
+		}
+		if log.TransactionType == "DEBIT" {
+			entry.Debit = log.Amount
+		} else {
+			entry.Credit = log.Amount
+		}
+
+		if log.Status == "SUCCESS" {
+			balance = balance.Add(entry.Credit).Sub(entry.Debit)
+
+			total, ok := totals[log.TransactionCategory]
+			if !ok {
+				total = &CategoryTotal{Category: log.TransactionCategory}
+				totals[log.TransactionCategory] = total
+			}
+			total.Debit = total.Debit.Add(entry.Debit)
+			total.Credit = total.Credit.Add(entry.Credit)
+		}
+		entry.Balance = balance
+		st.Entries = append(st.Entries, entry)
+	}
+	st.ClosingBalance = balance
+
+	for _, total := range totals {
+		st.Totals = append(st.Totals, *total)
+	}
+	sort.Slice(st.Totals, func(i, j int) bool { return st.Totals[i].Category < st.Totals[j].Category })
+
+	return st, nil
+}
+
+// TotalDebit returns the sum of all successful debits.
+func (st Statement) TotalDebit() money.Money {
+	var total money.Money
+	for _, categoryTotal := range st.Totals {
+		total = total.Add(categoryTotal.Debit)
+	}
+	return total
+}
+
+// TotalCredit returns the sum of all successful credits.
+func (st Statement) TotalCredit() money.Money {
+	var total money.Money
+	for _, categoryTotal := range st.Totals {
+		total = total.Add(categoryTotal.Credit)
+	}
+	return total
+}
+
+// Period returns the month of the statement as YYYY-MM.
+func (st Statement) Period() string {
+	return st.Month.Format("2006-01")
+}
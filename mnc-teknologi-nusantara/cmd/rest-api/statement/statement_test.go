@@ -0,0 +1,76 @@
+package statement
+
+import (
+	"mnctech-restapi/cmd/rest-api/database/databasetest"
+	"mnctech-restapi/cmd/rest-api/models"
+	"mnctech-restapi/cmd/rest-api/money"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func TestBuild(t *testing.T) {
+	db := databasetest.Open(t)
+
+	user := models.User{UID: uuid.New().String(), PhoneNumber: uuid.New().String(), FirstName: "Budi", LastName: "Santoso"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	userAccount := models.UserAccount{UID: uuid.New().String(), UserID: user.ID, Name: "Main", Currency: "IDR"}
+	if err := db.Create(&userAccount).Error; err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Unscoped().Where("user_account_id = ?", userAccount.ID).Delete(&models.AccountTransactionLog{})
+		db.Unscoped().Delete(&userAccount)
+		db.Unscoped().Delete(&user)
+	})
+
+	month := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	for _, log := range []models.AccountTransactionLog{
+		{Model: gorm.Model{CreatedAt: month.Add(-time.Hour)}, TransactionType: "CREDIT", TransactionCategory: "TOPUP", Amount: money.FromMajor(100), BalanceAfter: money.FromMajor(100), Status: "SUCCESS"},
+		{Model: gorm.Model{CreatedAt: month.Add(time.Hour)}, TransactionType: "DEBIT", TransactionCategory: "PAYMENT", Amount: money.FromMajor(30), BalanceAfter: money.FromMajor(70), Status: "SUCCESS"},
+		{Model: gorm.Model{CreatedAt: month.Add(2 * time.Hour)}, TransactionType: "DEBIT", TransactionCategory: "PAYMENT", Amount: money.FromMajor(500), Status: "FAILED"},
+		{Model: gorm.Model{CreatedAt: month.Add(3 * time.Hour)}, TransactionType: "CREDIT", TransactionCategory: "TOPUP", Amount: money.FromMajor(50), BalanceAfter: money.FromMajor(120), Status: "SUCCESS"},
+		{Model: gorm.Model{CreatedAt: month.AddDate(0, 1, 0)}, TransactionType: "DEBIT", TransactionCategory: "PAYMENT", Amount: money.FromMajor(120), Status: "SUCCESS"},
+	} {
+		log.UserAccountID = userAccount.ID
+		log.TransactionReff = uuid.New().String()
+		if err := db.Create(&log).Error; err != nil {
+			t.Fatalf("create log: %v", err)
+		}
+	}
+
+	st, err := Build(db, userAccount, user, month.AddDate(0, 0, 14))
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+
+	if st.HolderName != "Budi Santoso" || st.Period() != "2024-10" {
+		t.Errorf("got holder %q period %q, want Budi Santoso 2024-10", st.HolderName, st.Period())
+	}
+	if st.OpeningBalance != money.FromMajor(100) || st.ClosingBalance != money.FromMajor(120) {
+		t.Errorf("got opening %s closing %s, want 100.00 and 120.00", st.OpeningBalance, st.ClosingBalance)
+	}
+
+	// The failed payment is listed without moving the balance
+	if len(st.Entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(st.Entries))
+	}
+	if failed := st.Entries[1]; failed.Status != "FAILED" || failed.Debit != money.FromMajor(500) || failed.Balance != money.FromMajor(70) {
+		t.Errorf("got failed entry %+v, want debit 500.00 at balance 70.00", failed)
+	}
+
+	want := []CategoryTotal{
+		{Category: "PAYMENT", Debit: money.FromMajor(30)},
+		{Category: "TOPUP", Credit: money.FromMajor(50)},
+	}
+	if len(st.Totals) != len(want) || st.Totals[0] != want[0] || st.Totals[1] != want[1] {
+		t.Errorf("got totals %+v, want %+v", st.Totals, want)
+	}
+	if st.TotalDebit() != money.FromMajor(30) || st.TotalCredit() != money.FromMajor(50) {
+		t.Errorf("got total debit %s credit %s, want 30.00 and 50.00", st.TotalDebit(), st.TotalCredit())
+	}
+}
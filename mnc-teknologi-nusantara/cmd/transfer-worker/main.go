@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mnctech-restapi/cmd/rest-api/broker"
+	"mnctech-restapi/cmd/rest-api/config"
+	"mnctech-restapi/cmd/rest-api/currency"
+	"mnctech-restapi/cmd/rest-api/database"
+	"mnctech-restapi/cmd/rest-api/handlers"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// transfer-worker credits the targets of transfers queued by the Rest API.
+func main() {
+	db := database.ConnectDB()
+	defer database.CloseDBConnection(db)
+
+	messageBroker, err := broker.Connect()
+	if err != nil {
+		log.Fatalf("Failed to connect to the broker: %v", err)
+	}
+	defer messageBroker.Close()
+
+	policy := broker.DefaultRetryPolicy()
+	policy.MaxAttempts = config.GetEnvInt("TRANSFER_MAX_ATTEMPTS", policy.MaxAttempts)
+	policy.Delay = config.GetEnvDuration("TRANSFER_RETRY_DELAY", policy.Delay)
+
+	limits := handlers.DefaultLimitPolicy()
+	if path := os.Getenv("LIMITS_FILE"); path != "" {
+		if limits, err = handlers.LoadLimitPolicy(path); err != nil {
+			log.Fatalf("Failed to load limits: %v", err)
+		}
+	}
+
+	appHandler := &handlers.AppHandler{DB: db, Limits: limits}
+
+	// Balances in other currencies count towards the maximum balance at the mid rate
+	if path := os.Getenv("FX_RATES_FILE"); path != "" {
+		rates, err := currency.LoadStaticRates(path)
+		if err != nil {
+			log.Fatalf("Failed to load exchange rates: %v", err)
+		}
+		appHandler.FxRates = rates
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Transfers whose messages failed every attempt are refunded to their senders,
+	// retrying until the refund succeeds
+	deadLetterQueue := broker.DeadLetterQueue(handlers.TransferQueue)
+	go func() {
+		log.Printf("Consuming %q", deadLetterQueue)
+		if err := messageBroker.Consume(ctx, deadLetterQueue, handlers.DeadTransferRetryPolicy(policy), appHandler.FailDeadTransfer); err != nil && !errors.Is(err, context.Canceled) {
+			log.Fatalf("Stopped consuming %q: %v", deadLetterQueue, err)
+		}
+	}()
+
+	log.Printf("Consuming %q", handlers.TransferQueue)
+	if err := messageBroker.Consume(ctx, handlers.TransferQueue, policy, appHandler.ProcessTransfer); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatalf("Stopped consuming: %v", err)
+	}
+	log.Println("Transfer worker stopped")
+}